@@ -1,40 +1,298 @@
 package configuration
 
 import (
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/session"
 )
 
 // Configuration contains the configuration of the application.
 type Configuration struct {
 	// SessionEncryptionKey is used to encrypt the user session details. It should be 32 bytes of random data.
+	// Separate authentication and encryption keys are derived from it.
 	SessionEncryptionKey []byte
+	// SessionEncryptionKeys is a keyring, ordered newest first, which allows keys to be rotated without
+	// logging users out. Sessions are encrypted with the newest key and can be decrypted with any of them.
+	// When loaded from the environment, the first key is also used as the SessionEncryptionKey.
+	SessionEncryptionKeys [][]byte
+	// SessionAcceptSignedOnlyCookies allows the signed, but unencrypted, session cookies issued by
+	// earlier versions to be used while migrating to encrypted cookies.
+	SessionAcceptSignedOnlyCookies bool
+	// MaxSessionsPerUser limits the number of concurrent sessions each user can have. Zero means
+	// no limit. Only supported when sessions are held on the server.
+	MaxSessionsPerUser int
+	// SessionLimitPolicy is what happens when a user with MaxSessionsPerUser sessions logs in:
+	// "evict-oldest" (the default) ends their oldest session, "refuse" refuses the login.
+	SessionLimitPolicy string
+	// SessionBinding ties cookie sessions to the network and browser of the client which started
+	// them: "off" (the default), "log" logs a security event when the client changes, and
+	// "enforce" also requires the user to log in again.
+	SessionBinding string
+	// SessionBindingIPv4PrefixLength is the number of leading bits of the client's IPv4 address
+	// which must stay the same. Zero disables the check. If nil, the session package's default
+	// is used.
+	SessionBindingIPv4PrefixLength *int
+	// SessionBindingIPv6PrefixLength is the number of leading bits of the client's IPv6 address
+	// which must stay the same. Zero disables the check. If nil, the session package's default
+	// is used.
+	SessionBindingIPv6PrefixLength *int
+	// SessionBindingIgnoreUserAgent allows the browser to change during a bound session.
+	SessionBindingIgnoreUserAgent bool
+	// SessionBindingRequireBound treats sessions started before binding was enabled as if they
+	// were used by a different client, instead of binding them to the first client to use them.
+	SessionBindingRequireBound bool
+	// TrustedProxies are the networks of load balancers and reverse proxies, whose
+	// X-Forwarded-For headers are used to find the address of the client.
+	TrustedProxies []*net.IPNet
+	// AllowedOrigins are the origins, e.g. "https://example.com", which the logout and admin
+	// forms can be posted from. If empty, they must be posted from the host the request was
+	// sent to, which doesn't work behind proxies which rewrite the Host header.
+	AllowedOrigins []string
 	// CookieName is the name of the session cookie.
 	CookieName string
+	// SessionStore is where sessions are held: "cookie" (the default) holds the whole session in
+	// an encrypted cookie, "memory", "file", "sql" or "redis" hold it on the server, and the cookie only
+	// contains a random session ID.
+	SessionStore string
+	// SessionStoreDir is the directory used by the "file" session store.
+	SessionStoreDir string
+	// SessionStoreDriver is the database/sql driver used by the "sql" session store, e.g. "postgres".
+	// The driver must be imported by the application.
+	SessionStoreDriver string
+	// SessionStoreDSN is the data source name used by the "sql" session store.
+	SessionStoreDSN string
+	// SessionStoreRedisURL is the server used by the "redis" session store, in the form
+	// redis://[:password@]host[:port][/db].
+	SessionStoreRedisURL string
+	// SessionRevocationFile is a file listing revoked sessions, which is checked by the "cookie"
+	// session store and reloaded when it changes. See session.NewFileRevocationList for the format.
+	SessionRevocationFile string
+	// SessionLifetime is the maximum time since logging in, after which users must log in
+	// again. If zero, the session package's default is used.
+	SessionLifetime time.Duration
+	// SessionIdleTimeout is how long a session lasts without being used. If zero, the
+	// session package's default is used.
+	SessionIdleTimeout time.Duration
+	// SessionRenewAfter is how long after a session cookie was issued that it is re-issued,
+	// extending the idle timeout. If zero, the session package's default is used.
+	SessionRenewAfter time.Duration
 	// SetSecureFlag sets whether cookies should be issued with the secure flag set.
 	// When the secure flag is set, cookies cannot be transmitted over HTTP.
 	// SSL must already be in place before this option is set.
 	SetSecureFlag bool
+	// CookiePath is the path the session cookie is sent to. Defaults to "/".
+	CookiePath string
+	// CookieDomain allows the session cookie to be sent to subdomains. If empty, it's only sent
+	// to the host which issued it.
+	CookieDomain string
+	// CookieSameSite is the SameSite attribute of the session cookie. Defaults to Lax.
+	CookieSameSite http.SameSite
+	// CookieMaxAge caps how long the browser keeps the session cookie. If zero, the cookie is
+	// kept until the session expires. If negative, it's deleted when the browser is closed.
+	CookieMaxAge time.Duration
+	// CookieHostPrefix prepends "__Host-" to the CookieName, so that browsers only accept the
+	// cookie if it's Secure, has a Path of "/" and no Domain. It can't be set by subdomains.
+	CookieHostPrefix bool
 	// GoogleAuthClientID is required to enable authentication.
 	GoogleAuthClientID string
+	// GoogleSignInLegacy uses the deprecated Google Sign-In JavaScript platform library for
+	// the login screen, instead of Google Identity Services. It's only available to clients
+	// which were already using it.
+	GoogleSignInLegacy bool
+	// AuthorizationCodeFlow signs users in by redirecting them to the authorization endpoint
+	// and exchanging the code they return with, using PKCE. No JavaScript is used.
+	AuthorizationCodeFlow bool
+	// GoogleAuthClientSecret is used to exchange authorization codes. It's required by the
+	// AuthorizationCodeFlow.
+	GoogleAuthClientSecret string
+	// OIDCAuthorizationEndpoint is where users are sent to sign in. Defaults to Google's.
+	OIDCAuthorizationEndpoint string
+	// OIDCTokenEndpoint is where authorization codes are exchanged. Defaults to Google's.
+	OIDCTokenEndpoint string
+	// OIDCJWKSURL is where the keys which sign ID tokens are published. Defaults to Google's.
+	OIDCJWKSURL string
+	// OIDCIssuer is the value of the iss claim of ID tokens issued by the provider. If empty,
+	// Google's issuers are accepted.
+	OIDCIssuer string
+	// OIDCRedirectURL is the absolute URL of the callback, which must be registered with the
+	// authorization server. If empty, it's derived from the host of each request and the
+	// CallbackPath.
+	OIDCRedirectURL string
+	// OIDCScopes are requested in addition to "openid email profile", e.g. to read the user's
+	// calendar.
+	OIDCScopes []string
+	// OIDCOfflineAccess requests a refresh token when the user signs in, which is kept,
+	// encrypted, on the server, so that the next handler can get an access token for the
+	// user with AccessTokenFromContext. Requires the AuthorizationCodeFlow, and the "memory"
+	// SessionStore, since the tokens are held in memory, and would be lost by sessions which
+	// outlive the process or are shared with other instances.
+	OIDCOfflineAccess bool
+	// GoogleAcceptedClientIDs are the client IDs which ID tokens may be issued for, e.g. the
+	// web client plus any mobile clients. It includes GoogleAuthClientID by default.
+	GoogleAcceptedClientIDs []string
 	// GoogleAllowedDomains are Google GSuite domains which are permitted to access the content.
 	GoogleAllowedDomains []string
+	// CallbackPath is the path reserved for receiving the ID token from the login screen.
+	// Defaults to "/_gauth/callback".
+	CallbackPath string
+	// LogoutPath is the path reserved for logging the user out. Defaults to "/_gauth/logout".
+	LogoutPath string
+	// LogoutRedirectURL is where users are sent after logging out. If it's not set, a
+	// "you are signed out" screen is shown.
+	LogoutRedirectURL string
+	// AdminEmails are the email addresses of the users who can list and revoke sessions on the
+	// admin page. The admin page is only available when sessions are held on the server.
+	AdminEmails []string
+	// AdminPath is the path of the admin page. Defaults to "/_gauth/admin".
+	AdminPath string
+	// GoogleRevocationURL is the endpoint used to revoke the user's Google grant when they
+	// log out, e.g. "https://oauth2.googleapis.com/revoke". If it's not set, the grant is not
+	// revoked.
+	GoogleRevocationURL string
 }
 
 // FromEnvironment loads the configuration using environment variables.
 func FromEnvironment() (c Configuration, err error) {
 	var errs []string
 
-	c.SessionEncryptionKey, err = base64.StdEncoding.DecodeString(os.Getenv("SESSION_ENCRYPTION_KEY"))
-	if err != nil {
-		errs = append(errs, fmt.Sprintf("SESSION_ENCRYPTION_KEY: not found, or invalid: %v", err))
+	c.SessionEncryptionKeys, errs = keyringFromEnvironment(errs)
+	if len(c.SessionEncryptionKeys) > 0 {
+		c.SessionEncryptionKey = c.SessionEncryptionKeys[0]
+	}
+
+	if v := os.Getenv("SESSION_ACCEPT_SIGNED_ONLY_COOKIES"); v != "" {
+		c.SessionAcceptSignedOnlyCookies, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("SESSION_ACCEPT_SIGNED_ONLY_COOKIES: invalid value: '%v'", v))
+		}
+	}
+
+	c.SessionStore = os.Getenv("SESSION_STORE")
+	switch c.SessionStore {
+	case "", "cookie", "memory":
+	case "file":
+		c.SessionStoreDir = os.Getenv("SESSION_STORE_DIR")
+		if c.SessionStoreDir == "" {
+			errs = append(errs, fmt.Sprintf("SESSION_STORE_DIR: not set"))
+		}
+	case "sql":
+		c.SessionStoreDriver = os.Getenv("SESSION_STORE_DRIVER")
+		if !contains(sql.Drivers(), c.SessionStoreDriver) {
+			errs = append(errs, fmt.Sprintf("SESSION_STORE_DRIVER: '%v' is not a registered database/sql driver, it must be imported by the application", c.SessionStoreDriver))
+		}
+		c.SessionStoreDSN = os.Getenv("SESSION_STORE_DSN")
+		if c.SessionStoreDSN == "" {
+			errs = append(errs, fmt.Sprintf("SESSION_STORE_DSN: not set"))
+		}
+	case "redis":
+		c.SessionStoreRedisURL = os.Getenv("SESSION_STORE_REDIS_URL")
+		if u, err := url.Parse(c.SessionStoreRedisURL); err != nil || u.Scheme != "redis" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("SESSION_STORE_REDIS_URL: expected a URL in the form redis://[:password@]host[:port][/db], got '%v'", c.SessionStoreRedisURL))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("SESSION_STORE: expected 'cookie', 'memory', 'file', 'sql' or 'redis', got '%v'", c.SessionStore))
+	}
+
+	if v := os.Getenv("MAX_SESSIONS_PER_USER"); v != "" {
+		c.MaxSessionsPerUser, err = strconv.Atoi(v)
+		if err != nil || c.MaxSessionsPerUser < 0 {
+			errs = append(errs, fmt.Sprintf("MAX_SESSIONS_PER_USER: invalid value: '%v'", v))
+		}
+		if c.SessionStore == "" || c.SessionStore == "cookie" {
+			errs = append(errs, fmt.Sprintf("MAX_SESSIONS_PER_USER: session limits require sessions to be held on the server, set SESSION_STORE"))
+		}
+	}
+	c.SessionLimitPolicy = os.Getenv("SESSION_LIMIT_POLICY")
+	switch c.SessionLimitPolicy {
+	case "", "evict-oldest", "refuse":
+	default:
+		errs = append(errs, fmt.Sprintf("SESSION_LIMIT_POLICY: expected 'evict-oldest' or 'refuse', got '%v'", c.SessionLimitPolicy))
+	}
+
+	c.SessionBinding = os.Getenv("SESSION_BINDING")
+	switch c.SessionBinding {
+	case "", "off", "log", "enforce":
+	default:
+		errs = append(errs, fmt.Sprintf("SESSION_BINDING: expected 'off', 'log' or 'enforce', got '%v'", c.SessionBinding))
+	}
+	c.SessionBindingIPv4PrefixLength, errs = prefixLengthFromEnvironment("SESSION_BINDING_IPV4_PREFIX", 32, errs)
+	c.SessionBindingIPv6PrefixLength, errs = prefixLengthFromEnvironment("SESSION_BINDING_IPV6_PREFIX", 128, errs)
+	if v := os.Getenv("SESSION_BINDING_IGNORE_USER_AGENT"); v != "" {
+		c.SessionBindingIgnoreUserAgent, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("SESSION_BINDING_IGNORE_USER_AGENT: invalid value: '%v'", v))
+		}
+	}
+	if v := os.Getenv("SESSION_BINDING_REQUIRE_BOUND"); v != "" {
+		c.SessionBindingRequireBound, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("SESSION_BINDING_REQUIRE_BOUND: invalid value: '%v'", v))
+		}
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			cidr := p
+			if !strings.Contains(p, "/") {
+				if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+					cidr += "/32"
+				} else {
+					cidr += "/128"
+				}
+			}
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("TRUSTED_PROXIES: invalid address or network: '%v'", p))
+				continue
+			}
+			c.TrustedProxies = append(c.TrustedProxies, n)
+		}
 	}
-	if len(c.SessionEncryptionKey) != 32 {
-		errs = append(errs, fmt.Sprintf("SESSION_ENCRYPTION_KEY: expected 32 bytes when base64 decoded, got %d bytes", len(c.SessionEncryptionKey)))
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		for _, o := range strings.Split(v, ",") {
+			o = strings.TrimSpace(o)
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+				errs = append(errs, fmt.Sprintf("ALLOWED_ORIGINS: invalid origin, expected e.g. 'https://example.com': '%v'", o))
+				continue
+			}
+			c.AllowedOrigins = append(c.AllowedOrigins, o)
+		}
+	}
+
+	c.SessionRevocationFile = os.Getenv("SESSION_REVOCATION_FILE")
+	if c.SessionRevocationFile != "" {
+		if c.SessionStore != "" && c.SessionStore != "cookie" {
+			errs = append(errs, fmt.Sprintf("SESSION_REVOCATION_FILE: only used by the 'cookie' session store, server-side sessions are revoked by deleting them"))
+		} else if _, err := os.Stat(c.SessionRevocationFile); err != nil {
+			errs = append(errs, fmt.Sprintf("SESSION_REVOCATION_FILE: %v", err))
+		}
+	}
+
+	if v := os.Getenv("ADMIN_EMAILS"); v != "" {
+		for _, email := range strings.Split(v, ",") {
+			if email = strings.TrimSpace(email); email != "" {
+				c.AdminEmails = append(c.AdminEmails, email)
+			}
+		}
+		if c.SessionStore == "" || c.SessionStore == "cookie" {
+			errs = append(errs, fmt.Sprintf("ADMIN_EMAILS: the admin page requires sessions to be held on the server, set SESSION_STORE"))
+		}
+	}
+	c.AdminPath = os.Getenv("ADMIN_PATH")
+	if c.AdminPath != "" && !strings.HasPrefix(c.AdminPath, "/") {
+		errs = append(errs, fmt.Sprintf("ADMIN_PATH: must start with '/', got '%v'", c.AdminPath))
 	}
 
 	c.CookieName = os.Getenv("COOKIE_NAME")
@@ -42,16 +300,106 @@ func FromEnvironment() (c Configuration, err error) {
 		errs = append(errs, fmt.Sprintf("COOKIE_NAME: not set"))
 	}
 
+	c.SessionLifetime, errs = durationFromEnvironment("SESSION_LIFETIME", errs)
+	c.SessionIdleTimeout, errs = durationFromEnvironment("SESSION_IDLE_TIMEOUT", errs)
+	c.SessionRenewAfter, errs = durationFromEnvironment("SESSION_RENEW_AFTER", errs)
+
 	c.SetSecureFlag, err = strconv.ParseBool(os.Getenv("SET_SECURE_FLAG"))
 	if err != nil {
 		errs = append(errs, fmt.Sprintf("SET_SECURE_FLAG: not set or invalid value: '%v'", os.Getenv("SET_SECURE_FLAG")))
 	}
 
+	c.CookiePath = os.Getenv("COOKIE_PATH")
+	if c.CookiePath != "" && !strings.HasPrefix(c.CookiePath, "/") {
+		errs = append(errs, fmt.Sprintf("COOKIE_PATH: must start with '/', got '%v'", c.CookiePath))
+	}
+	c.CookieDomain = os.Getenv("COOKIE_DOMAIN")
+	switch v := os.Getenv("COOKIE_SAMESITE"); strings.ToLower(v) {
+	case "", "lax":
+		c.CookieSameSite = http.SameSiteLaxMode
+	case "strict":
+		c.CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		c.CookieSameSite = http.SameSiteNoneMode
+	default:
+		errs = append(errs, fmt.Sprintf("COOKIE_SAMESITE: expected 'lax', 'strict' or 'none', got '%v'", v))
+	}
+	if v := os.Getenv("COOKIE_MAX_AGE"); v == "session" {
+		c.CookieMaxAge = -1
+	} else {
+		c.CookieMaxAge, errs = durationFromEnvironment("COOKIE_MAX_AGE", errs)
+	}
+	if v := os.Getenv("COOKIE_HOST_PREFIX"); v != "" {
+		c.CookieHostPrefix, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("COOKIE_HOST_PREFIX: invalid value: '%v'", v))
+		}
+	}
+	if c.CookieHostPrefix && !strings.HasPrefix(c.CookieName, session.HostPrefix) {
+		c.CookieName = session.HostPrefix + c.CookieName
+	}
+	if err := session.ValidateCookie(c.CookieName, c.CookieOptions()); err != nil {
+		errs = append(errs, fmt.Sprintf("COOKIE_NAME: %v, check SET_SECURE_FLAG, COOKIE_PATH, COOKIE_DOMAIN and COOKIE_SAMESITE", err))
+	}
+
 	c.GoogleAuthClientID = os.Getenv("GOOGLE_AUTH_CLIENT_ID")
 	if c.GoogleAuthClientID == "" {
 		errs = append(errs, fmt.Sprintf("GOOGLE_AUTH_CLIENT_ID: not set"))
 	}
 
+	if v := os.Getenv("GOOGLE_SIGN_IN_LEGACY"); v != "" {
+		c.GoogleSignInLegacy, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("GOOGLE_SIGN_IN_LEGACY: invalid value: '%v'", v))
+		}
+	}
+	if v := os.Getenv("AUTHORIZATION_CODE_FLOW"); v != "" {
+		c.AuthorizationCodeFlow, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("AUTHORIZATION_CODE_FLOW: invalid value: '%v'", v))
+		}
+	}
+	if c.AuthorizationCodeFlow {
+		if c.GoogleSignInLegacy {
+			errs = append(errs, fmt.Sprintf("AUTHORIZATION_CODE_FLOW: can't be used with GOOGLE_SIGN_IN_LEGACY"))
+		}
+		c.GoogleAuthClientSecret = os.Getenv("GOOGLE_AUTH_CLIENT_SECRET")
+		if c.GoogleAuthClientSecret == "" {
+			errs = append(errs, fmt.Sprintf("GOOGLE_AUTH_CLIENT_SECRET: not set, it's required by AUTHORIZATION_CODE_FLOW"))
+		}
+		c.OIDCAuthorizationEndpoint, errs = urlFromEnvironment("OIDC_AUTHORIZATION_ENDPOINT", errs)
+		c.OIDCTokenEndpoint, errs = urlFromEnvironment("OIDC_TOKEN_ENDPOINT", errs)
+		c.OIDCJWKSURL, errs = urlFromEnvironment("OIDC_JWKS_URL", errs)
+		c.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+		c.OIDCRedirectURL, errs = urlFromEnvironment("OIDC_REDIRECT_URL", errs)
+	}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		c.OIDCScopes = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		if !c.AuthorizationCodeFlow {
+			errs = append(errs, fmt.Sprintf("OIDC_SCOPES: requires AUTHORIZATION_CODE_FLOW"))
+		}
+	}
+	if v := os.Getenv("OIDC_OFFLINE_ACCESS"); v != "" {
+		c.OIDCOfflineAccess, err = strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("OIDC_OFFLINE_ACCESS: invalid value: '%v'", v))
+		}
+		if c.OIDCOfflineAccess && !c.AuthorizationCodeFlow {
+			errs = append(errs, fmt.Sprintf("OIDC_OFFLINE_ACCESS: requires AUTHORIZATION_CODE_FLOW"))
+		}
+		if c.OIDCOfflineAccess && c.SessionStore != "memory" {
+			errs = append(errs, fmt.Sprintf("OIDC_OFFLINE_ACCESS: requires SESSION_STORE to be 'memory', since the tokens are held in memory, got '%v'", c.SessionStore))
+		}
+	}
+	c.GoogleAcceptedClientIDs = []string{c.GoogleAuthClientID}
+	if gaci := os.Getenv("GOOGLE_ACCEPTED_CLIENT_IDS"); gaci != "" {
+		for _, id := range strings.Split(gaci, ",") {
+			if id = strings.TrimSpace(id); id != "" && id != c.GoogleAuthClientID {
+				c.GoogleAcceptedClientIDs = append(c.GoogleAcceptedClientIDs, id)
+			}
+		}
+	}
+
 	gad := os.Getenv("GOOGLE_ALLOWED_DOMAINS")
 	if gad != "*" {
 		c.GoogleAllowedDomains = strings.Split(gad, ",")
@@ -60,9 +408,138 @@ func FromEnvironment() (c Configuration, err error) {
 		}
 	}
 
+	c.CallbackPath = os.Getenv("CALLBACK_PATH")
+	if c.CallbackPath != "" && !strings.HasPrefix(c.CallbackPath, "/") {
+		errs = append(errs, fmt.Sprintf("CALLBACK_PATH: must start with '/', got '%v'", c.CallbackPath))
+	}
+
+	c.LogoutPath = os.Getenv("LOGOUT_PATH")
+	if c.LogoutPath != "" && !strings.HasPrefix(c.LogoutPath, "/") {
+		errs = append(errs, fmt.Sprintf("LOGOUT_PATH: must start with '/', got '%v'", c.LogoutPath))
+	}
+	c.LogoutRedirectURL = os.Getenv("LOGOUT_REDIRECT_URL")
+	c.GoogleRevocationURL = os.Getenv("GOOGLE_REVOCATION_URL")
+
 	if len(errs) > 0 {
 		err = errors.New(strings.Join(errs, ", "))
 	}
 
 	return
 }
+
+// durationFromEnvironment reads an optional duration, e.g. "12h", from an environment variable.
+func durationFromEnvironment(name string, errs []string) (time.Duration, []string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, errs
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, append(errs, fmt.Sprintf("%s: invalid duration: '%v'", name, v))
+	}
+	return d, errs
+}
+
+// urlFromEnvironment reads an optional absolute URL from an environment variable.
+func urlFromEnvironment(name string, errs []string) (string, []string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return "", errs
+	}
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", append(errs, fmt.Sprintf("%s: expected an absolute URL, got '%v'", name, v))
+	}
+	return v, errs
+}
+
+// keyringFromEnvironment loads the session encryption keys, newest first, from the
+// SESSION_ENCRYPTION_KEY, SESSION_ENCRYPTION_KEYS and SESSION_ENCRYPTION_KEYS_FILE
+// environment variables.
+func keyringFromEnvironment(errs []string) (keys [][]byte, _ []string) {
+	type source struct {
+		name string
+		keys []string
+	}
+	var sources []source
+	if v := os.Getenv("SESSION_ENCRYPTION_KEY"); v != "" {
+		sources = append(sources, source{"SESSION_ENCRYPTION_KEY", []string{v}})
+	}
+	if v := os.Getenv("SESSION_ENCRYPTION_KEYS"); v != "" {
+		sources = append(sources, source{"SESSION_ENCRYPTION_KEYS", strings.Split(v, ",")})
+	}
+	if fn := os.Getenv("SESSION_ENCRYPTION_KEYS_FILE"); fn != "" {
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("SESSION_ENCRYPTION_KEYS_FILE: failed to read file: %v", err))
+		}
+		var fileKeys []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				fileKeys = append(fileKeys, line)
+			}
+		}
+		sources = append(sources, source{"SESSION_ENCRYPTION_KEYS_FILE", fileKeys})
+	}
+	if len(sources) == 0 {
+		return nil, append(errs, "SESSION_ENCRYPTION_KEY: not set")
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range sources {
+		for i, k := range s.keys {
+			k = strings.TrimSpace(k)
+			key, err := base64.StdEncoding.DecodeString(k)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: key %d is invalid: %v", s.name, i+1, err))
+				continue
+			}
+			if len(key) != 32 {
+				errs = append(errs, fmt.Sprintf("%s: key %d: expected 32 bytes when base64 decoded, got %d bytes", s.name, i+1, len(key)))
+				continue
+			}
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, errs
+}
+
+// CookieOptions returns the attributes of the session cookie.
+func (c Configuration) CookieOptions() session.CookieOptions {
+	o := session.DefaultCookieOptions(c.SetSecureFlag)
+	if c.CookiePath != "" {
+		o.Path = c.CookiePath
+	}
+	o.Domain = c.CookieDomain
+	if c.CookieSameSite != 0 {
+		o.SameSite = c.CookieSameSite
+	}
+	o.MaxAge = int(c.CookieMaxAge.Seconds())
+	if c.CookieMaxAge < 0 {
+		o.MaxAge = -1
+	}
+	return o
+}
+
+func prefixLengthFromEnvironment(name string, max int, errs []string) (*int, []string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, errs
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > max {
+		return nil, append(errs, fmt.Sprintf("%s: expected a prefix length between 0 and %d, got '%v'", name, max, v))
+	}
+	return &n, errs
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,14 @@
 package gauthmiddleware
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
 
 	"github.com/a-h/gauthmiddleware/configuration"
+	"github.com/a-h/gauthmiddleware/handlers/admin"
 	"github.com/a-h/gauthmiddleware/handlers/login"
+	"github.com/a-h/gauthmiddleware/oidc"
 	"github.com/a-h/gauthmiddleware/session"
 	"github.com/a-h/gauthmiddleware/templates"
 	"github.com/a-h/gauthmiddleware/tokenverifier"
@@ -16,20 +20,259 @@ func New(next http.Handler) (h http.Handler, err error) {
 	if err != nil {
 		return
 	}
-	h = NewWithConfiguration(conf, next)
-	return
+	return NewFromConfiguration(conf, next)
 }
 
-// NewWithConfiguration starts up the GAuth middleware using the provided configuration.
+// NewWithConfiguration starts up the GAuth middleware using the provided configuration. It
+// panics if the session store can't be created, use NewFromConfiguration to handle the
+// error instead.
 func NewWithConfiguration(conf configuration.Configuration, next http.Handler) http.Handler {
-	session := session.NewGorillaSession(conf.SessionEncryptionKey, conf.SetSecureFlag, conf.CookieName)
-	lr := func(w http.ResponseWriter, r *http.Request) {
+	h, err := NewFromConfiguration(conf, next)
+	if err != nil {
+		panic(err.Error())
+	}
+	return h
+}
+
+// NewFromConfiguration starts up the GAuth middleware using the provided configuration. An
+// error is returned if the session store can't be created, e.g. because the database or
+// Redis server can't be reached, or the revocation list can't be read.
+func NewFromConfiguration(conf configuration.Configuration, next http.Handler) (http.Handler, error) {
+	keys := conf.SessionEncryptionKeys
+	if len(keys) == 0 {
+		keys = [][]byte{conf.SessionEncryptionKey}
+	}
+	callbackPath := conf.CallbackPath
+	if callbackPath == "" {
+		callbackPath = login.DefaultCallbackPath
+	}
+	lr := func(w http.ResponseWriter, r *http.Request, p login.Page) {
 		templates.RenderLogin(w, templates.LoginModel{
 			GoogleAuthClientID: conf.GoogleAuthClientID,
+			GoogleSignInLegacy: conf.GoogleSignInLegacy,
+			CallbackPath:       p.CallbackPath,
+			State:              p.State,
+			LoginURI:           p.LoginURI,
+			Nonce:              p.Nonce,
+			SignInURL:          p.SignInURL,
+			Message:            p.Message,
+			CSRFToken:          p.CSRFToken,
+		})
+	}
+	clientIDs := conf.GoogleAcceptedClientIDs
+	if len(clientIDs) == 0 {
+		clientIDs = []string{conf.GoogleAuthClientID}
+	}
+	jwksURL := tokenverifier.GoogleJWKSURL
+	if conf.OIDCJWKSURL != "" {
+		jwksURL = conf.OIDCJWKSURL
+	}
+	tv := tokenverifier.NewJWKSTokenVerifier(jwksURL, clientIDs, conf.GoogleAllowedDomains)
+	if conf.OIDCIssuer != "" {
+		tv.Issuers = []string{conf.OIDCIssuer}
+	}
+	var client *oidc.Client
+	var tokens *oidc.Tokens
+	if conf.AuthorizationCodeFlow {
+		client = newOIDCClient(conf)
+		if conf.OIDCOfflineAccess {
+			tokens = oidc.NewTokens(client, oidc.NewMemoryTokenStore(), keys[0])
+			// Tokens are kept for as long as the session can last.
+			tokens.Lifetime = session.DefaultLifetime.Absolute
+			if conf.SessionLifetime > 0 {
+				tokens.Lifetime = conf.SessionLifetime
+			}
+		}
+	}
+	s, store, err := newSession(conf, keys, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil && len(conf.AdminEmails) > 0 {
+		next = withAdmin(conf, store, next)
+	}
+	h := login.NewHandler(s, tv, lr, next)
+	h.CallbackPath = callbackPath
+	h.State = login.NewState(keys[0], login.DefaultStateMaxAge)
+	h.ReplayCache = tokenverifier.NewReplayCache()
+	h.Tokens = tokens
+	switch {
+	case conf.AuthorizationCodeFlow:
+		// The state of the login attempt is held in its own cookie, which also protects the
+		// callback from CSRF.
+		h.CodeFlow = login.NewCodeFlow(client, keys[0], login.DefaultCodeFlowMaxAge, conf.SetSecureFlag)
+	case conf.GoogleSignInLegacy:
+		// The legacy Google Sign-In library can't send a nonce, or its own CSRF token.
+		h.CSRF = login.NewCSRF(keys[0], login.DefaultCSRFMaxAge, conf.SetSecureFlag)
+	default:
+		h.Nonce = login.NewNonce(keys[0], login.DefaultNonceMaxAge, conf.SetSecureFlag)
+	}
+	if conf.LogoutPath != "" {
+		h.LogoutPath = conf.LogoutPath
+	}
+	h.LogoutRedirectURL = conf.LogoutRedirectURL
+	h.RenderLoggedOut = func(w http.ResponseWriter, r *http.Request) {
+		templates.RenderLoggedOut(w, templates.LoggedOutModel{
+			GoogleAuthClientID: conf.GoogleAuthClientID,
+			GoogleSignInLegacy: conf.GoogleSignInLegacy,
+			LoginURL:           "/",
+		})
+	}
+	h.RenderError = func(w http.ResponseWriter, r *http.Request, status int, message string) {
+		templates.RenderError(w, status, templates.ErrorModel{
+			GoogleAuthClientID: conf.GoogleAuthClientID,
+			Message:            message,
+			LoginURL:           "/",
+		})
+	}
+	if conf.GoogleRevocationURL != "" {
+		h.Revoker = login.NewRevoker(conf.GoogleRevocationURL)
+	}
+	h.AllowedOrigins = conf.AllowedOrigins
+	return h, nil
+}
+
+// newOIDCClient creates the client used by the authorization code flow, defaulting to
+// Google's endpoints.
+func newOIDCClient(conf configuration.Configuration) *oidc.Client {
+	c := oidc.Config{
+		ClientID:              conf.GoogleAuthClientID,
+		ClientSecret:          conf.GoogleAuthClientSecret,
+		AuthorizationEndpoint: oidc.GoogleAuthorizationEndpoint,
+		TokenEndpoint:         oidc.GoogleTokenEndpoint,
+		RedirectURL:           conf.OIDCRedirectURL,
+		Offline:               conf.OIDCOfflineAccess,
+	}
+	if len(conf.OIDCScopes) > 0 {
+		c.Scopes = append(append([]string{}, oidc.DefaultScopes...), conf.OIDCScopes...)
+	}
+	if conf.OIDCAuthorizationEndpoint != "" {
+		c.AuthorizationEndpoint = conf.OIDCAuthorizationEndpoint
+	}
+	if conf.OIDCTokenEndpoint != "" {
+		c.TokenEndpoint = conf.OIDCTokenEndpoint
+	}
+	return oidc.NewClient(c)
+}
+
+// withAdmin serves the admin page to logged in users, and passes other requests to next.
+func withAdmin(conf configuration.Configuration, store session.SessionStore, next http.Handler) http.Handler {
+	logoutPath := conf.LogoutPath
+	if logoutPath == "" {
+		logoutPath = login.DefaultLogoutPath
+	}
+	ah := admin.NewHandler(store, conf.AdminEmails, func(w http.ResponseWriter, r *http.Request, p admin.Page) {
+		model := templates.AdminModel{
+			GoogleAuthClientID: conf.GoogleAuthClientID,
+			Path:               p.Path,
+			LogoutPath:         logoutPath,
+		}
+		for _, s := range p.Sessions {
+			model.Sessions = append(model.Sessions, templates.AdminSession{
+				Handle:    s.Handle,
+				UserID:    s.UserID,
+				Email:     s.Email,
+				Name:      s.Name,
+				IP:        s.IP,
+				UserAgent: s.UserAgent,
+				Created:   s.Created,
+				LastSeen:  s.LastSeen,
+			})
+		}
+		templates.RenderAdmin(w, model)
+	})
+	if conf.AdminPath != "" {
+		ah.Path = conf.AdminPath
+	}
+	ah.AllowedOrigins = conf.AllowedOrigins
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ah.IsAdminPath(r) {
+			ah.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newSession creates the Session selected by the configuration. If sessions are held on
+// the server, the store is also returned. If tokens are kept, they're deleted along with
+// the session.
+func newSession(conf configuration.Configuration, keys [][]byte, tokens *oidc.Tokens) (session.Session, session.SessionStore, error) {
+	lifetime := session.DefaultLifetime
+	if conf.SessionLifetime > 0 {
+		lifetime.Absolute = conf.SessionLifetime
+	}
+	if conf.SessionIdleTimeout > 0 {
+		lifetime.Idle = conf.SessionIdleTimeout
+	}
+	if conf.SessionRenewAfter > 0 {
+		lifetime.RenewAfter = conf.SessionRenewAfter
+	}
+
+	var store session.SessionStore
+	switch conf.SessionStore {
+	case "memory":
+		store = session.NewMemoryStore()
+	case "file":
+		store = session.NewFileStore(conf.SessionStoreDir)
+	case "sql":
+		db, err := sql.Open(conf.SessionStoreDriver, conf.SessionStoreDSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gauthmiddleware: failed to open session database: %v", err)
+		}
+		store = session.NewSQLStore(db, conf.SessionStoreDriver)
+	case "redis":
+		rs, err := session.NewRedisStore(conf.SessionStoreRedisURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gauthmiddleware: failed to create Redis session store: %v", err)
+		}
+		store = rs
+	default:
+		gs := session.NewGorillaSessionWithKeys(keys, conf.SetSecureFlag, conf.CookieName)
+		gs.CookieOptions = conf.CookieOptions()
+		gs.AcceptSignedOnlyCookies = conf.SessionAcceptSignedOnlyCookies
+		gs.Lifetime = lifetime
+		gs.Binding.TrustedProxies = conf.TrustedProxies
+		gs.Binding.CheckUserAgent = !conf.SessionBindingIgnoreUserAgent
+		gs.Binding.RequireBound = conf.SessionBindingRequireBound
+		switch conf.SessionBinding {
+		case "log":
+			gs.Binding.Mode = session.BindingLogOnly
+		case "enforce":
+			gs.Binding.Mode = session.BindingEnforced
+		}
+		if conf.SessionBindingIPv4PrefixLength != nil {
+			gs.Binding.IPv4PrefixLength = *conf.SessionBindingIPv4PrefixLength
+		}
+		if conf.SessionBindingIPv6PrefixLength != nil {
+			gs.Binding.IPv6PrefixLength = *conf.SessionBindingIPv6PrefixLength
+		}
+		if conf.SessionRevocationFile != "" {
+			rl, err := session.NewFileRevocationList(conf.SessionRevocationFile, session.DefaultRevocationReloadInterval)
+			if err != nil {
+				return nil, nil, fmt.Errorf("gauthmiddleware: failed to load session revocation list: %v", err)
+			}
+			gs.Revocations = rl
+		}
+		return gs, nil, nil
+	}
+	if tokens != nil {
+		// However the session ends, e.g. when it's revoked by an administrator, or discarded
+		// when the user logs in again, the tokens obtained when it started are deleted.
+		store = session.NewNotifyingStore(store, func(r session.Record) error {
+			if r.Identity.TokenID == "" {
+				return nil
+			}
+			return tokens.Delete(r.Identity.TokenID)
 		})
 	}
-	tv := tokenverifier.GoogleTokenVerifier{
-		AllowedDomains: conf.GoogleAllowedDomains,
+	ss := session.NewServerSession(store, conf.SetSecureFlag, conf.CookieName)
+	ss.CookieOptions = conf.CookieOptions()
+	ss.Lifetime = lifetime
+	ss.MaxSessionsPerUser = conf.MaxSessionsPerUser
+	ss.TrustedProxies = conf.TrustedProxies
+	if conf.SessionLimitPolicy == "refuse" {
+		ss.SessionLimitPolicy = session.RefuseNewSession
 	}
-	return login.NewHandler(session, tv, lr, next)
+	return ss, store, nil
 }
@@ -0,0 +1,245 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/internal/origin"
+	"github.com/a-h/gauthmiddleware/logger"
+	"github.com/a-h/gauthmiddleware/session"
+)
+
+const pkg = "github.com/a-h/gauthmiddleware/handlers/admin"
+
+// DefaultPath is the path the admin page is served from.
+const DefaultPath = "/_gauth/admin"
+
+// Session is an active session, as listed by the admin page and API.
+type Session struct {
+	// Handle identifies the session, so that it can be revoked. It's derived from the
+	// session ID, which isn't disclosed, since it could be used to impersonate the user.
+	Handle    string    `json:"handle"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Page contains the values needed to render the admin page.
+type Page struct {
+	// Path is where the admin page is served from. Revocations are posted to Path + "/revoke".
+	Path     string
+	Sessions []Session
+}
+
+// A Renderer renders the admin page.
+type Renderer func(w http.ResponseWriter, r *http.Request, p Page)
+
+// Handler lists and revokes the sessions held in a SessionStore. It must be used behind
+// the login handler, since it uses the identity of the logged in user to check that they
+// are an admin.
+//
+// It serves:
+//
+//	GET    {Path}                         the admin page
+//	POST   {Path}/revoke                  revokes the session with the "handle" form value, or all
+//	                                      sessions of the "user" form value, then shows the page
+//	GET    {Path}/sessions                lists sessions as JSON, optionally filtered by ?user=
+//	DELETE {Path}/sessions/{handle}       revokes a session
+//	DELETE {Path}/users/{userID}/sessions revokes all sessions of a user
+type Handler struct {
+	Store session.SessionStore
+	// Admins are the email addresses of the users who can use the handler.
+	Admins     []string
+	RenderPage Renderer
+	Path       string
+	// AllowedOrigins are the origins, e.g. "https://example.com", which the revocation form
+	// can be posted from. If it's empty, the form must be posted from the host the request
+	// was sent to, which doesn't work behind proxies which rewrite the Host header.
+	AllowedOrigins []string
+}
+
+// NewHandler creates a Handler which is restricted to the admins.
+func NewHandler(store session.SessionStore, admins []string, pageRenderer Renderer) *Handler {
+	return &Handler{
+		Store:      store,
+		Admins:     admins,
+		RenderPage: pageRenderer,
+		Path:       DefaultPath,
+	}
+}
+
+// IsAdminPath returns true if the request is for the admin page or API.
+func (h Handler) IsAdminPath(r *http.Request) bool {
+	return r.URL.Path == h.Path || strings.HasPrefix(r.URL.Path, h.Path+"/")
+}
+
+func (h Handler) isAdmin(id identity.Identity) bool {
+	for _, admin := range h.Admins {
+		if id.Email != "" && strings.EqualFold(admin, id.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := identity.FromContext(r.Context())
+	if !ok || !h.isAdmin(id) {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).Warn("Attempt to access the admin page by a user who is not an admin")
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, h.Path)
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		h.page(w, r)
+	case path == "/revoke" && r.Method == http.MethodPost:
+		h.revokeFromPage(w, r, id)
+	case path == "/sessions" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case strings.HasPrefix(path, "/sessions/") && r.Method == http.MethodDelete:
+		h.revokeFromAPI(w, id, strings.TrimPrefix(path, "/sessions/"), "")
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/sessions") && r.Method == http.MethodDelete:
+		userID, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/sessions"))
+		if err != nil || userID == "" {
+			http.Error(w, "Invalid user ID.", http.StatusBadRequest)
+			return
+		}
+		h.revokeFromAPI(w, id, "", userID)
+	default:
+		http.Error(w, "Not found.", http.StatusNotFound)
+	}
+}
+
+// handle derives the public identifier of a session from its ID.
+func handle(sessionID string) string {
+	hash := sha256.Sum256([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
+
+func (h Handler) sessions(userID string) ([]Session, error) {
+	var records []session.Record
+	var err error
+	if userID != "" {
+		records, err = h.Store.ListByUser(userID)
+	} else {
+		records, err = h.Store.List()
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, r := range records {
+		sessions = append(sessions, Session{
+			Handle:    handle(r.ID),
+			UserID:    r.Identity.UserID(),
+			Email:     r.Identity.Email,
+			Name:      r.Identity.Name,
+			IP:        r.IP,
+			UserAgent: r.UserAgent,
+			Created:   r.Created,
+			LastSeen:  r.LastSeen,
+			Expires:   r.Expires,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (h Handler) page(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.sessions("")
+	if err != nil {
+		logger.For(pkg, "page").WithError(err).Error("Failed to list sessions")
+		http.Error(w, "Unable to list sessions.", http.StatusInternalServerError)
+		return
+	}
+	h.RenderPage(w, r, Page{
+		Path:     h.Path,
+		Sessions: sessions,
+	})
+}
+
+func (h Handler) list(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.sessions(r.URL.Query().Get("user"))
+	if err != nil {
+		logger.For(pkg, "list").WithError(err).Error("Failed to list sessions")
+		http.Error(w, "Unable to list sessions.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h Handler) revokeFromPage(w http.ResponseWriter, r *http.Request, admin identity.Identity) {
+	// Other sites mustn't be able to post the revocation form on behalf of an admin.
+	if !origin.Allowed(r, h.AllowedOrigins) {
+		logger.For(pkg, "revokeFromPage").WithField("email", admin.Email).Warn("Rejected a revocation posted from another site")
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+	if _, err := h.revoke(admin, r.FormValue("handle"), r.FormValue("user")); err != nil {
+		logger.For(pkg, "revokeFromPage").WithError(err).Error("Failed to revoke sessions")
+		http.Error(w, "Unable to revoke sessions.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, h.Path, http.StatusSeeOther)
+}
+
+func (h Handler) revokeFromAPI(w http.ResponseWriter, admin identity.Identity, sessionHandle string, userID string) {
+	revoked, err := h.revoke(admin, sessionHandle, userID)
+	if err != nil {
+		logger.For(pkg, "revokeFromAPI").WithError(err).Error("Failed to revoke sessions")
+		http.Error(w, "Unable to revoke sessions.", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 && sessionHandle != "" {
+		http.Error(w, "Session not found.", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Revoked int `json:"revoked"`
+	}{revoked})
+}
+
+// revoke deletes the session with the handle, or all sessions of the user.
+func (h Handler) revoke(admin identity.Identity, sessionHandle string, userID string) (revoked int, err error) {
+	var records []session.Record
+	if userID != "" {
+		records, err = h.Store.ListByUser(userID)
+	} else if sessionHandle != "" {
+		records, err = h.Store.List()
+	}
+	if err != nil {
+		return
+	}
+	for _, r := range records {
+		if userID == "" && handle(r.ID) != sessionHandle {
+			continue
+		}
+		if err = h.Store.Delete(r.ID); err != nil {
+			return
+		}
+		revoked++
+		logger.For(pkg, "revoke").
+			WithField("admin", admin.Email).
+			WithField("email", r.Identity.Email).
+			WithField("handle", handle(r.ID)).
+			Info("Session revoked")
+	}
+	return
+}
@@ -0,0 +1,229 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/session"
+)
+
+func newTestStore(t *testing.T) *session.MemoryStore {
+	store := session.NewMemoryStore()
+	now := time.Now()
+	for _, r := range []session.Record{
+		{ID: "session1", Identity: identity.Identity{Email: "alice@example.com", Subject: "1"}, IP: "192.0.2.1", LastSeen: now, Expires: now.Add(time.Hour)},
+		{ID: "session2", Identity: identity.Identity{Email: "alice@example.com", Subject: "1"}, IP: "192.0.2.2", LastSeen: now, Expires: now.Add(time.Hour)},
+		{ID: "session3", Identity: identity.Identity{Email: "bob@example.com", Subject: "2"}, IP: "192.0.2.3", LastSeen: now, Expires: now.Add(time.Hour)},
+	} {
+		if err := store.Put(r); err != nil {
+			t.Fatalf("failed to put session: %v", err)
+		}
+	}
+	return store
+}
+
+func remainingIDs(t *testing.T, store session.SessionStore) (ids []string) {
+	records, err := store.List()
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+	return
+}
+
+func TestHandler(t *testing.T) {
+	admin := identity.Identity{Email: "Admin@example.com"}
+	tests := []struct {
+		name               string
+		user               *identity.Identity
+		method             string
+		path               string
+		form               string
+		header             map[string]string
+		expectedStatus     int
+		expectedRendered   bool
+		expectedSessions   int
+		expectedRemaining  []string
+		expectedAPIRevoked int
+	}{
+		{
+			name:              "users who are not logged in are forbidden",
+			method:            "GET",
+			path:              "/_gauth/admin",
+			expectedStatus:    http.StatusForbidden,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:              "users who are not admins are forbidden",
+			user:              &identity.Identity{Email: "alice@example.com"},
+			method:            "DELETE",
+			path:              "/_gauth/admin/users/1/sessions",
+			expectedStatus:    http.StatusForbidden,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:              "admins can view the page",
+			user:              &admin,
+			method:            "GET",
+			path:              "/_gauth/admin",
+			expectedStatus:    http.StatusOK,
+			expectedRendered:  true,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:              "admins can list sessions",
+			user:              &admin,
+			method:            "GET",
+			path:              "/_gauth/admin/sessions",
+			expectedStatus:    http.StatusOK,
+			expectedSessions:  3,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:              "admins can list the sessions of a user",
+			user:              &admin,
+			method:            "GET",
+			path:              "/_gauth/admin/sessions?user=1",
+			expectedStatus:    http.StatusOK,
+			expectedSessions:  2,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:               "admins can revoke a session",
+			user:               &admin,
+			method:             "DELETE",
+			path:               "/_gauth/admin/sessions/" + handle("session2"),
+			expectedStatus:     http.StatusOK,
+			expectedAPIRevoked: 1,
+			expectedRemaining:  []string{"session1", "session3"},
+		},
+		{
+			name:              "revoking an unknown session is not found",
+			user:              &admin,
+			method:            "DELETE",
+			path:              "/_gauth/admin/sessions/session2",
+			expectedStatus:    http.StatusNotFound,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:               "admins can revoke all sessions of a user",
+			user:               &admin,
+			method:             "DELETE",
+			path:               "/_gauth/admin/users/1/sessions",
+			expectedStatus:     http.StatusOK,
+			expectedAPIRevoked: 2,
+			expectedRemaining:  []string{"session3"},
+		},
+		{
+			name:              "admins can revoke a session from the page",
+			user:              &admin,
+			method:            "POST",
+			path:              "/_gauth/admin/revoke",
+			form:              "handle=" + handle("session3"),
+			header:            map[string]string{"Origin": "http://example.com"},
+			expectedStatus:    http.StatusSeeOther,
+			expectedRemaining: []string{"session1", "session2"},
+		},
+		{
+			name:              "admins can revoke all sessions of a user from the page",
+			user:              &admin,
+			method:            "POST",
+			path:              "/_gauth/admin/revoke",
+			form:              "user=1",
+			expectedStatus:    http.StatusSeeOther,
+			expectedRemaining: []string{"session3"},
+		},
+		{
+			name:              "revocations posted from other sites are forbidden",
+			user:              &admin,
+			method:            "POST",
+			path:              "/_gauth/admin/revoke",
+			form:              "user=1",
+			header:            map[string]string{"Origin": "http://attacker.example.com"},
+			expectedStatus:    http.StatusForbidden,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+		{
+			name:              "unknown paths are not found",
+			user:              &admin,
+			method:            "GET",
+			path:              "/_gauth/admin/unknown",
+			expectedStatus:    http.StatusNotFound,
+			expectedRemaining: []string{"session1", "session2", "session3"},
+		},
+	}
+
+	for _, test := range tests {
+		store := newTestStore(t)
+		var rendered bool
+		h := NewHandler(store, []string{"admin@example.com"}, func(w http.ResponseWriter, r *http.Request, p Page) {
+			rendered = true
+			if len(p.Sessions) != 3 || p.Path != DefaultPath {
+				t.Errorf("%s: expected the page to contain 3 sessions, got %v", test.name, p)
+			}
+		})
+
+		var body io.Reader
+		if test.form != "" {
+			body = strings.NewReader(test.form)
+		}
+		r := httptest.NewRequest(test.method, "http://example.com"+test.path, body)
+		if test.form != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		if test.user != nil {
+			r = r.WithContext(identity.NewContext(r.Context(), *test.user))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, w.Code)
+		}
+		if rendered != test.expectedRendered {
+			t.Errorf("%s: expected rendered %v, got %v", test.name, test.expectedRendered, rendered)
+		}
+		if test.expectedSessions > 0 {
+			var sessions []Session
+			if err := json.NewDecoder(w.Body).Decode(&sessions); err != nil {
+				t.Fatalf("%s: failed to decode sessions: %v", test.name, err)
+			}
+			if len(sessions) != test.expectedSessions {
+				t.Errorf("%s: expected %d sessions, got %d", test.name, test.expectedSessions, len(sessions))
+			}
+			for _, s := range sessions {
+				if s.Handle == "" || s.Handle == "session1" || s.Handle == "session2" || s.Handle == "session3" {
+					t.Errorf("%s: expected the session ID not to be disclosed, got handle %q", test.name, s.Handle)
+				}
+			}
+		}
+		if test.expectedAPIRevoked > 0 {
+			var result struct {
+				Revoked int `json:"revoked"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("%s: failed to decode result: %v", test.name, err)
+			}
+			if result.Revoked != test.expectedAPIRevoked {
+				t.Errorf("%s: expected %d sessions to be revoked, got %d", test.name, test.expectedAPIRevoked, result.Revoked)
+			}
+		}
+		if remaining := remainingIDs(t, store); !reflect.DeepEqual(remaining, test.expectedRemaining) {
+			t.Errorf("%s: expected sessions %v to remain, got %v", test.name, test.expectedRemaining, remaining)
+		}
+	}
+}
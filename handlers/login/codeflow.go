@@ -0,0 +1,177 @@
+package login
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/a-h/gauthmiddleware/oidc"
+	"github.com/gorilla/securecookie"
+)
+
+// DefaultCodeFlowCookieName is the name of the cookie which holds the state of the
+// authorization request while the user signs in.
+const DefaultCodeFlowCookieName = "gauth_flow"
+
+// DefaultCodeFlowMaxAge is how long the user has to sign in once they've been sent to the
+// authorization endpoint.
+const DefaultCodeFlowMaxAge = 10 * time.Minute
+
+// maxCodeFlowAttempts is the number of login attempts kept in the cookie, so that the user
+// can start signing in from several tabs. The oldest attempt is discarded first.
+const maxCodeFlowAttempts = 5
+
+// ErrStateMismatch is returned when the authorization server redirects back to the
+// callback with a state which wasn't issued to this browser, or which has expired.
+var ErrStateMismatch = errors.New("login: the state doesn't match the login attempt")
+
+// CodeFlow signs users in using the OAuth 2.0 authorization code flow with PKCE, so that
+// no JavaScript is needed. The state, nonce and code verifier of each login attempt are
+// kept in an encrypted cookie, keyed by the state, until the user returns to the callback.
+type CodeFlow struct {
+	Client     *oidc.Client
+	CookieName string
+	codec      *securecookie.SecureCookie
+	maxAge     time.Duration
+	secure     bool
+}
+
+// codeFlowAttempt is stored in the cookie while the user signs in.
+type codeFlowAttempt struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	RedirectURL  string
+	ReturnURL    string
+	Started      time.Time
+}
+
+// NewCodeFlow creates a CodeFlow which encrypts the cookie using keys derived from the key,
+// and rejects cookies older than maxAge. If secure is set, the cookie is only sent over
+// HTTPS.
+func NewCodeFlow(client *oidc.Client, key []byte, maxAge time.Duration, secure bool) *CodeFlow {
+	codec := securecookie.New(keys.Derive(key, "gauthmiddleware/login/codeflow/authentication"),
+		keys.Derive(key, "gauthmiddleware/login/codeflow/encryption"))
+	codec.MaxAge(int(maxAge.Seconds()))
+	return &CodeFlow{
+		Client:     client,
+		CookieName: DefaultCodeFlowCookieName,
+		codec:      codec,
+		maxAge:     maxAge,
+		secure:     secure,
+	}
+}
+
+// Start begins a login attempt, and returns the URL of the authorization endpoint to send
+// the user to. If the Client has no RedirectURL, the callback on the host of the request
+// is used. Once signed in, the user is returned to returnURL. Attempts which were started
+// earlier by the browser can still be completed.
+func (cf *CodeFlow) Start(w http.ResponseWriter, r *http.Request, callbackPath, returnURL string) (authorizationURL string, err error) {
+	a := codeFlowAttempt{
+		RedirectURL: cf.Client.RedirectURL,
+		ReturnURL:   returnURL,
+		Started:     time.Now(),
+	}
+	if a.RedirectURL == "" {
+		a.RedirectURL = callbackURL(r, callbackPath, "")
+	}
+	if !isLocalURL(a.ReturnURL) {
+		a.ReturnURL = "/"
+	}
+	if a.State, err = oidc.NewState(); err != nil {
+		return
+	}
+	if a.Nonce, err = oidc.NewState(); err != nil {
+		return
+	}
+	if a.CodeVerifier, err = oidc.NewCodeVerifier(); err != nil {
+		return
+	}
+	attempts := append(cf.attempts(r), a)
+	if len(attempts) > maxCodeFlowAttempts {
+		attempts = attempts[len(attempts)-maxCodeFlowAttempts:]
+	}
+	if err = cf.setAttempts(w, attempts); err != nil {
+		return
+	}
+	return cf.client(a).AuthCodeURL(a.State, a.Nonce, a.CodeVerifier), nil
+}
+
+// Complete checks that the user returned to the callback from a login attempt started
+// by this browser, and exchanges the authorization code for tokens. The ID token must be
+// verified, and must contain the returned nonce.
+func (cf *CodeFlow) Complete(w http.ResponseWriter, r *http.Request) (token *oidc.Token, nonce, returnURL string, err error) {
+	attempts := cf.attempts(r)
+	state := r.URL.Query().Get("state")
+	for i, a := range attempts {
+		if subtle.ConstantTimeCompare([]byte(a.State), []byte(state)) != 1 {
+			continue
+		}
+		// The attempt can only be completed once.
+		if err = cf.setAttempts(w, append(attempts[:i:i], attempts[i+1:]...)); err != nil {
+			return nil, "", "", err
+		}
+		token, err = cf.client(a).Exchange(r.URL.Query().Get("code"), a.CodeVerifier)
+		return token, a.Nonce, a.ReturnURL, err
+	}
+	return nil, "", "", ErrStateMismatch
+}
+
+// attempts returns the login attempts in the cookie which haven't expired, oldest first.
+func (cf *CodeFlow) attempts(r *http.Request) (attempts []codeFlowAttempt) {
+	c, err := r.Cookie(cf.CookieName)
+	if err != nil {
+		return nil
+	}
+	var all []codeFlowAttempt
+	if err = cf.codec.Decode(cf.CookieName, c.Value, &all); err != nil {
+		return nil
+	}
+	for _, a := range all {
+		if time.Since(a.Started) < cf.maxAge {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts
+}
+
+// setAttempts replaces the attempts in the cookie, or clears the cookie if there are none.
+// If the attempts don't fit in a cookie, the oldest are discarded.
+func (cf *CodeFlow) setAttempts(w http.ResponseWriter, attempts []codeFlowAttempt) error {
+	for len(attempts) > 0 {
+		value, err := cf.codec.Encode(cf.CookieName, attempts)
+		if err == nil {
+			http.SetCookie(w, cf.cookie(value, int(cf.maxAge.Seconds())))
+			return nil
+		}
+		if len(attempts) == 1 {
+			return err
+		}
+		attempts = attempts[1:]
+	}
+	http.SetCookie(w, cf.cookie("", -1))
+	return nil
+}
+
+// client returns the Client, using the redirect URL of the attempt.
+func (cf *CodeFlow) client(a codeFlowAttempt) *oidc.Client {
+	client := *cf.Client
+	client.RedirectURL = a.RedirectURL
+	return &client
+}
+
+func (cf *CodeFlow) cookie(value string, maxAge int) *http.Cookie {
+	// The authorization server redirects back to the callback, so the cookie must be sent
+	// on top level navigations from other sites.
+	return &http.Cookie{
+		Name:     cf.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   cf.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
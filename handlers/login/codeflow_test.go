@@ -0,0 +1,368 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/oidc"
+	"github.com/a-h/gauthmiddleware/oidc/oidctest"
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/tokenverifier"
+)
+
+func TestCodeFlow(t *testing.T) {
+	tests := []struct {
+		name             string
+		denied           bool
+		idToken          func(nonce string) string
+		tamper           func(callback *url.URL, cookies []*http.Cookie) []*http.Cookie
+		expectedStatus   int
+		expectedRedirect string
+	}{
+		{
+			name:             "users are returned to the page they requested once signed in",
+			expectedStatus:   http.StatusSeeOther,
+			expectedRedirect: "/reports?year=2017",
+		},
+		{
+			name:           "users who deny access are not signed in",
+			denied:         true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "callbacks with another state are rejected",
+			tamper: func(callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				q := callback.Query()
+				q.Set("state", "another_state")
+				callback.RawQuery = q.Encode()
+				return cookies
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "callbacks from another browser are rejected",
+			tamper: func(callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				return nil
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "ID tokens for another login attempt are rejected",
+			idToken: func(nonce string) string {
+				return "id_token_for_another_nonce"
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		s := oidctest.NewServer("the_client_id", "the_client_secret")
+		s.Denied = test.denied
+		if test.idToken != nil {
+			s.IDToken = test.idToken
+		}
+
+		var page Page
+		sess := session.NewGorillaSession([]byte("session_key"), false, "session")
+		h := NewHandler(sess, mockTokenVerifier{
+			validator: func(idToken string) (*tokenverifier.Claim, error) {
+				return &tokenverifier.Claim{Email: "marr@example.com", Nonce: strings.TrimPrefix(idToken, "id_token_for_")}, nil
+			},
+		}, func(w http.ResponseWriter, r *http.Request, p Page) {
+			page = p
+		}, nil)
+		h.CodeFlow = NewCodeFlow(oidc.NewClient(oidc.Config{
+			ClientID:              "the_client_id",
+			ClientSecret:          "the_client_secret",
+			AuthorizationEndpoint: s.AuthorizationEndpoint(),
+			TokenEndpoint:         s.TokenEndpoint(),
+		}), []byte("flow_key"), DefaultCodeFlowMaxAge, false)
+
+		// Show the login screen.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/reports?year=2017", nil))
+		if page.SignInURL == "" {
+			t.Fatalf("%s: expected the login screen to link to the sign in URL", test.name)
+		}
+		if cookies := w.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("%s: expected the login screen not to start a login attempt, got %v", test.name, cookies)
+		}
+
+		// Sign in at the authorization server.
+		callback, cookies := signIn(t, h, page.SignInURL, nil)
+		if callback.Host != "example.com" || callback.Path != DefaultCallbackPath {
+			t.Fatalf("%s: expected a redirect to the callback, got %v", test.name, callback)
+		}
+		if test.tamper != nil {
+			cookies = test.tamper(callback, cookies)
+		}
+
+		// Return to the callback.
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", callback.String(), nil), cookies))
+		s.Close()
+
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.expectedStatus, w.Code, w.Body.String())
+		}
+		if test.expectedRedirect == "" {
+			continue
+		}
+		if actual := w.Header().Get("Location"); actual != test.expectedRedirect {
+			t.Errorf("%s: expected redirect to %q, got %q", test.name, test.expectedRedirect, actual)
+		}
+		var sessionStarted, flowCleared bool
+		for _, c := range w.Result().Cookies() {
+			sessionStarted = sessionStarted || (c.Name == "session" && c.MaxAge >= 0)
+			flowCleared = flowCleared || (c.Name == DefaultCodeFlowCookieName && c.MaxAge < 0)
+		}
+		if !sessionStarted || !flowCleared {
+			t.Errorf("%s: expected the session to be started and the flow cookie to be cleared, got %v", test.name, w.Result().Cookies())
+		}
+	}
+}
+
+// signIn follows the sign in URL of the login screen to the authorization server, and
+// returns the URL of the callback the user is redirected to, along with the cookies which
+// were updated by starting the login attempt.
+func signIn(t *testing.T, h http.Handler, signInURL string, cookies []*http.Cookie) (callback *url.URL, updated []*http.Cookie) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", "http://example.com"+signInURL, nil), cookies))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect to the authorization endpoint, got status %d: %s", w.Code, w.Body.String())
+	}
+	resp, err := (&http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}).Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+	resp.Body.Close()
+	if callback, err = resp.Location(); err != nil {
+		t.Fatalf("expected a redirect to the callback: %v", err)
+	}
+	return callback, updateCookies(cookies, w.Result().Cookies())
+}
+
+func withCookies(r *http.Request, cookies []*http.Cookie) *http.Request {
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// updateCookies replaces the cookies with those set by a response, as a browser would.
+func updateCookies(cookies, set []*http.Cookie) (updated []*http.Cookie) {
+	replaced := map[string]bool{}
+	for _, c := range set {
+		replaced[c.Name] = true
+		if c.MaxAge >= 0 {
+			updated = append(updated, c)
+		}
+	}
+	for _, c := range cookies {
+		if !replaced[c.Name] {
+			updated = append(updated, c)
+		}
+	}
+	return updated
+}
+
+func TestThatLoginAttemptsSurviveOtherRequestsAndTabs(t *testing.T) {
+	s := oidctest.NewServer("the_client_id", "the_client_secret")
+	defer s.Close()
+	var page Page
+	h := NewHandler(mockSession{}, mockTokenVerifier{
+		validator: func(idToken string) (*tokenverifier.Claim, error) {
+			return &tokenverifier.Claim{Email: "marr@example.com", Nonce: strings.TrimPrefix(idToken, "id_token_for_")}, nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request, p Page) {
+		page = p
+	}, nil)
+	h.CodeFlow = NewCodeFlow(oidc.NewClient(oidc.Config{
+		ClientID:              "the_client_id",
+		ClientSecret:          "the_client_secret",
+		AuthorizationEndpoint: s.AuthorizationEndpoint(),
+		TokenEndpoint:         s.TokenEndpoint(),
+	}), []byte("flow_key"), DefaultCodeFlowMaxAge, false)
+
+	// Start signing in from the first tab.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/reports", nil))
+	first, cookies := signIn(t, h, page.SignInURL, nil)
+
+	// The browser requests the favicon, and the user opens another tab, before returning.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", "http://example.com/favicon.ico", nil), cookies))
+	cookies = updateCookies(cookies, w.Result().Cookies())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/admin", nil))
+	second, cookies := signIn(t, h, page.SignInURL, cookies)
+
+	for _, test := range []struct {
+		callback         *url.URL
+		expectedRedirect string
+	}{
+		{callback: first, expectedRedirect: "/reports"},
+		{callback: second, expectedRedirect: "/admin"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", test.callback.String(), nil), cookies))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != test.expectedRedirect {
+			t.Errorf("expected the user to be returned to %s, got status %d, location %q: %s", test.expectedRedirect, w.Code, w.Header().Get("Location"), w.Body.String())
+		}
+		cookies = updateCookies(cookies, w.Result().Cookies())
+	}
+	if len(cookies) != 0 {
+		t.Errorf("expected the flow cookie to be cleared once both attempts were completed, got %v", cookies)
+	}
+}
+
+func TestThatIDTokensAreVerifiedAgainstTheConfiguredIssuer(t *testing.T) {
+	tests := []struct {
+		name            string
+		configureIssuer bool
+		expectedStatus  int
+	}{
+		{
+			name:           "tokens from other issuers are rejected by default",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:            "tokens from the configured issuer are accepted",
+			configureIssuer: true,
+			expectedStatus:  http.StatusSeeOther,
+		},
+	}
+
+	for _, test := range tests {
+		s := oidctest.NewServer("the_client_id", "the_client_secret")
+		s.IDToken = func(nonce string) string {
+			return s.SignIDToken(map[string]interface{}{
+				"iss":            s.Issuer(),
+				"aud":            "the_client_id",
+				"sub":            "the_subject",
+				"email":          "marr@example.com",
+				"email_verified": true,
+				"exp":            time.Now().Add(time.Hour).Unix(),
+				"nonce":          nonce,
+			})
+		}
+		tv := tokenverifier.NewJWKSTokenVerifier(s.JWKSURL(), []string{"the_client_id"}, nil)
+		if test.configureIssuer {
+			tv.Issuers = []string{s.Issuer()}
+		}
+
+		var page Page
+		h := NewHandler(mockSession{}, tv, func(w http.ResponseWriter, r *http.Request, p Page) {
+			page = p
+		}, nil)
+		h.CodeFlow = NewCodeFlow(oidc.NewClient(oidc.Config{
+			ClientID:              "the_client_id",
+			ClientSecret:          "the_client_secret",
+			AuthorizationEndpoint: s.AuthorizationEndpoint(),
+			TokenEndpoint:         s.TokenEndpoint(),
+		}), []byte("flow_key"), DefaultCodeFlowMaxAge, false)
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+		callback, cookies := signIn(t, h, page.SignInURL, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", callback.String(), nil), cookies))
+		s.Close()
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestThatTheCallbackOnlyAcceptsCodesWhenTheCodeFlowIsUsed(t *testing.T) {
+	h := NewHandler(mockSession{}, mockTokenVerifier{}, nil, nil)
+	h.CodeFlow = NewCodeFlow(oidc.NewClient(oidc.Config{}), []byte("flow_key"), DefaultCodeFlowMaxAge, false)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com"+DefaultCallbackPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestThatTokensAreAvailableToNextUntilTheUserLogsOut(t *testing.T) {
+	s := oidctest.NewServer("the_client_id", "the_client_secret")
+	defer s.Close()
+	client := oidc.NewClient(oidc.Config{
+		ClientID:              "the_client_id",
+		ClientSecret:          "the_client_secret",
+		AuthorizationEndpoint: s.AuthorizationEndpoint(),
+		TokenEndpoint:         s.TokenEndpoint(),
+		Offline:               true,
+	})
+	store := oidc.NewMemoryTokenStore()
+
+	var page Page
+	var accessToken string
+	var accessTokenErr error
+	sess := session.NewGorillaSession([]byte("session_key"), false, "session")
+	h := NewHandler(sess, mockTokenVerifier{
+		validator: func(idToken string) (*tokenverifier.Claim, error) {
+			return &tokenverifier.Claim{Email: "marr@example.com", Nonce: strings.TrimPrefix(idToken, "id_token_for_")}, nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request, p Page) {
+		page = p
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken, accessTokenErr = oidc.AccessTokenFromContext(r.Context())
+	}))
+	h.CodeFlow = NewCodeFlow(client, []byte("flow_key"), DefaultCodeFlowMaxAge, false)
+	h.Tokens = oidc.NewTokens(client, store, []byte("tokens_key"))
+
+	// Sign in.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+	callback, flowCookies := signIn(t, h, page.SignInURL, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, withCookies(httptest.NewRequest("GET", callback.String(), nil), flowCookies))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the user to be signed in, got status %d: %s", w.Code, w.Body.String())
+	}
+	var sessionCookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			sessionCookies = append(sessionCookies, c)
+		}
+	}
+
+	// Access the page.
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	for _, c := range sessionCookies {
+		r.AddCookie(c)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if accessTokenErr != nil || accessToken != "access_token_for_code_1" {
+		t.Fatalf("expected the access token to be available to Next, got %q, %v", accessToken, accessTokenErr)
+	}
+	_, id, _ := sess.Validate(httptest.NewRecorder(), r)
+	if _, ok, _ := store.Get(id.TokenID); !ok {
+		t.Fatalf("expected the tokens to be stored")
+	}
+
+	// Log out.
+	var revokedTokens []string
+	revocationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revokedTokens = append(revokedTokens, r.FormValue("token"))
+	}))
+	defer revocationServer.Close()
+	h.Revoker = NewRevoker(revocationServer.URL)
+	r = httptest.NewRequest("POST", "http://example.com"+DefaultLogoutPath, nil)
+	for _, c := range sessionCookies {
+		r.AddCookie(c)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if _, ok, _ := store.Get(id.TokenID); ok {
+		t.Errorf("expected the tokens to be deleted when the user logs out")
+	}
+	if expected := []string{"refresh_token_for_code_1"}; !reflect.DeepEqual(expected, revokedTokens) {
+		t.Errorf("expected the stored refresh token to be revoked, got %v", revokedTokens)
+	}
+}
@@ -0,0 +1,109 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/gorilla/securecookie"
+)
+
+// GoogleCSRFTokenName is the name of the cookie set by Google Identity Services, and of
+// the field it posts to the callback along with the ID token. Their values must match.
+const GoogleCSRFTokenName = "g_csrf_token"
+
+// DefaultCSRFCookieName is the name of the cookie which holds the CSRF token issued with
+// the login screen.
+const DefaultCSRFCookieName = "gauth_csrf"
+
+// CSRFFieldName is the name of the field the login screen posts the CSRF token in.
+const CSRFFieldName = "csrf_token"
+
+// DefaultCSRFMaxAge is how long the CSRF token issued with the login screen is valid for.
+const DefaultCSRFMaxAge = 15 * time.Minute
+
+// ErrCSRFTokenMismatch is returned when the CSRF token posted to the callback is missing,
+// or doesn't match the cookie.
+var ErrCSRFTokenMismatch = errors.New("login: the CSRF token is missing or doesn't match the cookie")
+
+// CSRF issues and verifies signed double-submit tokens, which prevent other sites from
+// posting an ID token to the callback to log the user in to another account. The token is
+// set in a cookie and included in the login screen, and both must be posted back.
+type CSRF struct {
+	codec      *securecookie.SecureCookie
+	CookieName string
+	maxAge     time.Duration
+	secure     bool
+}
+
+// NewCSRF creates a CSRF which signs tokens using a key derived from the key, and rejects
+// tokens older than maxAge. If secure is set, the cookie is only sent over HTTPS.
+func NewCSRF(key []byte, maxAge time.Duration, secure bool) *CSRF {
+	codec := securecookie.New(keys.Derive(key, "gauthmiddleware/login/csrf"), nil)
+	codec.MaxAge(int(maxAge.Seconds()))
+	return &CSRF{
+		codec:      codec,
+		CookieName: DefaultCSRFCookieName,
+		maxAge:     maxAge,
+		secure:     secure,
+	}
+}
+
+// Issue returns the token to include in the login screen. The token in the request's
+// cookie is reused if it's still valid, so that logging in from several tabs works,
+// otherwise a new token is set in the cookie.
+func (c *CSRF) Issue(w http.ResponseWriter, r *http.Request) (token string, err error) {
+	if cookie, err := r.Cookie(c.CookieName); err == nil && c.valid(cookie.Value) {
+		return cookie.Value, nil
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	if token, err = c.codec.Encode("csrf", base64.RawURLEncoding.EncodeToString(b)); err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(c.maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// Verify checks that the token posted in the form matches the cookie, and was issued by
+// Issue.
+func (c *CSRF) Verify(r *http.Request) error {
+	if err := verifyDoubleSubmit(r, c.CookieName, CSRFFieldName); err != nil {
+		return err
+	}
+	if !c.valid(r.PostFormValue(CSRFFieldName)) {
+		return ErrCSRFTokenMismatch
+	}
+	return nil
+}
+
+func (c *CSRF) valid(token string) bool {
+	var value string
+	return c.codec.Decode("csrf", token, &value) == nil
+}
+
+// verifyDoubleSubmit checks that the value posted in the form field matches the cookie.
+func verifyDoubleSubmit(r *http.Request, cookieName, fieldName string) error {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return ErrCSRFTokenMismatch
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue(fieldName))) != 1 {
+		return ErrCSRFTokenMismatch
+	}
+	return nil
+}
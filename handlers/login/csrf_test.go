@@ -0,0 +1,148 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestCSRF(t *testing.T) {
+	csrf := NewCSRF([]byte("csrf_key"), DefaultCSRFMaxAge, true)
+	w := httptest.NewRecorder()
+	token, err := csrf.Issue(w, httptest.NewRequest("GET", "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultCSRFCookieName || cookies[0].Value != token {
+		t.Fatalf("expected the token to be set in the %s cookie, got %v", DefaultCSRFCookieName, cookies)
+	}
+	if !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("expected a secure, HttpOnly, SameSite=Strict cookie, got %v", cookies[0])
+	}
+	forged, err := NewCSRF([]byte("other_key"), DefaultCSRFMaxAge, true).Issue(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	// The key is shared with other cookies, so tokens signed with it directly mustn't be
+	// accepted.
+	signedWithConfiguredKey, err := securecookie.New([]byte("csrf_key"), nil).Encode("csrf", "value")
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cookies  map[string]string
+		form     url.Values
+		csrf     *CSRF
+		expected error
+	}{
+		{
+			name:     "matching tokens issued by the login screen are accepted",
+			cookies:  map[string]string{DefaultCSRFCookieName: token},
+			form:     url.Values{CSRFFieldName: []string{token}},
+			csrf:     csrf,
+			expected: nil,
+		},
+		{
+			name:     "a missing token is rejected",
+			cookies:  map[string]string{DefaultCSRFCookieName: token},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "a missing cookie is rejected",
+			form:     url.Values{CSRFFieldName: []string{token}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "tokens which don't match the cookie are rejected",
+			cookies:  map[string]string{DefaultCSRFCookieName: token},
+			form:     url.Values{CSRFFieldName: []string{forged}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "matching tokens signed with another key are rejected",
+			cookies:  map[string]string{DefaultCSRFCookieName: forged},
+			form:     url.Values{CSRFFieldName: []string{forged}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "matching tokens signed with the configured key are rejected",
+			cookies:  map[string]string{DefaultCSRFCookieName: signedWithConfiguredKey},
+			form:     url.Values{CSRFFieldName: []string{signedWithConfiguredKey}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "login screens without a CSRF token are rejected when CSRF isn't configured",
+			cookies:  map[string]string{DefaultCSRFCookieName: token},
+			form:     url.Values{CSRFFieldName: []string{token}},
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "matching Google Identity Services tokens are accepted",
+			cookies:  map[string]string{GoogleCSRFTokenName: "google_token"},
+			form:     url.Values{GoogleCSRFTokenName: []string{"google_token"}},
+			expected: nil,
+		},
+		{
+			name:     "Google Identity Services tokens which don't match the cookie are rejected",
+			cookies:  map[string]string{GoogleCSRFTokenName: "google_token"},
+			form:     url.Values{GoogleCSRFTokenName: []string{"attacker_token"}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+		{
+			name:     "empty Google Identity Services tokens are rejected",
+			cookies:  map[string]string{GoogleCSRFTokenName: ""},
+			form:     url.Values{GoogleCSRFTokenName: []string{""}},
+			csrf:     csrf,
+			expected: ErrCSRFTokenMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://example.com"+DefaultCallbackPath, strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, value := range test.cookies {
+			r.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		r.ParseForm()
+		h := Handler{CSRF: test.csrf}
+		if actual := h.verifyCSRF(r); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestThatValidCSRFTokensAreReused(t *testing.T) {
+	csrf := NewCSRF([]byte("csrf_key"), time.Minute, false)
+	token, err := csrf.Issue(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: token})
+	w := httptest.NewRecorder()
+	reused, err := csrf.Issue(w, r)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if reused != token {
+		t.Errorf("expected the token in the cookie to be reused")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected the cookie not to be replaced, got %v", cookies)
+	}
+}
@@ -2,62 +2,452 @@ package login
 
 import (
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/internal/origin"
 	"github.com/a-h/gauthmiddleware/logger"
+	"github.com/a-h/gauthmiddleware/oidc"
 	"github.com/a-h/gauthmiddleware/session"
 	"github.com/a-h/gauthmiddleware/tokenverifier"
 )
 
 const pkg = "github.com/a-h/gauthmiddleware/handlers/login"
 
+// DefaultCallbackPath is the path the login screen posts the ID token to.
+const DefaultCallbackPath = "/_gauth/callback"
+
+// CredentialFieldName is the field Google Identity Services posts the ID token in.
+const CredentialFieldName = "credential"
+
+// IDTokenFieldName is the field the legacy login screen posts the ID token in.
+const IDTokenFieldName = "id_token"
+
+// DefaultLogoutPath is the path which logs the user out.
+const DefaultLogoutPath = "/_gauth/logout"
+
+// DefaultStartPath is the path which starts a login attempt when the CodeFlow is used.
+const DefaultStartPath = "/_gauth/start"
+
+// ReturnURLFieldName is the query parameter of the StartPath which holds the URL to return
+// the user to once they've signed in.
+const ReturnURLFieldName = "return"
+
+// Page contains the values the login screen needs to post the ID token back to the Handler.
+type Page struct {
+	// CallbackPath is where the login screen must post the ID token to.
+	CallbackPath string
+	// State must be posted back to the callback, along with the ID token.
+	State string
+	// LoginURI is the absolute URL of the callback, including the State in the query, which
+	// Google Identity Services posts the ID token to.
+	LoginURI string
+	// Message explains why the user must log in, e.g. because their session expired.
+	Message string
+	// CSRFToken must be posted back to the callback in the CSRFFieldName field, unless the
+	// login screen uses Google Identity Services, which posts its own token.
+	CSRFToken string
+	// SignInURL is where the user is sent to sign in when the CodeFlow is used. It starts a
+	// login attempt, and redirects the user to the authorization endpoint.
+	SignInURL string
+	// Nonce must be sent to Google when requesting the ID token, so that it's included in
+	// the token. It's empty if the Handler doesn't check nonces.
+	Nonce string
+}
+
+// A Renderer renders the login screen.
+type Renderer func(w http.ResponseWriter, r *http.Request, p Page)
+
+// An ErrorRenderer renders a screen explaining why the user couldn't be logged in.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, status int, message string)
+
 // Handler renders the logon screen if you're not logged on, or passes you through to the
 // expected content.
 type Handler struct {
 	Session       session.Session
 	TokenVerifier tokenverifier.TokenVerifier
-	RenderLogin   http.HandlerFunc
+	RenderLogin   Renderer
 	Next          http.Handler
+	// CallbackPath is reserved for receiving the ID token from the login screen. Requests
+	// to any other path are passed to Next once the user has logged in.
+	CallbackPath string
+	// State records the URL the user was trying to access, so they can be returned to it
+	// after logging in. If it's nil, users are returned to "/".
+	State *State
+	// CSRF verifies the token posted by login screens which don't use Google Identity
+	// Services. If it's nil, only posts from Google Identity Services, which include the
+	// g_csrf_token, are accepted.
+	CSRF *CSRF
+	// Nonce ties each ID token to the login attempt which requested it. If it's nil, the
+	// nonce of the token isn't checked.
+	Nonce *Nonce
+	// ReplayCache prevents ID tokens from being used to log in more than once. If it's nil,
+	// tokens can be reused until they expire.
+	ReplayCache *tokenverifier.ReplayCache
+	// CodeFlow, if set, signs users in using the OAuth 2.0 authorization code flow instead
+	// of a JavaScript login screen. The callback must be registered as a redirect URI.
+	CodeFlow *CodeFlow
+	// StartPath is reserved for starting login attempts when the CodeFlow is used, so that
+	// rendering the login screen, e.g. for a favicon request, doesn't replace an attempt
+	// which is in progress.
+	StartPath string
+	// Tokens, if set, keeps the refresh token issued by the CodeFlow, so that Next can get
+	// an access token using oidc.AccessTokenFromContext. The tokens are deleted when the
+	// user logs out.
+	Tokens *oidc.Tokens
+	// LogoutPath is reserved for logging the user out.
+	LogoutPath string
+	// AllowedOrigins are the origins, e.g. "https://example.com", which the logout form can
+	// be posted from. If it's empty, the form must be posted from the host the request was
+	// sent to, which doesn't work behind proxies which rewrite the Host header.
+	AllowedOrigins []string
+	// LogoutRedirectURL is where users are sent once they've logged out. If it's empty,
+	// RenderLoggedOut is used instead.
+	LogoutRedirectURL string
+	// RenderLoggedOut renders the screen shown once the user has logged out.
+	RenderLoggedOut http.HandlerFunc
+	// RenderError renders the screen shown when the user can't be logged in, e.g. because
+	// they have too many sessions.
+	RenderError ErrorRenderer
+	// Revoker revokes the user's Google grant when they log out. If it's nil, the grant
+	// is not revoked.
+	Revoker *Revoker
+	// RevocationToken returns the token to revoke when the user logs out, if Tokens holds
+	// no refresh token for the session. By default, it's read from the "token" field of
+	// the logout request.
+	RevocationToken func(r *http.Request) string
 }
 
 // NewHandler creates an instance of the LoginHandler middleware.
 func NewHandler(session session.Session,
 	tokenVerifier tokenverifier.TokenVerifier,
-	loginRenderer http.HandlerFunc,
+	loginRenderer Renderer,
 	next http.Handler) *Handler {
 	return &Handler{
 		Session:       session,
 		TokenVerifier: tokenVerifier,
 		RenderLogin:   loginRenderer,
 		Next:          next,
+		CallbackPath:  DefaultCallbackPath,
+		StartPath:     DefaultStartPath,
+		LogoutPath:    DefaultLogoutPath,
+		RenderLoggedOut: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("You are signed out."))
+		},
+		RenderError: func(w http.ResponseWriter, r *http.Request, status int, message string) {
+			http.Error(w, message, status)
+		},
+		RevocationToken: func(r *http.Request) string {
+			return r.FormValue("token")
+		},
 	}
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.For(pkg, "ServeHTTP").WithField("url", r.URL.Path).Info("Attempt to access")
-	if r.Method == http.MethodPost {
-		// Retrieve the token from Google and validate it against our requirements.
-		r.ParseForm()
-		idToken := r.FormValue("id_token")
+	if r.URL.Path == h.CallbackPath {
+		h.callback(w, r)
+		return
+	}
+	if r.URL.Path == h.LogoutPath {
+		h.logout(w, r)
+		return
+	}
+	if h.CodeFlow != nil && r.URL.Path == h.StartPath {
+		h.start(w, r)
+		return
+	}
+	isValid, id, err := h.Session.Validate(w, r)
+	if err == session.ErrExpired {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).Info("Session expired")
+		h.renderLogin(w, r, "Your session has expired, please log in again.")
+		return
+	}
+	if err == session.ErrBindingChanged {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).Warn("Session used by a different client")
+		h.renderLogin(w, r, "Your session could not be verified, please log in again.")
+		return
+	}
+	if err == session.ErrRevoked {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).Warn("Session revoked")
+		h.renderLogin(w, r, "Your session has ended, please log in again.")
+		return
+	}
+	if err != nil {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).WithError(err).Error("Error validating session")
+		http.Error(w, "Unable to validate session.", http.StatusInternalServerError)
+		return
+	}
+	if !isValid {
+		logger.For(pkg, "ServeHTTP").WithField("email", id.Email).Error("Invalid session")
+		h.renderLogin(w, r, "")
+		return
+	}
+	logger.For(pkg, "ServeHTTP").WithField("email", id.Email).WithField("url", r.URL.Path).Info("Accessing")
+	ctx := identity.NewContext(r.Context(), id)
+	if h.Tokens != nil && id.TokenID != "" {
+		ctx = oidc.NewContext(ctx, h.Tokens, id.TokenID)
+	}
+	h.Next.ServeHTTP(w, r.WithContext(ctx))
+}
 
-		claims, err := h.TokenVerifier.ValidateToken(idToken)
-		if err != nil {
-			logger.For(pkg, "ServeHTTP").WithField("idToken", idToken).WithError(err).Error("Invalid token")
-			http.Error(w, "The presented claim is invalid.", http.StatusInternalServerError)
+// callback receives the ID token posted by the login screen, starts the session and
+// redirects the user back to the page they were trying to access. When the CodeFlow is
+// used, the authorization server redirects the user back with a code instead.
+func (h Handler) callback(w http.ResponseWriter, r *http.Request) {
+	if h.CodeFlow != nil && r.Method == http.MethodGet {
+		h.codeCallback(w, r)
+		return
+	}
+	if h.CodeFlow != nil || r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	if err := h.verifyCSRF(r); err != nil {
+		logger.For(pkg, "callback").WithField("origin", r.Header.Get("Origin")).WithError(err).Warn("Login refused, the CSRF token is invalid")
+		h.RenderError(w, r, http.StatusForbidden, "Your sign in could not be verified, please try again.")
+		return
+	}
+	var nonce string
+	if h.Nonce != nil {
+		var err error
+		if nonce, err = h.Nonce.Read(r); err != nil {
+			logger.For(pkg, "callback").WithError(err).Warn("Login refused, the nonce cookie is missing or invalid")
+			h.RenderError(w, r, http.StatusForbidden, "Your sign in has expired, please try again.")
 			return
 		}
-		h.Session.Start(w, r, claims.Email)
+		h.Nonce.Clear(w)
+	}
+	idToken := r.PostFormValue(CredentialFieldName)
+	if idToken == "" {
+		idToken = r.FormValue(IDTokenFieldName)
 	}
-	isValid, email, err := h.Session.Validate(r)
+	h.login(w, r, idToken, nil, nonce, h.returnURL(r.FormValue("state")))
+}
+
+// start begins a login attempt using the CodeFlow, and redirects the user to the
+// authorization endpoint.
+func (h Handler) start(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	authorizationURL, err := h.CodeFlow.Start(w, r, h.CallbackPath, r.URL.Query().Get(ReturnURLFieldName))
 	if err != nil {
-		logger.For(pkg, "ServeHTTP").WithField("email", email).WithError(err).Error("Error validating session")
-		http.Error(w, "Unable to validate session.", http.StatusInternalServerError)
+		logger.For(pkg, "start").WithError(err).Error("Unable to start the authorization code flow")
+		h.RenderError(w, r, http.StatusInternalServerError, "Unable to start sign in, please try again.")
 		return
 	}
-	if !isValid {
-		logger.For(pkg, "ServeHTTP").WithField("email", email).Error("Invalid session")
-		h.RenderLogin(w, r)
+	http.Redirect(w, r, authorizationURL, http.StatusSeeOther)
+}
+
+// codeCallback exchanges the authorization code returned by the authorization server for
+// an ID token, and starts the session.
+func (h Handler) codeCallback(w http.ResponseWriter, r *http.Request) {
+	if e := r.URL.Query().Get("error"); e != "" {
+		logger.For(pkg, "codeCallback").WithField("error", e).Warn("Login refused by the authorization server")
+		h.RenderError(w, r, http.StatusForbidden, "Google didn't allow you to sign in, please try again.")
+		return
+	}
+	token, nonce, returnURL, err := h.CodeFlow.Complete(w, r)
+	if err == ErrStateMismatch {
+		logger.For(pkg, "codeCallback").WithError(err).Warn("Login refused, the state is invalid")
+		h.RenderError(w, r, http.StatusForbidden, "Your sign in could not be verified, please try again.")
+		return
+	}
+	if err != nil {
+		logger.For(pkg, "codeCallback").WithError(err).Error("Unable to exchange the authorization code")
+		h.RenderError(w, r, http.StatusInternalServerError, "Unable to complete sign in, please try again.")
 		return
 	}
-	logger.For(pkg, "ServeHTTP").WithField("email", email).WithField("url", r.URL.Path).Info("Accessing")
-	h.Next.ServeHTTP(w, r)
+	h.login(w, r, token.IDToken, token, nonce, returnURL)
+}
+
+// login validates the ID token, starts the session and redirects the user to the
+// returnURL. If the ID token was obtained by the CodeFlow, the token contains the access
+// and refresh tokens issued with it, otherwise it's nil.
+func (h Handler) login(w http.ResponseWriter, r *http.Request, idToken string, token *oidc.Token, nonce, returnURL string) {
+	// Retrieve the token from Google and validate it against our requirements.
+	claims, err := h.TokenVerifier.ValidateToken(idToken, nonce)
+	if err == tokenverifier.ErrNonceMismatch {
+		logger.For(pkg, "login").Warn("Login refused, the token was requested by another login attempt")
+		h.RenderError(w, r, http.StatusForbidden, "Your sign in could not be verified, please try again.")
+		return
+	}
+	if err != nil {
+		logger.For(pkg, "login").WithField("idToken", idToken).WithError(err).Error("Invalid token")
+		http.Error(w, "The presented claim is invalid.", http.StatusInternalServerError)
+		return
+	}
+	if h.ReplayCache != nil {
+		if err = h.ReplayCache.Use(idToken, claims); err != nil {
+			logger.For(pkg, "login").WithField("email", claims.Email).WithError(err).Warn("Login refused, the token has already been used")
+			h.RenderError(w, r, http.StatusForbidden, "Your sign in could not be verified, please try again.")
+			return
+		}
+	}
+	id := identity.Identity{
+		Email:        claims.Email,
+		Name:         claims.Name,
+		Picture:      claims.Picture,
+		HostedDomain: claims.HD,
+		Subject:      claims.Subject,
+		LoginTime:    time.Now(),
+	}
+	if h.Tokens != nil && token != nil && token.RefreshToken != "" {
+		if id.TokenID, err = h.Tokens.Save(token); err != nil {
+			logger.For(pkg, "login").WithField("email", claims.Email).WithError(err).Error("Error saving tokens")
+			h.RenderError(w, r, http.StatusInternalServerError, "Unable to start session.")
+			return
+		}
+	}
+	err = h.Session.Start(w, r, id)
+	if err == session.ErrTooManySessions {
+		logger.For(pkg, "login").WithField("email", claims.Email).Warn("Login refused, the user has too many sessions")
+		h.RenderError(w, r, http.StatusForbidden, "You are signed in on too many devices. Sign out on one of them, then try again.")
+		return
+	}
+	if err != nil {
+		logger.For(pkg, "login").WithField("email", claims.Email).WithError(err).Error("Error starting session")
+		h.RenderError(w, r, http.StatusInternalServerError, "Unable to start session.")
+		return
+	}
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// verifyCSRF checks the double-submit token posted to the callback, which prevents other
+// sites from logging the user in to an account of their choosing.
+func (h Handler) verifyCSRF(r *http.Request) error {
+	if _, ok := r.PostForm[GoogleCSRFTokenName]; ok {
+		return verifyDoubleSubmit(r, GoogleCSRFTokenName, GoogleCSRFTokenName)
+	}
+	if h.CSRF == nil {
+		return ErrCSRFTokenMismatch
+	}
+	return h.CSRF.Verify(r)
+}
+
+// logout ends the session, optionally revokes the user's Google grant, and shows the
+// logged out screen.
+func (h Handler) logout(w http.ResponseWriter, r *http.Request) {
+	// Logging out must be posted from this site, otherwise any page could log the user out,
+	// e.g. using an image.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !origin.Allowed(r, h.AllowedOrigins) {
+		logger.For(pkg, "logout").WithField("origin", r.Header.Get("Origin")).Warn("Rejected a logout posted from another site")
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+	var revocationToken string
+	if h.Tokens != nil {
+		// The session is validated without renewing it, since it's about to end.
+		if _, id, _ := h.Session.Validate(discardResponseWriter{}, r); id.TokenID != "" {
+			// The refresh token is read before it's deleted, so that the grant can be revoked.
+			var err error
+			if revocationToken, err = h.Tokens.RefreshToken(id.TokenID); err != nil && err != oidc.ErrNoAccessToken {
+				logger.For(pkg, "logout").WithField("email", id.Email).WithError(err).Warn("Unable to get refresh token")
+			}
+			if err := h.Tokens.Delete(id.TokenID); err != nil {
+				logger.For(pkg, "logout").WithField("email", id.Email).WithError(err).Warn("Unable to delete tokens")
+			}
+		}
+	}
+	if err := h.Session.End(w, r); err != nil {
+		logger.For(pkg, "logout").WithError(err).Error("Error ending session")
+		http.Error(w, "Unable to end session.", http.StatusInternalServerError)
+		return
+	}
+	if h.Revoker != nil {
+		if revocationToken == "" && h.RevocationToken != nil {
+			revocationToken = h.RevocationToken(r)
+		}
+		if revocationToken != "" {
+			if err := h.Revoker.Revoke(revocationToken); err != nil {
+				logger.For(pkg, "logout").WithError(err).Warn("Unable to revoke Google grant")
+			}
+		}
+	}
+	if h.LogoutRedirectURL != "" {
+		http.Redirect(w, r, h.LogoutRedirectURL, http.StatusSeeOther)
+		return
+	}
+	h.RenderLoggedOut(w, r)
+}
+
+// discardResponseWriter ignores anything written to it.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+func (h Handler) renderLogin(w http.ResponseWriter, r *http.Request, message string) {
+	p := Page{
+		CallbackPath: h.CallbackPath,
+		Message:      message,
+	}
+	if h.State != nil {
+		var err error
+		p.State, err = h.State.Encode(r.URL.RequestURI())
+		if err != nil {
+			logger.For(pkg, "renderLogin").WithField("url", r.URL.RequestURI()).WithError(err).Warn("Unable to record the return URL")
+		}
+	}
+	if h.CodeFlow != nil {
+		p.SignInURL = h.StartPath + "?" + url.Values{ReturnURLFieldName: []string{r.URL.RequestURI()}}.Encode()
+	}
+	if h.Nonce != nil {
+		var err error
+		p.Nonce, err = h.Nonce.Issue(w, r)
+		if err != nil {
+			logger.For(pkg, "renderLogin").WithError(err).Error("Unable to issue a nonce")
+		}
+	}
+	if h.CSRF != nil {
+		var err error
+		p.CSRFToken, err = h.CSRF.Issue(w, r)
+		if err != nil {
+			logger.For(pkg, "renderLogin").WithError(err).Error("Unable to issue a CSRF token")
+		}
+	}
+	p.LoginURI = callbackURL(r, h.CallbackPath, p.State)
+	h.RenderLogin(w, r, p)
+}
+
+// callbackURL returns the absolute URL of the callback on the host the request was made
+// to, with the state in the query.
+func callbackURL(r *http.Request, callbackPath, state string) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   r.Host,
+		Path:   callbackPath,
+	}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		u.Scheme = "https"
+	}
+	if state != "" {
+		u.RawQuery = url.Values{"state": []string{state}}.Encode()
+	}
+	return u.String()
+}
+
+// returnURL returns the URL recorded in the state, or "/" if the state is invalid or
+// has expired.
+func (h Handler) returnURL(state string) string {
+	if h.State == nil || state == "" {
+		return "/"
+	}
+	u, err := h.State.Decode(state)
+	if err != nil {
+		logger.For(pkg, "returnURL").WithError(err).Warn("Invalid state")
+		return "/"
+	}
+	return u
 }
@@ -7,21 +7,37 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/a-h/gauthmiddleware/identity"
 	"github.com/a-h/gauthmiddleware/session"
 	"github.com/a-h/gauthmiddleware/tokenverifier"
 )
 
 func TestHandler(t *testing.T) {
+	state := NewState([]byte("state_key"), DefaultStateMaxAge)
+	encodeState := func(u string) string {
+		s, err := state.Encode(u)
+		if err != nil {
+			t.Fatalf("failed to encode state: %v", err)
+		}
+		return s
+	}
+
 	tests := []struct {
 		name                  string
 		request               http.Request
+		omitCSRFToken         bool
 		session               session.Session
 		tokenVerifier         func(idToken string) (claim *tokenverifier.Claim, err error)
 		expectedNextCalled    bool
 		expectedContent       string
 		expectedLoginRendered bool
+		expectedReturnURL     string
+		expectedMessage       string
+		expectedRedirect      string
 	}{
 		{
 			name:    "not having a valid session shows the login screen",
@@ -33,12 +49,25 @@ func TestHandler(t *testing.T) {
 			expectedNextCalled:    false,
 			expectedContent:       "You must login",
 			expectedLoginRendered: true,
+			expectedReturnURL:     "/",
+		},
+		{
+			name:    "the login screen records the requested path and query",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports", RawQuery: "year=2017"},
+				Method: "GET",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "You must login",
+			expectedLoginRendered: true,
+			expectedReturnURL:     "/reports?year=2017",
 		},
 		{
 			name: "having an invalid session shows the login screen",
 			session: mockSession{
-				validateResponse:             false,
-				validateEmailAddressResponse: "marr@example.com",
+				validateResponse:         false,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
 			},
 			request: http.Request{
 				URL:    &url.URL{Path: "/"},
@@ -48,11 +77,62 @@ func TestHandler(t *testing.T) {
 			expectedContent:       "You must login",
 			expectedLoginRendered: true,
 		},
+		{
+			name: "having an expired session shows the login screen with a message",
+			session: mockSession{
+				validateResponse:         false,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
+				validateError:            session.ErrExpired,
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports"},
+				Method: "GET",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "You must login",
+			expectedLoginRendered: true,
+			expectedReturnURL:     "/reports",
+			expectedMessage:       "Your session has expired, please log in again.",
+		},
+		{
+			name: "having a session started by a different client shows the login screen with a message",
+			session: mockSession{
+				validateResponse:         false,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
+				validateError:            session.ErrBindingChanged,
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports"},
+				Method: "GET",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "You must login",
+			expectedLoginRendered: true,
+			expectedReturnURL:     "/reports",
+			expectedMessage:       "Your session could not be verified, please log in again.",
+		},
+		{
+			name: "having a revoked session shows the login screen with a message",
+			session: mockSession{
+				validateResponse:         false,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
+				validateError:            session.ErrRevoked,
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports"},
+				Method: "GET",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "You must login",
+			expectedLoginRendered: true,
+			expectedReturnURL:     "/reports",
+			expectedMessage:       "Your session has ended, please log in again.",
+		},
 		{
 			name: "having a valid session from an allowed domain shows the login screen",
 			session: mockSession{
-				validateResponse:             true,
-				validateEmailAddressResponse: "marr@example.com",
+				validateResponse:         true,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
 			},
 			request: http.Request{
 				URL:    &url.URL{Path: "/"},
@@ -63,7 +143,7 @@ func TestHandler(t *testing.T) {
 			expectedLoginRendered: false,
 		},
 		{
-			name: "POSTing validates the provided auth token - incorrect email address domain",
+			name: "POSTing to the callback validates the provided auth token - incorrect email address domain",
 			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
 				if idToken != "the_id_token" {
 					t.Errorf("POSTing - expected the_id_token")
@@ -71,11 +151,11 @@ func TestHandler(t *testing.T) {
 				return nil, errors.New("the user is not on the correct GSuite domain")
 			},
 			session: mockSession{
-				validateResponse:             true,
-				validateEmailAddressResponse: "marr@example.net",
+				validateResponse:         true,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.net"},
 			},
 			request: http.Request{
-				URL:    &url.URL{Path: "/"},
+				URL:    &url.URL{Path: DefaultCallbackPath},
 				Method: "POST",
 				Form: url.Values{
 					"id_token": []string{"the_id_token"},
@@ -86,7 +166,7 @@ func TestHandler(t *testing.T) {
 			expectedLoginRendered: false,
 		},
 		{
-			name: "POSTing validates the provided auth token - valid email address domain",
+			name: "POSTing to the callback validates the provided auth token - valid email address domain",
 			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
 				if idToken != "the_id_token" {
 					t.Errorf("POSTing - expected the_id_token")
@@ -96,45 +176,209 @@ func TestHandler(t *testing.T) {
 				}, nil
 			},
 			session: mockSession{
-				validateResponse:             true,
-				validateEmailAddressResponse: "marr@example.com",
+				validateResponse:         true,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
 			},
 			request: http.Request{
-				URL:    &url.URL{Path: "/"},
+				URL:    &url.URL{Path: DefaultCallbackPath},
 				Method: "POST",
 				Form: url.Values{
 					"id_token": []string{"the_id_token"},
+					"state":    []string{encodeState("/reports?year=2017")},
+				},
+			},
+			expectedNextCalled:    false,
+			expectedLoginRendered: false,
+			expectedRedirect:      "/reports?year=2017",
+		},
+		{
+			name: "POSTing to the callback when the user has too many sessions explains why they can't log in",
+			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
+				return &tokenverifier.Claim{
+					Email: "marr@example.com",
+				}, nil
+			},
+			session: mockSession{
+				startError: session.ErrTooManySessions,
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultCallbackPath},
+				Method: "POST",
+				Form: url.Values{
+					"id_token": []string{"the_id_token"},
+					"state":    []string{encodeState("/reports")},
+				},
+			},
+			expectedNextCalled:    false,
+			expectedLoginRendered: false,
+			expectedContent:       "You are signed in on too many devices.",
+		},
+		{
+			name: "POSTing to the callback when the session can't be started shows an error",
+			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
+				return &tokenverifier.Claim{
+					Email: "marr@example.com",
+				}, nil
+			},
+			session: mockSession{
+				startError: errors.New("the store is unavailable"),
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultCallbackPath},
+				Method: "POST",
+				Form: url.Values{
+					"id_token": []string{"the_id_token"},
+				},
+			},
+			expectedNextCalled:    false,
+			expectedLoginRendered: false,
+			expectedContent:       "Unable to start session.",
+		},
+		{
+			name: "POSTing to the callback with a tampered state redirects to the root",
+			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
+				return &tokenverifier.Claim{
+					Email: "marr@example.com",
+				}, nil
+			},
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultCallbackPath},
+				Method: "POST",
+				Form: url.Values{
+					"id_token": []string{"the_id_token"},
+					"state":    []string{encodeState("/reports") + "x"},
+				},
+			},
+			expectedNextCalled:    false,
+			expectedLoginRendered: false,
+			expectedRedirect:      "/",
+		},
+		{
+			name: "POSTing to the callback without a CSRF token is forbidden",
+			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
+				t.Errorf("POSTing without a CSRF token should not validate tokens")
+				return nil, nil
+			},
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultCallbackPath},
+				Method: "POST",
+				Form: url.Values{
+					"id_token": []string{"the_id_token"},
+				},
+			},
+			omitCSRFToken:         true,
+			expectedNextCalled:    false,
+			expectedLoginRendered: false,
+			expectedContent:       "Your sign in could not be verified, please try again.",
+		},
+		{
+			name:    "GETting the callback is not allowed",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultCallbackPath},
+				Method: "GET",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "Method not allowed.",
+			expectedLoginRendered: false,
+		},
+		{
+			name: "POSTing to other paths with a valid session is passed to the next handler",
+			tokenVerifier: func(idToken string) (claim *tokenverifier.Claim, err error) {
+				t.Errorf("POSTing to other paths should not validate tokens")
+				return nil, nil
+			},
+			session: mockSession{
+				validateResponse:         true,
+				validateIdentityResponse: identity.Identity{Email: "marr@example.com"},
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports"},
+				Method: "POST",
+				Form: url.Values{
+					"name": []string{"value"},
 				},
 			},
 			expectedNextCalled:    true,
 			expectedContent:       "Actual content",
 			expectedLoginRendered: false,
 		},
+		{
+			name:    "POSTing to other paths without a valid session shows the login screen",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: "/reports"},
+				Method: "POST",
+			},
+			expectedNextCalled:    false,
+			expectedContent:       "You must login",
+			expectedLoginRendered: true,
+		},
 	}
 
 	for _, test := range tests {
 		var actualNextCalled bool
+		var actualIdentity identity.Identity
 		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			actualNextCalled = true
+			actualIdentity, _ = identity.FromContext(r.Context())
 			w.Write([]byte("Actual content"))
 		})
 		var actualLoginRendered bool
-		loginRenderer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var actualPage Page
+		loginRenderer := func(w http.ResponseWriter, r *http.Request, p Page) {
 			actualLoginRendered = true
+			actualPage = p
 			w.Write([]byte("You must login"))
-		})
+		}
 		mtv := mockTokenVerifier{validator: test.tokenVerifier}
 		h := NewHandler(test.session, mtv, loginRenderer, next)
+		h.State = state
 
+		r := test.request
+		if r.Method == "POST" && r.URL.Path == DefaultCallbackPath && !test.omitCSRFToken {
+			r.Header = http.Header{}
+			r.AddCookie(&http.Cookie{Name: GoogleCSRFTokenName, Value: "csrf_token"})
+			r.PostForm = url.Values{GoogleCSRFTokenName: []string{"csrf_token"}}
+		}
 		w := httptest.NewRecorder()
-		h.ServeHTTP(w, &test.request)
+		h.ServeHTTP(w, &r)
 
 		if test.expectedLoginRendered != actualLoginRendered {
 			t.Errorf("%s: expected login rendered to be %v, but was %v", test.name, test.expectedLoginRendered, actualLoginRendered)
 		}
+		if test.expectedReturnURL != "" {
+			if actualPage.CallbackPath != DefaultCallbackPath {
+				t.Errorf("%s: expected callback path %q, but was %q", test.name, DefaultCallbackPath, actualPage.CallbackPath)
+			}
+			actualReturnURL, err := state.Decode(actualPage.State)
+			if err != nil {
+				t.Errorf("%s: failed to decode state: %v", test.name, err)
+			}
+			if test.expectedReturnURL != actualReturnURL {
+				t.Errorf("%s: expected return URL %q, but was %q", test.name, test.expectedReturnURL, actualReturnURL)
+			}
+		}
+		if test.expectedMessage != actualPage.Message {
+			t.Errorf("%s: expected message %q, but was %q", test.name, test.expectedMessage, actualPage.Message)
+		}
 		if test.expectedNextCalled != actualNextCalled {
 			t.Errorf("%s: expected next called of %v, but was %v", test.name, test.expectedNextCalled, actualNextCalled)
 		}
+		if actualNextCalled && actualIdentity.Email != "marr@example.com" {
+			t.Errorf("%s: expected the identity of marr@example.com to be passed to next, but was %v", test.name, actualIdentity)
+		}
+
+		if test.expectedRedirect != "" {
+			if w.Code != http.StatusSeeOther {
+				t.Errorf("%s: expected status %d, but was %d", test.name, http.StatusSeeOther, w.Code)
+			}
+			if actualRedirect := w.Header().Get("Location"); test.expectedRedirect != actualRedirect {
+				t.Errorf("%s: expected redirect to %q, but was %q", test.name, test.expectedRedirect, actualRedirect)
+			}
+		}
 
 		actualBody, err := ioutil.ReadAll(w.Result().Body)
 		if err != nil {
@@ -146,10 +390,293 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestLogout(t *testing.T) {
+	var revokedTokens []string
+	revocationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revokedTokens = append(revokedTokens, r.FormValue("token"))
+	}))
+	defer revocationServer.Close()
+
+	tests := []struct {
+		name                  string
+		request               http.Request
+		session               session.Session
+		logoutRedirectURL     string
+		expectedStatus        int
+		expectedContent       string
+		expectedRedirect      string
+		expectedRevokedTokens []string
+	}{
+		{
+			name:    "logging out shows the logged out screen",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "POST",
+				Host:   "example.com",
+				Header: http.Header{"Origin": []string{"https://example.com"}},
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: "You are signed out.",
+		},
+		{
+			name:    "logging out redirects to the logout redirect URL if set",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "POST",
+			},
+			logoutRedirectURL: "https://example.com/goodbye",
+			expectedStatus:    http.StatusSeeOther,
+			expectedRedirect:  "https://example.com/goodbye",
+		},
+		{
+			name:    "logging out revokes the posted token",
+			session: mockSession{},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "POST",
+				Form: url.Values{
+					"token": []string{"the_access_token"},
+				},
+			},
+			expectedStatus:        http.StatusOK,
+			expectedContent:       "You are signed out.",
+			expectedRevokedTokens: []string{"the_access_token"},
+		},
+		{
+			name: "failing to end the session returns an error",
+			session: mockSession{
+				endError: errors.New("failed"),
+			},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "POST",
+			},
+			expectedStatus:  http.StatusInternalServerError,
+			expectedContent: "Unable to end session.",
+		},
+		{
+			name:    "logging out with a GET request is not allowed, so that images can't log users out",
+			session: mockSession{endError: errors.New("unexpected call to end")},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "GET",
+			},
+			expectedStatus:  http.StatusMethodNotAllowed,
+			expectedContent: "Method not allowed.",
+		},
+		{
+			name:    "logging out from another site is forbidden",
+			session: mockSession{endError: errors.New("unexpected call to end")},
+			request: http.Request{
+				URL:    &url.URL{Path: DefaultLogoutPath},
+				Method: "POST",
+				Host:   "example.com",
+				Header: http.Header{"Origin": []string{"https://attacker.example.org"}},
+			},
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: "Forbidden.",
+		},
+	}
+
+	for _, test := range tests {
+		revokedTokens = nil
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: unexpected call to next", test.name)
+		})
+		loginRenderer := func(w http.ResponseWriter, r *http.Request, p Page) {
+			t.Errorf("%s: unexpected call to render login", test.name)
+		}
+		h := NewHandler(test.session, mockTokenVerifier{}, loginRenderer, next)
+		h.LogoutRedirectURL = test.logoutRedirectURL
+		h.Revoker = NewRevoker(revocationServer.URL)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, &test.request)
+
+		if test.expectedStatus != w.Code {
+			t.Errorf("%s: expected status %d, but was %d", test.name, test.expectedStatus, w.Code)
+		}
+		if actualRedirect := w.Header().Get("Location"); test.expectedRedirect != actualRedirect {
+			t.Errorf("%s: expected redirect to %q, but was %q", test.name, test.expectedRedirect, actualRedirect)
+		}
+		if !strings.Contains(w.Body.String(), test.expectedContent) {
+			t.Errorf("%s: expected body to contain %s, but it didn't: %s", test.name, test.expectedContent, w.Body.String())
+		}
+		if !reflect.DeepEqual(test.expectedRevokedTokens, revokedTokens) {
+			t.Errorf("%s: expected revoked tokens %v, but was %v", test.name, test.expectedRevokedTokens, revokedTokens)
+		}
+	}
+}
+
 type mockTokenVerifier struct {
 	validator func(idToken string) (claim *tokenverifier.Claim, err error)
 }
 
-func (m mockTokenVerifier) ValidateToken(idToken string) (claim *tokenverifier.Claim, err error) {
-	return m.validator(idToken)
+func (m mockTokenVerifier) ValidateToken(idToken string, nonce string) (claim *tokenverifier.Claim, err error) {
+	claim, err = m.validator(idToken)
+	if err == nil && nonce != "" && claim.Nonce != nonce {
+		err = tokenverifier.ErrNonceMismatch
+	}
+	return
+}
+
+func TestThatIDTokensAreTiedToTheLoginAttempt(t *testing.T) {
+	var page Page
+	h := NewHandler(mockSession{}, mockTokenVerifier{
+		validator: func(idToken string) (*tokenverifier.Claim, error) {
+			// The login screen sends the nonce to Google, which includes it in the token.
+			return &tokenverifier.Claim{Email: "marr@example.com", Nonce: strings.TrimPrefix(idToken, "token_for_")}, nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request, p Page) {
+		page = p
+	}, nil)
+	h.Nonce = NewNonce([]byte("nonce_key"), DefaultNonceMaxAge, false)
+	h.ReplayCache = tokenverifier.NewReplayCache()
+
+	startAttempt := func() (nonce string, cookies []*http.Cookie) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		if page.Nonce == "" {
+			t.Fatalf("expected the login screen to include a nonce")
+		}
+		return page.Nonce, w.Result().Cookies()
+	}
+	post := func(idToken string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{CredentialFieldName: []string{idToken}, GoogleCSRFTokenName: []string{"csrf_token"}}
+		r := httptest.NewRequest("POST", "http://example.com"+DefaultCallbackPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: GoogleCSRFTokenName, Value: "csrf_token"})
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	nonce, cookies := startAttempt()
+	w := post("token_for_"+nonce, cookies)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the token requested by the login attempt to be accepted, got status %d: %s", w.Code, w.Body.String())
+	}
+	if cleared := w.Result().Cookies(); len(cleared) != 1 || cleared[0].Name != DefaultNonceCookieName || cleared[0].MaxAge >= 0 {
+		t.Errorf("expected the nonce cookie to be cleared, got %v", cleared)
+	}
+
+	tests := []struct {
+		name    string
+		idToken func(nonce string) string
+		cookies bool
+	}{
+		{
+			name:    "tokens can't be replayed",
+			idToken: func(string) string { return "token_for_" + nonce },
+			cookies: true,
+		},
+		{
+			name:    "tokens requested by another login attempt are rejected",
+			idToken: func(string) string { return "token_for_another_nonce" },
+			cookies: true,
+		},
+		{
+			name:    "tokens are rejected without the nonce cookie",
+			idToken: func(nonce string) string { return "token_for_" + nonce },
+		},
+	}
+	for _, test := range tests {
+		nonce, cookies := startAttempt()
+		if !test.cookies {
+			cookies = nil
+		}
+		if w := post(test.idToken(nonce), cookies); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusForbidden, w.Code)
+		}
+	}
+}
+
+func TestThatRenderingTheLoginScreenAgainKeepsTheLoginAttempt(t *testing.T) {
+	var page Page
+	h := NewHandler(mockSession{}, mockTokenVerifier{
+		validator: func(idToken string) (*tokenverifier.Claim, error) {
+			return &tokenverifier.Claim{Email: "marr@example.com", Nonce: strings.TrimPrefix(idToken, "token_for_")}, nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request, p Page) {
+		page = p
+	}, nil)
+	h.Nonce = NewNonce([]byte("nonce_key"), DefaultNonceMaxAge, false)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	nonce, cookies := page.Nonce, w.Result().Cookies()
+
+	// Render the login screen again, e.g. in another tab, before the first one posts its token.
+	r := httptest.NewRequest("GET", "http://example.com/reports", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if page.Nonce != nonce {
+		t.Errorf("expected the nonce to be reused, got %q and %q", nonce, page.Nonce)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultNonceCookieName {
+			t.Errorf("expected the nonce cookie not to be replaced, got %v", c)
+		}
+	}
+
+	form := url.Values{CredentialFieldName: []string{"token_for_" + nonce}, GoogleCSRFTokenName: []string{"csrf_token"}}
+	r = httptest.NewRequest("POST", "http://example.com"+DefaultCallbackPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: GoogleCSRFTokenName, Value: "csrf_token"})
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("expected the token requested by the first login screen to be accepted, got status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCallbackURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		state    string
+		expected string
+	}{
+		{
+			name: "the callback is on the host the login screen was requested from",
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "http://example.com/reports", nil)
+			},
+			state:    "the_state=",
+			expected: "http://example.com/_gauth/callback?state=the_state%3D",
+		},
+		{
+			name: "HTTPS requests use an HTTPS callback",
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "https://example.com/reports", nil)
+			},
+			expected: "https://example.com/_gauth/callback",
+		},
+		{
+			name: "requests forwarded by a proxy which terminates TLS use an HTTPS callback",
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://example.com:8080/reports", nil)
+				r.Header.Set("X-Forwarded-Proto", "https")
+				return r
+			},
+			expected: "https://example.com:8080/_gauth/callback",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := callbackURL(test.request(), DefaultCallbackPath, test.state); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
 }
@@ -2,22 +2,30 @@ package login
 
 import (
 	"net/http"
+
+	"github.com/a-h/gauthmiddleware/identity"
 )
 
 type mockSession struct {
-	validateResponse             bool
-	validateEmailAddressResponse string
-	validateError                error
-	validateWasCalled            bool
-	startWasCalled               bool
+	validateResponse         bool
+	validateIdentityResponse identity.Identity
+	validateError            error
+	startError               error
+	endError                 error
+	validateWasCalled        bool
+	startWasCalled           bool
 }
 
-func (ms mockSession) Validate(r *http.Request) (isValid bool, emailAddress string, err error) {
+func (ms mockSession) Validate(w http.ResponseWriter, r *http.Request) (isValid bool, id identity.Identity, err error) {
 	ms.validateWasCalled = true
-	return ms.validateResponse, ms.validateEmailAddressResponse, ms.validateError
+	return ms.validateResponse, ms.validateIdentityResponse, ms.validateError
 }
 
-func (ms mockSession) Start(w http.ResponseWriter, r *http.Request, emailAddress string) error {
+func (ms mockSession) Start(w http.ResponseWriter, r *http.Request, id identity.Identity) error {
 	ms.startWasCalled = true
-	return nil
+	return ms.startError
+}
+
+func (ms mockSession) End(w http.ResponseWriter, r *http.Request) error {
+	return ms.endError
 }
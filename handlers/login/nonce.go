@@ -0,0 +1,94 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/gorilla/securecookie"
+)
+
+// DefaultNonceCookieName is the name of the cookie which holds the nonce of the login
+// attempt.
+const DefaultNonceCookieName = "gauth_nonce"
+
+// DefaultNonceMaxAge is how long the user has to complete the login attempt.
+const DefaultNonceMaxAge = 10 * time.Minute
+
+// Nonce issues a random value for each login attempt, which the login screen sends to
+// Google to be included in the ID token. It's kept in a short-lived signed cookie, so
+// that the callback only accepts tokens requested by this browser.
+type Nonce struct {
+	codec      *securecookie.SecureCookie
+	CookieName string
+	maxAge     time.Duration
+	secure     bool
+}
+
+// NewNonce creates a Nonce which signs the cookie using a key derived from the key, and
+// rejects cookies older than maxAge. If secure is set, the cookie is only sent over HTTPS.
+func NewNonce(key []byte, maxAge time.Duration, secure bool) *Nonce {
+	codec := securecookie.New(keys.Derive(key, "gauthmiddleware/login/nonce"), nil)
+	codec.MaxAge(int(maxAge.Seconds()))
+	return &Nonce{
+		codec:      codec,
+		CookieName: DefaultNonceCookieName,
+		maxAge:     maxAge,
+		secure:     secure,
+	}
+}
+
+// Issue creates a nonce for a new login attempt and sets it in the cookie. If the request
+// already has a valid nonce, it's reused, so that rendering the login screen again, e.g.
+// in another tab, doesn't invalidate the ID token requested by the first one.
+func (n *Nonce) Issue(w http.ResponseWriter, r *http.Request) (nonce string, err error) {
+	if nonce, err = n.Read(r); err == nil {
+		return nonce, nil
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce = base64.RawURLEncoding.EncodeToString(b)
+	value, err := n.codec.Encode("nonce", nonce)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, n.cookie(value, int(n.maxAge.Seconds())))
+	return nonce, nil
+}
+
+// Read returns the nonce of the login attempt from the cookie.
+func (n *Nonce) Read(r *http.Request) (nonce string, err error) {
+	c, err := r.Cookie(n.CookieName)
+	if err != nil {
+		return "", err
+	}
+	err = n.codec.Decode("nonce", c.Value, &nonce)
+	return
+}
+
+// Clear expires the cookie, so that the nonce can't be used for another login.
+func (n *Nonce) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, n.cookie("", -1))
+}
+
+func (n *Nonce) cookie(value string, maxAge int) *http.Cookie {
+	// When Google posts the ID token from its own site, SameSite=Lax cookies aren't sent,
+	// and SameSite=None requires a secure cookie.
+	sameSite := http.SameSiteLaxMode
+	if n.secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     n.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   n.secure,
+		SameSite: sameSite,
+	}
+}
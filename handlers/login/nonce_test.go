@@ -0,0 +1,105 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestNonce(t *testing.T) {
+	tests := []struct {
+		name          string
+		secure        bool
+		expectedSite  http.SameSite
+		tamper        bool
+		otherKey      bool
+		configuredKey bool
+		expectedValid bool
+	}{
+		{
+			name:          "the nonce can be read from the cookie",
+			expectedSite:  http.SameSiteLaxMode,
+			expectedValid: true,
+		},
+		{
+			name:          "secure cookies are sent when Google posts the token",
+			secure:        true,
+			expectedSite:  http.SameSiteNoneMode,
+			expectedValid: true,
+		},
+		{
+			name:         "tampered cookies are rejected",
+			expectedSite: http.SameSiteLaxMode,
+			tamper:       true,
+		},
+		{
+			name:         "cookies signed with another key are rejected",
+			expectedSite: http.SameSiteLaxMode,
+			otherKey:     true,
+		},
+		{
+			// The key is shared with other cookies, so cookies signed with it directly
+			// mustn't be accepted.
+			name:          "cookies signed with the configured key are rejected",
+			expectedSite:  http.SameSiteLaxMode,
+			configuredKey: true,
+		},
+	}
+
+	for _, test := range tests {
+		n := NewNonce([]byte("nonce_key"), DefaultNonceMaxAge, test.secure)
+		w := httptest.NewRecorder()
+		nonce, err := n.Issue(w, httptest.NewRequest("GET", "http://example.com", nil))
+		if err != nil {
+			t.Fatalf("%s: failed to issue nonce: %v", test.name, err)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].SameSite != test.expectedSite || cookies[0].Secure != test.secure || !cookies[0].HttpOnly {
+			t.Fatalf("%s: unexpected cookie %v", test.name, cookies)
+		}
+		if test.tamper {
+			cookies[0].Value += "x"
+		}
+		if test.otherKey {
+			n = NewNonce([]byte("other_key"), DefaultNonceMaxAge, test.secure)
+		}
+		if test.configuredKey {
+			if cookies[0].Value, err = securecookie.New([]byte("nonce_key"), nil).Encode("nonce", nonce); err != nil {
+				t.Fatalf("%s: failed to sign cookie: %v", test.name, err)
+			}
+		}
+		r := httptest.NewRequest("POST", "http://example.com", nil)
+		r.AddCookie(cookies[0])
+		actual, err := n.Read(r)
+		if valid := err == nil && actual == nonce; valid != test.expectedValid {
+			t.Errorf("%s: expected valid %v, got nonce %q, error %v", test.name, test.expectedValid, actual, err)
+		}
+	}
+}
+
+func TestThatValidNoncesAreReused(t *testing.T) {
+	n := NewNonce([]byte("nonce_key"), DefaultNonceMaxAge, false)
+	w := httptest.NewRecorder()
+	nonce, err := n.Issue(w, httptest.NewRequest("GET", "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("failed to issue nonce: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	reused, err := n.Issue(w, r)
+	if err != nil {
+		t.Fatalf("failed to issue nonce: %v", err)
+	}
+	if reused != nonce {
+		t.Errorf("expected the nonce in the cookie to be reused")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected the cookie not to be replaced, got %v", cookies)
+	}
+}
@@ -0,0 +1,39 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// GoogleRevocationURL is Google's OAuth 2.0 token revocation endpoint.
+const GoogleRevocationURL = "https://oauth2.googleapis.com/revoke"
+
+// A Revoker revokes the Google grant of a user when they log out.
+type Revoker struct {
+	// URL of the revocation endpoint, e.g. GoogleRevocationURL.
+	URL    string
+	Client *http.Client
+}
+
+// NewRevoker creates a Revoker which uses the revocation endpoint at url.
+func NewRevoker(url string) *Revoker {
+	return &Revoker{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Revoke revokes the access or refresh token, and the grant it was issued under.
+func (rv *Revoker) Revoke(token string) error {
+	resp, err := rv.Client.PostForm(rv.URL, url.Values{"token": []string{token}})
+	if err != nil {
+		return fmt.Errorf("Revoker: failed to revoke token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Revoker: failed to revoke token, unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
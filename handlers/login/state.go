@@ -0,0 +1,65 @@
+package login
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/gorilla/securecookie"
+)
+
+// DefaultStateMaxAge is how long the user has to complete the login once the
+// login screen has been shown.
+const DefaultStateMaxAge = 15 * time.Minute
+
+// State signs and verifies the URL the user was trying to access when the login
+// screen was shown, so that they can be returned there once they've logged in.
+type State struct {
+	codec *securecookie.SecureCookie
+}
+
+// NewState creates a State which signs values using a key derived from the key, and
+// rejects values older than maxAge.
+func NewState(key []byte, maxAge time.Duration) *State {
+	codec := securecookie.New(keys.Derive(key, "gauthmiddleware/login/state"), nil)
+	codec.MaxAge(int(maxAge.Seconds()))
+	return &State{
+		codec: codec,
+	}
+}
+
+// Encode signs the return URL. Only URLs on this site can be encoded.
+func (s *State) Encode(returnURL string) (state string, err error) {
+	if !isLocalURL(returnURL) {
+		return "", errors.New("State.Encode: the return URL must be a path on this site")
+	}
+	return s.codec.Encode("state", returnURL)
+}
+
+// Decode verifies the signature and age of the state and returns the URL it contains.
+func (s *State) Decode(state string) (returnURL string, err error) {
+	if err = s.codec.Decode("state", state, &returnURL); err != nil {
+		return "", err
+	}
+	if !isLocalURL(returnURL) {
+		return "", errors.New("State.Decode: the return URL must be a path on this site")
+	}
+	return returnURL, nil
+}
+
+// isLocalURL returns true if the URL is a path on this site, rather than an
+// absolute URL or protocol-relative URL which would redirect to another site.
+func isLocalURL(u string) bool {
+	if len(u) == 0 || u[0] != '/' {
+		return false
+	}
+	if len(u) > 1 && (u[1] == '/' || u[1] == '\\') {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return !parsed.IsAbs() && parsed.Host == ""
+}
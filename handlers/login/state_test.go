@@ -0,0 +1,64 @@
+package login
+
+import (
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestThatOnlyLocalURLsCanBeUsedAsReturnURLs(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{url: "/", expected: true},
+		{url: "/reports?year=2017#summary", expected: true},
+		{url: "", expected: false},
+		{url: "reports", expected: false},
+		{url: "https://example.net/reports", expected: false},
+		{url: "//example.net/reports", expected: false},
+		{url: "/\\example.net/reports", expected: false},
+		{url: "/\t/example.net/reports", expected: false},
+		{url: "javascript:alert(1)", expected: false},
+	}
+
+	s := NewState([]byte("state_key"), DefaultStateMaxAge)
+	for _, test := range tests {
+		state, err := s.Encode(test.url)
+		if test.expected != (err == nil) {
+			t.Errorf("%q: expected encoding to succeed to be %v, but got error %v", test.url, test.expected, err)
+		}
+		if err != nil {
+			continue
+		}
+		actual, err := s.Decode(state)
+		if err != nil {
+			t.Errorf("%q: unexpected error decoding state: %v", test.url, err)
+		}
+		if test.url != actual {
+			t.Errorf("%q: expected to decode the same URL, but got %q", test.url, actual)
+		}
+	}
+}
+
+func TestThatStateSignedWithAnotherKeyIsRejected(t *testing.T) {
+	state, err := NewState([]byte("another_key"), DefaultStateMaxAge).Encode("/reports")
+	if err != nil {
+		t.Fatalf("unexpected error encoding state: %v", err)
+	}
+	if _, err = NewState([]byte("state_key"), DefaultStateMaxAge).Decode(state); err == nil {
+		t.Error("expected state signed with another key to be rejected")
+	}
+}
+
+func TestThatStateSignedWithTheConfiguredKeyIsRejected(t *testing.T) {
+	// The key is shared with other cookies, so values signed with it directly, rather than
+	// the key derived for the state, mustn't be accepted.
+	state, err := securecookie.New([]byte("state_key"), nil).Encode("state", "/reports")
+	if err != nil {
+		t.Fatalf("unexpected error encoding state: %v", err)
+	}
+	if _, err = NewState([]byte("state_key"), DefaultStateMaxAge).Decode(state); err == nil {
+		t.Error("expected state signed with the configured key to be rejected")
+	}
+}
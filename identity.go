@@ -0,0 +1,31 @@
+package gauthmiddleware
+
+import (
+	"context"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/oidc"
+)
+
+// Identity is the user who has logged in.
+type Identity = identity.Identity
+
+// IdentityFromContext returns the Identity of the logged in user. The middleware adds it
+// to the context of each request it passes to the next handler, e.g.
+//
+//	id, ok := gauthmiddleware.IdentityFromContext(r.Context())
+func IdentityFromContext(ctx context.Context) (id Identity, ok bool) {
+	return identity.FromContext(ctx)
+}
+
+// ErrNoAccessToken is returned by AccessTokenFromContext when no refresh token was obtained
+// when the user signed in, e.g. because OIDC_OFFLINE_ACCESS isn't enabled.
+var ErrNoAccessToken = oidc.ErrNoAccessToken
+
+// AccessTokenFromContext returns a valid access token for the logged in user, refreshing it
+// if it has expired, so that the next handler can call Google APIs on their behalf, e.g.
+//
+//	accessToken, err := gauthmiddleware.AccessTokenFromContext(r.Context())
+func AccessTokenFromContext(ctx context.Context) (accessToken string, err error) {
+	return oidc.AccessTokenFromContext(ctx)
+}
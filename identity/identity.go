@@ -0,0 +1,50 @@
+package identity
+
+import (
+	"context"
+	"time"
+)
+
+// Identity is the user who has logged in.
+type Identity struct {
+	// Email is the user's email address, e.g. "testuser@gmail.com".
+	Email string
+	// Name is the user's full name, e.g. "Test User".
+	Name string
+	// Picture is the URL of the user's profile picture.
+	Picture string
+	// HostedDomain is the user's GSuite domain, e.g. "infinityworks.com".
+	HostedDomain string
+	// Subject is the stable identifier of the user's Google account. Unlike the email
+	// address, it never changes.
+	Subject string
+	// LoginTime is when the user logged in.
+	LoginTime time.Time
+	// TokenID finds the OAuth 2.0 tokens obtained when the user logged in, which are held
+	// on the server. It's empty if no tokens were kept.
+	TokenID string
+}
+
+// UserID returns a stable key for the user, the Subject, or the Email if the Subject
+// isn't known.
+func (id Identity) UserID() string {
+	if id.Subject != "" {
+		return id.Subject
+	}
+	return id.Email
+}
+
+type contextKey int
+
+const identityContextKey contextKey = 0
+
+// NewContext returns a copy of the context which carries the Identity.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey, id)
+}
+
+// FromContext returns the Identity carried by the context, if any.
+func FromContext(ctx context.Context) (id Identity, ok bool) {
+	id, ok = ctx.Value(identityContextKey).(Identity)
+	return
+}
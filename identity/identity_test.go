@@ -0,0 +1,32 @@
+package identity
+
+import (
+	"context"
+	"testing"
+)
+
+func TestThatAnIdentityCanBeRetrievedFromAContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("expected no identity in an empty context")
+	}
+
+	ctx := NewContext(context.Background(), Identity{Email: "test@example.com"})
+	id, ok := FromContext(ctx)
+	if !ok {
+		t.Fatal("expected an identity in the context")
+	}
+	if id.Email != "test@example.com" {
+		t.Errorf("expected email test@example.com, got %v", id.Email)
+	}
+}
+
+func TestThatTheUserIDIsTheSubjectWhenKnown(t *testing.T) {
+	id := Identity{Email: "test@example.com"}
+	if id.UserID() != "test@example.com" {
+		t.Errorf("expected the email to be used when the subject is not known, got %v", id.UserID())
+	}
+	id.Subject = "110169484474386276334"
+	if id.UserID() != "110169484474386276334" {
+		t.Errorf("expected the subject to be used, got %v", id.UserID())
+	}
+}
@@ -0,0 +1,16 @@
+// Package keys derives keys for specific purposes from the keys in the configuration.
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Derive derives a 32 byte key for the purpose from the key, so that the same key is never
+// used for more than one purpose, e.g. for both authentication and encryption, or for two
+// different cookies. The purpose should be unique, e.g. "gauthmiddleware/session/encryption".
+func Derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,23 @@
+package keys
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	key := []byte("the_key")
+	a, b := Derive(key, "a"), Derive(key, "b")
+	if len(a) != 32 {
+		t.Errorf("expected a 32 byte key, got %d bytes", len(a))
+	}
+	if !bytes.Equal(a, Derive(key, "a")) {
+		t.Errorf("expected the same key to be derived for the same purpose")
+	}
+	if bytes.Equal(a, b) {
+		t.Errorf("expected different keys to be derived for different purposes")
+	}
+	if bytes.Equal(a, Derive([]byte("another_key"), "a")) {
+		t.Errorf("expected different keys to be derived from different keys")
+	}
+}
@@ -0,0 +1,41 @@
+// Package origin checks that forms which change state, e.g. logging out, were posted from
+// this site, so that other sites can't post them on behalf of the user.
+package origin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Allowed returns true if the request was sent from a page on one of the allowed origins,
+// e.g. "https://example.com", using the Origin header, or the Referer if it's missing.
+// Requests with neither are allowed, since browsers send at least one with cross-site
+// posts.
+//
+// If no origins are allowed, the request must be sent from a page on the host it was
+// sent to. Reverse proxies which rewrite the Host header, e.g. to the address of the
+// application, make every request appear to come from another site, so the public origin
+// of the site must be allowed instead.
+func Allowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(allowed) == 0 {
+		return u.Host == r.Host
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package origin
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		referer  string
+		allowed  []string
+		expected bool
+	}{
+		{
+			name:     "requests without an origin or referer are allowed",
+			expected: true,
+		},
+		{
+			name:     "requests from the host are allowed",
+			origin:   "https://example.com",
+			expected: true,
+		},
+		{
+			name:     "requests from other sites are rejected",
+			origin:   "https://attacker.example.org",
+			expected: false,
+		},
+		{
+			name:     "the referer is used if the origin is missing",
+			referer:  "https://attacker.example.org/page",
+			expected: false,
+		},
+		{
+			name:     "requests from an allowed origin are allowed, whatever the host",
+			origin:   "https://public.example.org",
+			allowed:  []string{"https://public.example.org/"},
+			expected: true,
+		},
+		{
+			name:     "requests from the host are rejected if it isn't an allowed origin",
+			origin:   "https://example.com",
+			allowed:  []string{"https://public.example.org"},
+			expected: false,
+		},
+		{
+			name:     "the scheme of allowed origins must match",
+			referer:  "http://public.example.org/page",
+			allowed:  []string{"https://public.example.org"},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "https://example.com/_gauth/logout", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		if actual := Allowed(r, test.allowed); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE, so that
+// users can sign in without JavaScript, and access and refresh tokens can be obtained.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GoogleAuthorizationEndpoint is where Google users are sent to sign in.
+const GoogleAuthorizationEndpoint = "https://accounts.google.com/o/oauth2/v2/auth"
+
+// GoogleTokenEndpoint is where Google authorization codes are exchanged for tokens.
+const GoogleTokenEndpoint = "https://oauth2.googleapis.com/token"
+
+// DefaultScopes are the scopes requested if none are configured.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Config is the configuration of the OAuth 2.0 client.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// AuthorizationEndpoint is where the user is sent to sign in, e.g.
+	// GoogleAuthorizationEndpoint.
+	AuthorizationEndpoint string
+	// TokenEndpoint is where the authorization code is exchanged for tokens, e.g.
+	// GoogleTokenEndpoint.
+	TokenEndpoint string
+	// RedirectURL is the callback the user is sent back to with the authorization code. It
+	// must be registered with the authorization server.
+	RedirectURL string
+	// Scopes are the scopes requested. If empty, DefaultScopes are requested.
+	Scopes []string
+	// Offline requests a refresh token, so that access tokens can be obtained once the user
+	// has left. The user is asked for consent each time they sign in, because Google only
+	// issues a refresh token when consent is given.
+	Offline bool
+}
+
+// A Client sends users to the authorization endpoint, and exchanges the authorization
+// codes they return with for tokens.
+type Client struct {
+	Config
+	HTTPClient *http.Client
+}
+
+// NewClient creates a Client using the configuration.
+func NewClient(config Config) *Client {
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Token is the response of the token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	// Expiry is when the access token expires, calculated from ExpiresIn.
+	Expiry time.Time `json:"-"`
+}
+
+// Error is an error response from the token endpoint, e.g. "invalid_grant" when the code
+// has already been used.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+// NewCodeVerifier creates a random PKCE code verifier, which must be kept secret until the
+// code is exchanged.
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewState creates a random value, e.g. for the state or nonce of an authorization request.
+func NewState() (string, error) {
+	return randomString(32)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the code verifier.
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint which the user is sent to. The
+// state is returned to the callback, and the nonce is included in the ID token.
+func (c *Client) AuthCodeURL(state, nonce, codeVerifier string) string {
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	v := url.Values{
+		"response_type":         []string{"code"},
+		"client_id":             []string{c.ClientID},
+		"redirect_uri":          []string{c.RedirectURL},
+		"scope":                 []string{strings.Join(scopes, " ")},
+		"state":                 []string{state},
+		"nonce":                 []string{nonce},
+		"code_challenge":        []string{CodeChallenge(codeVerifier)},
+		"code_challenge_method": []string{"S256"},
+	}
+	if c.Offline {
+		v.Set("access_type", "offline")
+		v.Set("prompt", "consent")
+	}
+	sep := "?"
+	if strings.Contains(c.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange exchanges the authorization code for tokens, proving that this client started
+// the flow using the code verifier.
+func (c *Client) Exchange(code, codeVerifier string) (*Token, error) {
+	token, err := c.token(url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"redirect_uri":  []string{c.RedirectURL},
+		"code_verifier": []string{codeVerifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("Client.Exchange: the token endpoint didn't return an ID token")
+	}
+	return token, nil
+}
+
+// Refresh uses the refresh token to obtain a new access token. If the token endpoint doesn't
+// issue a new refresh token, the returned token contains the one which was used.
+func (c *Client) Refresh(refreshToken string) (*Token, error) {
+	token, err := c.token(url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+// token posts the request to the token endpoint, authenticating using the client secret.
+// Error responses are returned as an *Error.
+func (c *Client) token(v url.Values) (*Token, error) {
+	v.Set("client_id", c.ClientID)
+	v.Set("client_secret", c.ClientSecret)
+	req, err := http.NewRequest("POST", c.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Client.token: failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Client.token: request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("Client.token: failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{}
+		if err = json.Unmarshal(body, e); err != nil || e.Code == "" {
+			return nil, fmt.Errorf("Client.token: unexpected status %d", resp.StatusCode)
+		}
+		return nil, e
+	}
+	token := &Token{}
+	if err = json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("Client.token: failed to unmarshal response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("Client.token: the token endpoint didn't return an access token")
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,144 @@
+package oidc_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/a-h/gauthmiddleware/oidc"
+	"github.com/a-h/gauthmiddleware/oidc/oidctest"
+)
+
+// authorize follows the authorization URL, and returns the query of the redirect back to
+// the callback.
+func authorize(t *testing.T, authorizationURL string) url.Values {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authorizationURL)
+	if err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect to the callback, got status %d", resp.StatusCode)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("failed to read redirect: %v", err)
+	}
+	return location.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s := oidctest.NewServer("the_client_id", "the_client_secret")
+	defer s.Close()
+
+	tests := []struct {
+		name         string
+		clientSecret string
+		codeVerifier func(codeVerifier string) string
+		exchanges    int
+		expectedErr  string
+	}{
+		{
+			name:         "codes can be exchanged for tokens",
+			clientSecret: "the_client_secret",
+			codeVerifier: func(v string) string { return v },
+			exchanges:    1,
+		},
+		{
+			name:         "codes can only be exchanged once",
+			clientSecret: "the_client_secret",
+			codeVerifier: func(v string) string { return v },
+			exchanges:    2,
+			expectedErr:  "invalid_grant",
+		},
+		{
+			name:         "codes can't be exchanged without the code verifier",
+			clientSecret: "the_client_secret",
+			codeVerifier: func(v string) string { return v + "x" },
+			exchanges:    1,
+			expectedErr:  "invalid_grant",
+		},
+		{
+			name:         "codes can't be exchanged without the client secret",
+			clientSecret: "another_secret",
+			codeVerifier: func(v string) string { return v },
+			exchanges:    1,
+			expectedErr:  "invalid_client",
+		},
+	}
+
+	for _, test := range tests {
+		client := oidc.NewClient(oidc.Config{
+			ClientID:              "the_client_id",
+			ClientSecret:          test.clientSecret,
+			AuthorizationEndpoint: s.AuthorizationEndpoint(),
+			TokenEndpoint:         s.TokenEndpoint(),
+			RedirectURL:           "https://example.com/_gauth/callback",
+		})
+		codeVerifier, err := oidc.NewCodeVerifier()
+		if err != nil {
+			t.Fatalf("%s: failed to create code verifier: %v", test.name, err)
+		}
+		callback := authorize(t, client.AuthCodeURL("the_state", "the_nonce", codeVerifier))
+		if callback.Get("state") != "the_state" {
+			t.Errorf("%s: expected the state to be returned, got %q", test.name, callback.Get("state"))
+		}
+
+		var token *oidc.Token
+		for i := 0; i < test.exchanges; i++ {
+			token, err = client.Exchange(callback.Get("code"), test.codeVerifier(codeVerifier))
+		}
+		if test.expectedErr != "" {
+			if e, ok := err.(*oidc.Error); !ok || e.Code != test.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if token.IDToken != "id_token_for_the_nonce" {
+			t.Errorf("%s: expected the ID token to contain the nonce, got %q", test.name, token.IDToken)
+		}
+		if token.AccessToken == "" || token.Expiry.IsZero() {
+			t.Errorf("%s: expected an access token with an expiry, got %+v", test.name, token)
+		}
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	client := oidc.NewClient(oidc.Config{
+		ClientID:              "the_client_id",
+		AuthorizationEndpoint: "https://accounts.example.com/auth?hd=example.com",
+		RedirectURL:           "https://example.com/_gauth/callback",
+	})
+	u, err := url.Parse(client.AuthCodeURL("the_state", "the_nonce", "the_code_verifier"))
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	expected := map[string]string{
+		"hd":                    "example.com",
+		"response_type":         "code",
+		"client_id":             "the_client_id",
+		"redirect_uri":          "https://example.com/_gauth/callback",
+		"scope":                 "openid email profile",
+		"state":                 "the_state",
+		"nonce":                 "the_nonce",
+		"code_challenge":        oidc.CodeChallenge("the_code_verifier"),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range expected {
+		if actual := u.Query().Get(k); actual != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, actual)
+		}
+	}
+	// The example from RFC 7636, appendix B.
+	if actual := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); actual != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unexpected code challenge %q", actual)
+	}
+}
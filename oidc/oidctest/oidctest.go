@@ -0,0 +1,247 @@
+// Package oidctest contains a fake OAuth 2.0 authorization server, for testing the
+// authorization code flow without calling Google.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/a-h/gauthmiddleware/oidc"
+)
+
+// Server is a fake authorization server. Users are signed in as soon as they're sent to
+// the authorization endpoint, and redirected back with a code which can be exchanged once.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	// IDToken returns the ID token issued for the nonce of the authorization request. By
+	// default, it's "id_token_for_" followed by the nonce.
+	IDToken func(nonce string) string
+	// Denied makes the authorization endpoint redirect back with an access_denied error.
+	Denied bool
+	// ExpiresIn is the lifetime of the access tokens issued, in seconds. Defaults to 3600.
+	ExpiresIn int
+
+	m             sync.Mutex
+	codes         map[string]grant
+	refreshTokens map[string]bool
+	issued        int
+	keyOnce       sync.Once
+	key           *rsa.PrivateKey
+}
+
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	offline     bool
+}
+
+// NewServer starts a fake authorization server which accepts the client credentials. It
+// must be closed once the test is complete.
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		IDToken: func(nonce string) string {
+			return "id_token_for_" + nonce
+		},
+		ExpiresIn:     3600,
+		codes:         make(map[string]grant),
+		refreshTokens: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AuthorizationEndpoint is the URL of the fake authorization endpoint.
+func (s *Server) AuthorizationEndpoint() string {
+	return s.URL + "/authorize"
+}
+
+// TokenEndpoint is the URL of the fake token endpoint.
+func (s *Server) TokenEndpoint() string {
+	return s.URL + "/token"
+}
+
+// Issuer is the iss claim of ID tokens signed by the server.
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// JWKSURL is the URL where the key which signs ID tokens is published.
+func (s *Server) JWKSURL() string {
+	return s.URL + "/jwks"
+}
+
+// SignIDToken signs the claims using RS256, so that they can be returned by IDToken and
+// verified using the key published at the JWKSURL. It panics if the claims can't be
+// encoded.
+func (s *Server) SignIDToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "oidctest", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic("oidctest: failed to encode claims: " + err.Error())
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.signingKey(), crypto.SHA256, hash[:])
+	if err != nil {
+		panic("oidctest: failed to sign claims: " + err.Error())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// signingKey returns the key which signs ID tokens. It's only generated when it's first
+// used, since most tests don't need signed tokens.
+func (s *Server) signingKey() *rsa.PrivateKey {
+	s.keyOnce.Do(func() {
+		var err error
+		if s.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic("oidctest: failed to generate key: " + err.Error())
+		}
+	})
+	return s.key
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	key := s.signingKey()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "oidctest",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	v := redirect.Query()
+	v.Set("state", q.Get("state"))
+	if s.Denied {
+		v.Set("error", "access_denied")
+	} else {
+		s.m.Lock()
+		s.issued++
+		code := "code_" + strconv.Itoa(s.issued)
+		s.codes[code] = grant{
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			nonce:       q.Get("nonce"),
+			offline:     q.Get("access_type") == "offline",
+		}
+		s.m.Unlock()
+		v.Set("code", code)
+	}
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.PostFormValue("client_id") != s.ClientID || r.PostFormValue("client_secret") != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, oidc.Error{Code: "invalid_client"})
+		return
+	}
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+	case "refresh_token":
+		s.refresh(w, r)
+		return
+	default:
+		writeJSON(w, http.StatusBadRequest, oidc.Error{Code: "unsupported_grant_type"})
+		return
+	}
+	s.m.Lock()
+	code := r.PostFormValue("code")
+	g, ok := s.codes[code]
+	// Codes can only be used once.
+	delete(s.codes, code)
+	s.m.Unlock()
+	if !ok || g.redirectURI != r.PostFormValue("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, oidc.Error{Code: "invalid_grant", Description: "the code is invalid"})
+		return
+	}
+	if oidc.CodeChallenge(r.PostFormValue("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, oidc.Error{Code: "invalid_grant", Description: "the code verifier doesn't match the challenge"})
+		return
+	}
+	resp := map[string]interface{}{
+		"access_token": "access_token_for_" + code,
+		"token_type":   "Bearer",
+		"expires_in":   s.ExpiresIn,
+		"id_token":     s.IDToken(g.nonce),
+	}
+	if g.offline {
+		refreshToken := "refresh_token_for_" + code
+		s.m.Lock()
+		s.refreshTokens[refreshToken] = true
+		s.m.Unlock()
+		resp["refresh_token"] = refreshToken
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// refresh issues a new access token for a refresh token which hasn't been revoked.
+func (s *Server) refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostFormValue("refresh_token")
+	s.m.Lock()
+	ok := s.refreshTokens[refreshToken]
+	s.issued++
+	accessToken := "access_token_" + strconv.Itoa(s.issued) + "_for_" + refreshToken
+	s.m.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, oidc.Error{Code: "invalid_grant", Description: "the refresh token is invalid"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   s.ExpiresIn,
+	})
+}
+
+// Revoke revokes the refresh token, so that it can no longer be used.
+func (s *Server) Revoke(refreshToken string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.refreshTokens, refreshToken)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
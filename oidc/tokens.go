@@ -0,0 +1,316 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/gorilla/securecookie"
+)
+
+// ErrNoAccessToken is returned by AccessTokenFromContext when no tokens were obtained
+// when the user signed in, e.g. because offline access wasn't requested.
+var ErrNoAccessToken = errors.New("oidc: no access token is available for the session")
+
+// DefaultTokenLifetime is how long tokens are kept by Tokens, it should be at least the
+// lifetime of the session.
+const DefaultTokenLifetime = 7 * 24 * time.Hour
+
+// expiryMargin is how long before it expires that an access token is refreshed, so that it
+// doesn't expire while it's being used.
+const expiryMargin = time.Minute
+
+// A TokenStore holds the encrypted tokens of each session on the server.
+type TokenStore interface {
+	// Get returns the value with the ID. ok is false if it doesn't exist or has expired.
+	Get(id string) (value []byte, ok bool, err error)
+	// Put creates or replaces the value, which can be deleted once it expires.
+	Put(id string, value []byte, expires time.Time) error
+	// Replace replaces the value, if it exists and hasn't expired. ok is false if it
+	// doesn't, so that tokens which are deleted while they're being refreshed stay deleted.
+	Replace(id string, value []byte, expires time.Time) (ok bool, err error)
+	// Delete removes the value. It's not an error if it doesn't exist.
+	Delete(id string) error
+}
+
+// MemoryTokenStore is a TokenStore which holds tokens in memory. Tokens are lost when the
+// process restarts, and aren't shared between instances.
+type MemoryTokenStore struct {
+	m       sync.Mutex
+	values  map[string]memoryToken
+	now     func() time.Time
+	pruneAt time.Time
+}
+
+type memoryToken struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryTokenStore creates an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		values: make(map[string]memoryToken),
+		now:    time.Now,
+	}
+}
+
+// Get returns the value with the ID.
+func (s *MemoryTokenStore) Get(id string) (value []byte, ok bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	t, ok := s.values[id]
+	if !ok || !s.now().Before(t.expires) {
+		return nil, false, nil
+	}
+	return t.value, true, nil
+}
+
+// Put creates or replaces the value, and removes expired values.
+func (s *MemoryTokenStore) Put(id string, value []byte, expires time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := s.now()
+	if now.After(s.pruneAt) {
+		for k, t := range s.values {
+			if !now.Before(t.expires) {
+				delete(s.values, k)
+			}
+		}
+		s.pruneAt = now.Add(time.Minute)
+	}
+	s.values[id] = memoryToken{value: value, expires: expires}
+	return nil
+}
+
+// Replace replaces the value, if it exists.
+func (s *MemoryTokenStore) Replace(id string, value []byte, expires time.Time) (ok bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	t, ok := s.values[id]
+	if !ok || !s.now().Before(t.expires) {
+		return false, nil
+	}
+	s.values[id] = memoryToken{value: value, expires: expires}
+	return true, nil
+}
+
+// Delete removes the value.
+func (s *MemoryTokenStore) Delete(id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.values, id)
+	return nil
+}
+
+// Tokens keeps the access and refresh tokens obtained when each user signed in, encrypted,
+// in a TokenStore, and refreshes access tokens when they expire.
+type Tokens struct {
+	Client *Client
+	Store  TokenStore
+	// Lifetime is how long tokens are kept after they're saved or refreshed.
+	Lifetime time.Duration
+	codec    securecookie.Codec
+	// refreshMutex guards refreshing.
+	refreshMutex sync.Mutex
+	// refreshing holds a lock for each ID whose access token is being refreshed, which
+	// prevents concurrent requests from using the same refresh token, without holding up
+	// requests made by other users.
+	refreshing map[string]*refreshLock
+}
+
+type refreshLock struct {
+	sync.Mutex
+	// waiters is the number of requests holding or waiting for the lock.
+	waiters int
+}
+
+// storedToken is the encrypted value held in the TokenStore.
+type storedToken struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// NewTokens creates Tokens which encrypt the tokens using keys derived from the key.
+func NewTokens(client *Client, store TokenStore, key []byte) *Tokens {
+	codec := securecookie.New(keys.Derive(key, "gauthmiddleware/oidc/tokens/authentication"),
+		keys.Derive(key, "gauthmiddleware/oidc/tokens/encryption"))
+	// Tokens are kept for as long as the store holds them.
+	codec.MaxAge(0)
+	codec.MaxLength(0)
+	return &Tokens{
+		Client:   client,
+		Store:    store,
+		Lifetime: DefaultTokenLifetime,
+		codec:    codec,
+	}
+}
+
+// Save stores the token, and returns the random ID used to find it again.
+func (t *Tokens) Save(token *Token) (id string, err error) {
+	if id, err = NewState(); err != nil {
+		return "", fmt.Errorf("Tokens.Save: failed to create ID: %v", err)
+	}
+	if err = t.put(id, storedToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}, false); err != nil {
+		return "", fmt.Errorf("Tokens.Save: %v", err)
+	}
+	return id, nil
+}
+
+// AccessToken returns a valid access token for the ID, refreshing it if it has expired. If
+// the refresh token has been revoked, the tokens are deleted. If the tokens are deleted
+// while they're being refreshed, e.g. because the user logged out, they stay deleted, and
+// ErrNoAccessToken is returned.
+func (t *Tokens) AccessToken(id string) (string, error) {
+	st, err := t.get(id)
+	if err != nil || valid(st) {
+		return st.AccessToken, err
+	}
+	defer t.lockRefresh(id)()
+	// Another request may have refreshed the token while this one was waiting.
+	if st, err = t.get(id); err != nil || valid(st) {
+		return st.AccessToken, err
+	}
+	if st.RefreshToken == "" {
+		return "", ErrNoAccessToken
+	}
+	token, err := t.Client.Refresh(st.RefreshToken)
+	if e, ok := err.(*Error); ok && e.Code == "invalid_grant" {
+		t.Store.Delete(id)
+		return "", fmt.Errorf("Tokens.AccessToken: the refresh token is no longer valid: %v", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Tokens.AccessToken: failed to refresh the access token: %v", err)
+	}
+	st = storedToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
+	if err = t.put(id, st, true); err == ErrNoAccessToken {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("Tokens.AccessToken: %v", err)
+	}
+	return st.AccessToken, nil
+}
+
+// lockRefresh locks the ID while its access token is refreshed, and returns the function
+// which unlocks it.
+func (t *Tokens) lockRefresh(id string) (unlock func()) {
+	t.refreshMutex.Lock()
+	if t.refreshing == nil {
+		t.refreshing = make(map[string]*refreshLock)
+	}
+	l, ok := t.refreshing[id]
+	if !ok {
+		l = &refreshLock{}
+		t.refreshing[id] = l
+	}
+	l.waiters++
+	t.refreshMutex.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.refreshMutex.Lock()
+		defer t.refreshMutex.Unlock()
+		if l.waiters--; l.waiters == 0 {
+			delete(t.refreshing, id)
+		}
+	}
+}
+
+// RefreshToken returns the refresh token with the ID, e.g. so that it can be revoked when
+// the user logs out. ErrNoAccessToken is returned if there are no tokens with the ID.
+func (t *Tokens) RefreshToken(id string) (string, error) {
+	st, err := t.get(id)
+	if err != nil {
+		return "", err
+	}
+	return st.RefreshToken, nil
+}
+
+// Delete removes the tokens with the ID, e.g. when the user logs out.
+func (t *Tokens) Delete(id string) error {
+	return t.Store.Delete(id)
+}
+
+func (t *Tokens) get(id string) (st storedToken, err error) {
+	value, ok, err := t.Store.Get(id)
+	if err != nil {
+		return st, fmt.Errorf("Tokens.get: failed to get tokens: %v", err)
+	}
+	if !ok {
+		return st, ErrNoAccessToken
+	}
+	if err = t.codec.Decode(id, string(value), &st); err != nil {
+		return st, fmt.Errorf("Tokens.get: failed to decrypt tokens: %v", err)
+	}
+	return st, nil
+}
+
+// put stores the tokens. If replace is set, they're only stored if tokens with the ID still
+// exist, and ErrNoAccessToken is returned if they don't.
+func (t *Tokens) put(id string, st storedToken, replace bool) error {
+	value, err := t.codec.Encode(id, st)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %v", err)
+	}
+	expires := time.Now().Add(t.Lifetime)
+	if !replace {
+		if err = t.Store.Put(id, []byte(value), expires); err != nil {
+			return fmt.Errorf("failed to store tokens: %v", err)
+		}
+		return nil
+	}
+	ok, err := t.Store.Replace(id, []byte(value), expires)
+	if err != nil {
+		return fmt.Errorf("failed to store tokens: %v", err)
+	}
+	if !ok {
+		return ErrNoAccessToken
+	}
+	return nil
+}
+
+// valid returns true if the access token can be used without refreshing it.
+func valid(st storedToken) bool {
+	if st.AccessToken == "" {
+		return false
+	}
+	return st.Expiry.IsZero() || time.Now().Add(expiryMargin).Before(st.Expiry)
+}
+
+type contextKey int
+
+const tokensContextKey contextKey = 0
+
+type sessionTokens struct {
+	tokens *Tokens
+	id     string
+}
+
+// NewContext returns a copy of the context which can provide the access token with the ID.
+func NewContext(ctx context.Context, tokens *Tokens, id string) context.Context {
+	return context.WithValue(ctx, tokensContextKey, sessionTokens{tokens: tokens, id: id})
+}
+
+// AccessTokenFromContext returns a valid access token for the user who made the request,
+// refreshing it if it has expired. ErrNoAccessToken is returned if the context carries no
+// tokens.
+func AccessTokenFromContext(ctx context.Context) (string, error) {
+	st, ok := ctx.Value(tokensContextKey).(sessionTokens)
+	if !ok {
+		return "", ErrNoAccessToken
+	}
+	return st.tokens.AccessToken(st.id)
+}
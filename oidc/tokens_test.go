@@ -0,0 +1,220 @@
+package oidc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/oidc"
+	"github.com/a-h/gauthmiddleware/oidc/oidctest"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		name                string
+		expiresIn           int
+		revoke              bool
+		expectedAccessToken string
+		expectedErr         bool
+		expectedDeleted     bool
+	}{
+		{
+			name:                "access tokens are reused until they expire",
+			expiresIn:           3600,
+			expectedAccessToken: "access_token_for_code_1",
+		},
+		{
+			name:                "expired access tokens are refreshed",
+			expiresIn:           30,
+			expectedAccessToken: "access_token_2_for_refresh_token_for_code_1",
+		},
+		{
+			name:            "tokens are deleted once the refresh token is revoked",
+			expiresIn:       30,
+			revoke:          true,
+			expectedErr:     true,
+			expectedDeleted: true,
+		},
+	}
+
+	for _, test := range tests {
+		s := oidctest.NewServer("the_client_id", "the_client_secret")
+		s.ExpiresIn = test.expiresIn
+		client := oidc.NewClient(oidc.Config{
+			ClientID:              "the_client_id",
+			ClientSecret:          "the_client_secret",
+			AuthorizationEndpoint: s.AuthorizationEndpoint(),
+			TokenEndpoint:         s.TokenEndpoint(),
+			RedirectURL:           "https://example.com/_gauth/callback",
+			Offline:               true,
+		})
+		codeVerifier, _ := oidc.NewCodeVerifier()
+		callback := authorize(t, client.AuthCodeURL("the_state", "the_nonce", codeVerifier))
+		token, err := client.Exchange(callback.Get("code"), codeVerifier)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if token.RefreshToken == "" {
+			t.Fatalf("%s: expected a refresh token to be issued for offline access", test.name)
+		}
+		if test.revoke {
+			s.Revoke(token.RefreshToken)
+		}
+
+		store := oidc.NewMemoryTokenStore()
+		tokens := oidc.NewTokens(client, store, []byte("the_key"))
+		id, err := tokens.Save(token)
+		if err != nil {
+			t.Fatalf("%s: failed to save tokens: %v", test.name, err)
+		}
+		value, _, _ := store.Get(id)
+		if strings.Contains(string(value), token.RefreshToken) {
+			t.Errorf("%s: expected the refresh token to be encrypted", test.name)
+		}
+
+		ctx := oidc.NewContext(context.Background(), tokens, id)
+		for i := 0; i < 2; i++ {
+			accessToken, err := oidc.AccessTokenFromContext(ctx)
+			if test.expectedErr != (err != nil) {
+				t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+			}
+			if test.expectedErr {
+				continue
+			}
+			// Tokens which expire within the expiry margin are refreshed on every request.
+			if test.expiresIn > 60 && accessToken != test.expectedAccessToken {
+				t.Errorf("%s: expected access token %q, got %q", test.name, test.expectedAccessToken, accessToken)
+			}
+			if test.expiresIn < 60 && i == 0 && accessToken != test.expectedAccessToken {
+				t.Errorf("%s: expected access token %q, got %q", test.name, test.expectedAccessToken, accessToken)
+			}
+		}
+		if _, ok, _ := store.Get(id); ok == test.expectedDeleted {
+			t.Errorf("%s: expected deleted to be %v", test.name, test.expectedDeleted)
+		}
+		s.Close()
+	}
+}
+
+func TestThatTokensOfDifferentUsersAreRefreshedConcurrently(t *testing.T) {
+	// The token endpoint only responds once both refreshes are in progress, so it times
+	// out if one user's refresh holds up the other's.
+	var m sync.Mutex
+	var requests, refreshes int
+	bothArrived := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		if requests++; requests == 2 {
+			close(bothArrived)
+		}
+		refreshes++
+		m.Unlock()
+		select {
+		case <-bothArrived:
+		case <-time.After(time.Second):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"refreshed_%s","expires_in":3600}`, r.PostFormValue("refresh_token"))
+	}))
+	defer s.Close()
+	tokens := oidc.NewTokens(oidc.NewClient(oidc.Config{TokenEndpoint: s.URL}), oidc.NewMemoryTokenStore(), []byte("the_key"))
+
+	save := func(refreshToken string) string {
+		id, err := tokens.Save(&oidc.Token{AccessToken: "expired", RefreshToken: refreshToken, Expiry: time.Now()})
+		if err != nil {
+			t.Fatalf("failed to save tokens: %v", err)
+		}
+		return id
+	}
+	a, b := save("a"), save("b")
+	// The first user makes two requests at once, which must only refresh their token once.
+	ids := []string{a, a, b}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := tokens.AccessToken(id); err != nil {
+				t.Errorf("failed to get access token: %v", err)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the refreshes to happen concurrently, took %v", elapsed)
+	}
+	if refreshes != 2 {
+		t.Errorf("expected each refresh token to be used once, got %d refreshes", refreshes)
+	}
+}
+
+func TestThatContextsWithoutTokensHaveNoAccessToken(t *testing.T) {
+	if _, err := oidc.AccessTokenFromContext(context.Background()); err != oidc.ErrNoAccessToken {
+		t.Errorf("expected ErrNoAccessToken, got %v", err)
+	}
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	s := oidc.NewMemoryTokenStore()
+	if err := s.Put("expired", []byte("value"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if _, ok, _ := s.Get("expired"); ok {
+		t.Errorf("expected expired values not to be returned")
+	}
+	s.Put("current", []byte("value"), time.Now().Add(time.Hour))
+	if v, ok, _ := s.Get("current"); !ok || string(v) != "value" {
+		t.Errorf("expected the value to be returned, got %q", string(v))
+	}
+	if ok, err := s.Replace("current", []byte("replaced"), time.Now().Add(time.Hour)); !ok || err != nil {
+		t.Errorf("expected the value to be replaced, got %v, %v", ok, err)
+	}
+	if v, _, _ := s.Get("current"); string(v) != "replaced" {
+		t.Errorf("expected the replaced value to be returned, got %q", string(v))
+	}
+	s.Delete("current")
+	if _, ok, _ := s.Get("current"); ok {
+		t.Errorf("expected deleted values not to be returned")
+	}
+	for _, id := range []string{"current", "expired"} {
+		if ok, err := s.Replace(id, []byte("value"), time.Now().Add(time.Hour)); ok || err != nil {
+			t.Errorf("expected %s not to be replaced, got %v, %v", id, ok, err)
+		}
+		if _, ok, _ := s.Get(id); ok {
+			t.Errorf("expected %s not to be restored", id)
+		}
+	}
+}
+
+func TestThatTokensDeletedWhileRefreshingStayDeleted(t *testing.T) {
+	var tokens *oidc.Tokens
+	var id string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The user logs out while the access token is being refreshed.
+		if err := tokens.Delete(id); err != nil {
+			t.Errorf("failed to delete tokens: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"refreshed","expires_in":3600}`)
+	}))
+	defer s.Close()
+	store := oidc.NewMemoryTokenStore()
+	tokens = oidc.NewTokens(oidc.NewClient(oidc.Config{TokenEndpoint: s.URL}), store, []byte("the_key"))
+	var err error
+	if id, err = tokens.Save(&oidc.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now()}); err != nil {
+		t.Fatalf("failed to save tokens: %v", err)
+	}
+	if _, err = tokens.AccessToken(id); err != oidc.ErrNoAccessToken {
+		t.Errorf("expected ErrNoAccessToken, got %v", err)
+	}
+	if _, ok, _ := store.Get(id); ok {
+		t.Errorf("expected the deleted tokens not to be restored")
+	}
+}
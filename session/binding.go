@@ -0,0 +1,173 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrBindingChanged is returned by Validate when the client using the session no longer
+// matches the client which started it, and the Binding is enforced.
+var ErrBindingChanged = errors.New("session: the session is being used by a different client")
+
+// A BindingMode determines what happens when a session is used by a different client.
+type BindingMode int
+
+const (
+	// BindingDisabled doesn't record or check the client.
+	BindingDisabled BindingMode = iota
+	// BindingLogOnly logs a security event when the client changes, but allows the session
+	// to be used.
+	BindingLogOnly
+	// BindingEnforced logs a security event when the client changes, and requires the user
+	// to log in again.
+	BindingEnforced
+)
+
+// DefaultBinding is the tolerance used when a session is bound to a client. Clients can
+// move within their IPv4 /24 or IPv6 /48 network, e.g. when their ISP reassigns their
+// address, and can upgrade their browser, but not change to another browser.
+var DefaultBinding = Binding{
+	Mode:             BindingDisabled,
+	IPv4PrefixLength: 24,
+	IPv6PrefixLength: 48,
+	CheckUserAgent:   true,
+}
+
+// A Binding ties a session to the network and browser of the client which started it,
+// so that a stolen session cookie can't be used from another machine.
+type Binding struct {
+	Mode BindingMode
+	// IPv4PrefixLength is the number of leading bits of an IPv4 address which must stay the
+	// same. Zero disables the check.
+	IPv4PrefixLength int
+	// IPv6PrefixLength is the number of leading bits of an IPv6 address which must stay the
+	// same. Zero disables the check.
+	IPv6PrefixLength int
+	// CheckUserAgent requires the browser family and operating system to stay the same.
+	CheckUserAgent bool
+	// RequireBound treats sessions which were started before binding was enabled as if
+	// they were used by a different client. Otherwise, they're bound to the first client
+	// which presents them, which could be an attacker holding a stolen cookie.
+	RequireBound bool
+	// TrustedProxies are the networks of load balancers and reverse proxies, whose
+	// X-Forwarded-For headers are used to find the address of the client.
+	TrustedProxies []*net.IPNet
+}
+
+// ClientIP returns the address of the client. If the request came from a trusted proxy,
+// the X-Forwarded-For header is read from right to left, skipping trusted proxies, to find
+// the first address which was added by an untrusted client.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	ip := net.ParseIP(remoteIP(r))
+	if ip == nil || !isTrusted(ip, trustedProxies) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrusted(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipPrefix returns the network of the IP address, using the prefix length for its type.
+func (b Binding) ipPrefix(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(b.IPv4PrefixLength, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(b.IPv6PrefixLength, 128)).String()
+}
+
+// userAgentFamily returns the browser family and operating system of the user agent,
+// ignoring version numbers, which change when the browser is upgraded.
+func userAgentFamily(ua string) string {
+	browser := "other"
+	// Order matters, e.g. Edge and Opera user agents also contain "Chrome", and Chrome
+	// user agents also contain "Safari".
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "edge"},
+		{"Edge/", "edge"},
+		{"OPR/", "opera"},
+		{"Firefox/", "firefox"},
+		{"FxiOS/", "firefox"},
+		{"CriOS/", "chrome"},
+		{"Chrome/", "chrome"},
+		{"Safari/", "safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	platform := "other"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "android"},
+		{"iPhone", "ios"},
+		{"iPad", "ios"},
+		{"Windows", "windows"},
+		{"Mac OS X", "macos"},
+		{"CrOS", "chromeos"},
+		{"Linux", "linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			platform = o.name
+			break
+		}
+	}
+	return browser + "/" + platform
+}
+
+// clientFingerprint is what's recorded about the client when a session starts.
+type clientFingerprint struct {
+	IP        string
+	UserAgent string
+}
+
+func (b Binding) fingerprint(r *http.Request) clientFingerprint {
+	var ip string
+	if clientIP := ClientIP(r, b.TrustedProxies); clientIP != nil {
+		ip = clientIP.String()
+	}
+	return clientFingerprint{
+		IP:        ip,
+		UserAgent: userAgentFamily(r.UserAgent()),
+	}
+}
+
+// changes returns a description of each way the client has changed beyond the tolerances
+// of the binding.
+func (b Binding) changes(recorded, current clientFingerprint) (changes []string) {
+	recordedIP, currentIP := net.ParseIP(recorded.IP), net.ParseIP(current.IP)
+	checkIP := b.IPv6PrefixLength > 0
+	if recordedIP.To4() != nil && currentIP.To4() != nil {
+		checkIP = b.IPv4PrefixLength > 0
+	}
+	if checkIP && b.ipPrefix(recordedIP) != b.ipPrefix(currentIP) {
+		changes = append(changes, "network changed from "+recorded.IP+" to "+current.IP)
+	}
+	if b.CheckUserAgent && recorded.UserAgent != current.UserAgent {
+		changes = append(changes, "user agent changed from "+recorded.UserAgent+" to "+current.UserAgent)
+	}
+	return
+}
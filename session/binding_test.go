@@ -0,0 +1,141 @@
+package session
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		trusted      []*net.IPNet
+		expectedIP   string
+	}{
+		{
+			name:       "direct connection",
+			remoteAddr: "192.0.2.1:1234",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:         "X-Forwarded-For is ignored from untrusted clients",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			trusted:      trusted,
+			expectedIP:   "192.0.2.1",
+		},
+		{
+			name:         "X-Forwarded-For is used from trusted proxies",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			trusted:      trusted,
+			expectedIP:   "198.51.100.1",
+		},
+		{
+			name:         "addresses added by the client are ignored",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"},
+			trusted:      trusted,
+			expectedIP:   "198.51.100.1",
+		},
+		{
+			name:         "multiple headers are combined",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.1", "198.51.100.1"},
+			trusted:      trusted,
+			expectedIP:   "198.51.100.1",
+		},
+		{
+			name:         "invalid addresses stop the search",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1, nonsense, 10.0.0.2"},
+			trusted:      trusted,
+			expectedIP:   "10.0.0.2",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[2001:db8::1]:1234",
+			expectedIP: "2001:db8::1",
+		},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, v := range test.forwardedFor {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if actual := ClientIP(r, test.trusted).String(); actual != test.expectedIP {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expectedIP, actual)
+		}
+	}
+}
+
+func TestUserAgentFamily(t *testing.T) {
+	tests := []struct {
+		ua       string
+		expected string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Safari/537.36", "chrome/windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Safari/537.36 Edg/80.0.361.66", "edge/windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:74.0) Gecko/20100101 Firefox/74.0", "firefox/macos"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Safari/605.1.15", "safari/macos"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Mobile/15E148 Safari/604.1", "safari/ios"},
+		{"Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Mobile Safari/537.36", "chrome/android"},
+		{"curl/7.68.0", "curl/other"},
+		{"", "other/other"},
+	}
+	for _, test := range tests {
+		if actual := userAgentFamily(test.ua); actual != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.ua, test.expected, actual)
+		}
+	}
+}
+
+func TestBindingChanges(t *testing.T) {
+	tests := []struct {
+		name            string
+		binding         Binding
+		recorded        clientFingerprint
+		current         clientFingerprint
+		expectedChanges int
+	}{
+		{
+			name:     "moving within the network is allowed",
+			binding:  DefaultBinding,
+			recorded: clientFingerprint{IP: "192.0.2.1", UserAgent: "chrome/linux"},
+			current:  clientFingerprint{IP: "192.0.2.200", UserAgent: "chrome/linux"},
+		},
+		{
+			name:            "moving to another network is a change",
+			binding:         DefaultBinding,
+			recorded:        clientFingerprint{IP: "192.0.2.1", UserAgent: "chrome/linux"},
+			current:         clientFingerprint{IP: "198.51.100.1", UserAgent: "chrome/linux"},
+			expectedChanges: 1,
+		},
+		{
+			name:     "a zero prefix length disables the network check",
+			binding:  Binding{IPv4PrefixLength: 0, IPv6PrefixLength: 0, CheckUserAgent: true},
+			recorded: clientFingerprint{IP: "192.0.2.1", UserAgent: "chrome/linux"},
+			current:  clientFingerprint{IP: "198.51.100.1", UserAgent: "chrome/linux"},
+		},
+		{
+			name:            "changing browser is a change",
+			binding:         DefaultBinding,
+			recorded:        clientFingerprint{IP: "2001:db8::1", UserAgent: "chrome/linux"},
+			current:         clientFingerprint{IP: "2001:db8::2", UserAgent: "firefox/linux"},
+			expectedChanges: 1,
+		},
+	}
+
+	for _, test := range tests {
+		changes := test.binding.changes(test.recorded, test.current)
+		if len(changes) != test.expectedChanges {
+			t.Errorf("%s: expected %d changes, got %v", test.name, test.expectedChanges, changes)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package session
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// maxCookieSize is the maximum length of the name and value of each cookie. Browsers
+// silently drop cookies larger than 4096 bytes, including the attributes.
+const maxCookieSize = 4000
+
+// maxChunks is the maximum number of cookies a session can be split across.
+const maxChunks = 10
+
+// maxSessionSize is the maximum size of the serialized session values, before they're
+// compressed. Compressed values can expand to far more than the maxCookieSize * maxChunks
+// bytes the cookies hold, so values which would decompress beyond this aren't read, and a
+// forged cookie can't exhaust memory. Sessions larger than this can't be started, since
+// they couldn't be read back.
+const maxSessionSize = 256 * 1024
+
+// ErrSessionTooLarge is returned when the session is larger than maxSessionSize, or can't
+// fit into maxChunks cookies once it's compressed and encrypted.
+var ErrSessionTooLarge = errors.New("session: the session is too large to be stored in cookies")
+
+// compressedMarker is the first byte of compressed session values. Gob streams start with
+// the length of the first message, which is never zero, so values serialized before
+// compression was added are still read.
+const compressedMarker = 0
+
+// compressingEncoder serializes session values using gob, then compresses them before
+// they're encrypted, to reduce the number of cookies needed.
+type compressingEncoder struct{}
+
+func (compressingEncoder) Serialize(src interface{}) ([]byte, error) {
+	var serialized bytes.Buffer
+	if err := gob.NewEncoder(&serialized).Encode(src); err != nil {
+		return nil, err
+	}
+	if serialized.Len() > maxSessionSize {
+		return nil, ErrSessionTooLarge
+	}
+	buf := bytes.NewBuffer([]byte{compressedMarker})
+	fw, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = serialized.WriteTo(fw); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compressingEncoder) Deserialize(src []byte, dst interface{}) error {
+	if len(src) == 0 || src[0] != compressedMarker {
+		return securecookie.GobEncoder{}.Deserialize(src, dst)
+	}
+	fr := flate.NewReader(bytes.NewReader(src[1:]))
+	defer fr.Close()
+	return gob.NewDecoder(io.LimitReader(fr, maxSessionSize)).Decode(dst)
+}
+
+// isTooLarge returns true if the error returned by securecookie, which wraps the errors
+// of the serializer, was caused by the session being larger than maxSessionSize.
+func isTooLarge(err error) bool {
+	if errs, ok := err.(securecookie.MultiError); ok && len(errs) > 0 {
+		err = errs[0]
+	}
+	if e, ok := err.(interface{ Cause() error }); ok {
+		err = e.Cause()
+	}
+	return err == ErrSessionTooLarge
+}
+
+// chunkName returns the name of the cookie which holds the nth chunk of the value. The
+// first chunk is held in the cookie named name.
+func chunkName(name string, n int) string {
+	return name + "_" + strconv.Itoa(n)
+}
+
+// splitCookie splits the value into chunks which fit into a cookie. If the value needs
+// more than one chunk, the first chunk is prefixed with the number of chunks, e.g. "3.",
+// which can't be confused with the base64 encoded value.
+func splitCookie(name, value string) ([]string, error) {
+	if len(name)+len(value) <= maxCookieSize {
+		return []string{value}, nil
+	}
+	// Leave space for the prefix of the first chunk, and the suffix of the other names.
+	size := maxCookieSize - len(name) - len(strconv.Itoa(maxChunks)) - 1
+	var chunks []string
+	for len(value) > 0 {
+		n := size
+		if n > len(value) {
+			n = len(value)
+		}
+		chunks = append(chunks, value[:n])
+		value = value[n:]
+	}
+	if len(chunks) > maxChunks {
+		return nil, ErrSessionTooLarge
+	}
+	chunks[0] = strconv.Itoa(len(chunks)) + "." + chunks[0]
+	return chunks, nil
+}
+
+// readCookie reassembles the value of the cookie from its chunks.
+func readCookie(r *http.Request, name string) (string, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	i := strings.Index(c.Value, ".")
+	if i < 0 {
+		return c.Value, nil
+	}
+	count, err := strconv.Atoi(c.Value[:i])
+	if err != nil || count < 2 || count > maxChunks {
+		return "", fmt.Errorf("invalid number of chunks: '%v'", c.Value[:i])
+	}
+	var value strings.Builder
+	value.WriteString(c.Value[i+1:])
+	for n := 1; n < count; n++ {
+		chunk, err := r.Cookie(chunkName(name, n))
+		if err != nil {
+			return "", fmt.Errorf("chunk %d of %d is missing", n+1, count)
+		}
+		value.WriteString(chunk.Value)
+	}
+	return value.String(), nil
+}
+
+// writeCookie writes the value of the cookie, split across as many cookies as needed. Any
+// chunks sent by the client which are no longer needed are expired. If maxAge is
+// negative, all of the cookies are expired.
+func (o CookieOptions) writeCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) error {
+	chunks := []string{""}
+	if maxAge >= 0 {
+		var err error
+		if chunks, err = splitCookie(name, value); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(w, o.cookie(name, chunks[0], maxAge))
+	for n := 1; n < len(chunks); n++ {
+		http.SetCookie(w, o.cookie(chunkName(name, n), chunks[n], maxAge))
+	}
+	for n := len(chunks); n < maxChunks; n++ {
+		if _, err := r.Cookie(chunkName(name, n)); err == nil {
+			http.SetCookie(w, o.cookie(chunkName(name, n), "", -1))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/gorilla/securecookie"
+)
+
+// incompressible returns a string of n random hex characters, which compression can only
+// halve in size.
+func incompressible(t *testing.T, n int) string {
+	b := make([]byte, n/2)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to read random data: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range cookies {
+		if c.MaxAge >= 0 {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestThatLargeSessionsAreSplitAcrossCookies(t *testing.T) {
+	tests := []struct {
+		name          string
+		pictureLength int
+		expectChunked bool
+	}{
+		{
+			name:          "small sessions use a single cookie",
+			pictureLength: 100,
+		},
+		{
+			name:          "large sessions use several cookies",
+			pictureLength: 10000,
+			expectChunked: true,
+		},
+	}
+
+	for _, test := range tests {
+		s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+		id := identity.Identity{
+			Email:     "test@example.com",
+			Picture:   incompressible(t, test.pictureLength),
+			LoginTime: time.Now(),
+		}
+		w := httptest.NewRecorder()
+		if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), id); err != nil {
+			t.Fatalf("%s: unexpected error starting the session: %v", test.name, err)
+		}
+		cookies := w.Result().Cookies()
+		if chunked := len(cookies) > 1; chunked != test.expectChunked {
+			t.Errorf("%s: expected chunked %v, got %d cookies", test.name, test.expectChunked, len(cookies))
+		}
+		for _, c := range cookies {
+			if len(c.Name)+len(c.Value) > maxCookieSize {
+				t.Errorf("%s: expected cookie %s to fit within %d bytes, got %d", test.name, c.Name, maxCookieSize, len(c.Name)+len(c.Value))
+			}
+		}
+
+		isValid, actual, err := s.Validate(httptest.NewRecorder(), requestWithCookies(cookies))
+		if !isValid || err != nil {
+			t.Fatalf("%s: expected the session to be valid, got valid %v, error %v", test.name, isValid, err)
+		}
+		if actual.Picture != id.Picture {
+			t.Errorf("%s: expected the picture to be reassembled", test.name)
+		}
+	}
+}
+
+func TestThatStaleChunksAreExpired(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		Picture:   incompressible(t, 10000),
+		LoginTime: time.Now(),
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+
+	large := w.Result().Cookies()
+	if len(large) < 2 {
+		t.Fatalf("expected the session to be split across cookies, got %d cookies", len(large))
+	}
+
+	// Start a smaller session from the same browser.
+	w = httptest.NewRecorder()
+	if err := s.Start(w, requestWithCookies(large), identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	small := w.Result().Cookies()
+	if len(small) != len(large) {
+		t.Fatalf("expected %d cookies, got %d", len(large), len(small))
+	}
+	for _, c := range small {
+		if isExpired := c.MaxAge < 0; isExpired != (c.Name != "cookie-name") {
+			t.Errorf("expected only the stale chunks to be expired, but cookie %s expired %v", c.Name, isExpired)
+		}
+	}
+
+	// Ending the session expires all of the chunks.
+	w = httptest.NewRecorder()
+	if err := s.End(w, requestWithCookies(large[:2])); err != nil {
+		t.Fatalf("unexpected error ending the session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("expected 2 cookies to be expired, got %d", len(cookies))
+	}
+	for _, c := range cookies {
+		if c.MaxAge >= 0 {
+			t.Errorf("expected cookie %s to be expired", c.Name)
+		}
+	}
+}
+
+func TestThatSessionsWithMissingChunksAreInvalid(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		Picture:   incompressible(t, 10000),
+		LoginTime: time.Now(),
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	var cookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name != "cookie-name_2" {
+			cookies = append(cookies, c)
+		}
+	}
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), requestWithCookies(cookies)); isValid || err != nil {
+		t.Errorf("expected the session to be invalid, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatSessionsWhichAreTooLargeCannotBeStarted(t *testing.T) {
+	tests := []struct {
+		name    string
+		picture string
+	}{
+		{
+			name:    "sessions which don't fit into the cookies",
+			picture: incompressible(t, 2*maxCookieSize*maxChunks),
+		},
+		{
+			// These would fit once compressed, but couldn't be read back.
+			name:    "sessions which are larger than the maximum size once decompressed",
+			picture: strings.Repeat("a", maxSessionSize+1),
+		},
+	}
+	for _, test := range tests {
+		s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+		err := s.Start(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+			Email:   "test@example.com",
+			Picture: test.picture,
+		})
+		if err != ErrSessionTooLarge {
+			t.Errorf("%s: expected ErrSessionTooLarge, got %v", test.name, err)
+		}
+	}
+}
+
+func TestThatUncompressedCookiesAreAccepted(t *testing.T) {
+	key := []byte("random_data")
+	// Issue a cookie in the format used before values were compressed.
+	codec := securecookie.New(deriveKey(key, "authentication"), deriveKey(key, "encryption"))
+	value, err := securecookie.EncodeMulti("cookie-name", map[interface{}]interface{}{
+		"version":      cookieVersion,
+		"emailAddress": "test@example.com",
+		"name":         strings.Repeat("a", 10),
+		"loginTime":    time.Now().Unix(),
+		"renewed":      time.Now().Unix(),
+	}, codec)
+	if err != nil {
+		t.Fatalf("unexpected error encoding the cookie: %v", err)
+	}
+
+	s := NewGorillaSession(key, false, "cookie-name")
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.AddCookie(&http.Cookie{Name: "cookie-name", Value: value})
+	isValid, id, err := s.Validate(httptest.NewRecorder(), r)
+	if !isValid || err != nil {
+		t.Fatalf("expected the session to be valid, got valid %v, error %v", isValid, err)
+	}
+	if id.Email != "test@example.com" || id.Name != "aaaaaaaaaa" {
+		t.Errorf("unexpected identity: %v", id)
+	}
+}
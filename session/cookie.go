@@ -0,0 +1,107 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// HostPrefix is prepended to the cookie name to tell browsers to only accept the cookie if
+// it's Secure, has a Path of "/" and no Domain, so that it can't be set or overwritten by
+// other subdomains or by insecure pages.
+const HostPrefix = "__Host-"
+
+// SecurePrefix is prepended to the cookie name to tell browsers to only accept the cookie
+// if it's Secure.
+const SecurePrefix = "__Secure-"
+
+// CookieOptions are the attributes of the session cookie. Session cookies are always
+// HttpOnly.
+type CookieOptions struct {
+	// Path is the path the cookie is sent to. Defaults to "/".
+	Path string
+	// Domain allows the cookie to be sent to subdomains. If empty, it's only sent to the
+	// host which issued it.
+	Domain string
+	// MaxAge caps the number of seconds the cookie is kept by the browser. If zero, the
+	// cookie is kept until the session's Lifetime expires. If negative, the cookie is
+	// deleted when the browser is closed.
+	MaxAge   int
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// DefaultCookieOptions returns the default attributes of the session cookie.
+func DefaultCookieOptions(secure bool) CookieOptions {
+	return CookieOptions{
+		Path:     "/",
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ValidateCookie checks that the options meet the requirements of the cookie name's
+// prefix, and of the SameSite mode, since browsers silently reject cookies which don't.
+func ValidateCookie(name string, o CookieOptions) error {
+	if strings.HasPrefix(name, HostPrefix) {
+		if !o.Secure {
+			return errors.New("cookies with the " + HostPrefix + " prefix must be Secure")
+		}
+		if o.Path != "/" && o.Path != "" {
+			return errors.New("cookies with the " + HostPrefix + " prefix must have a Path of \"/\"")
+		}
+		if o.Domain != "" {
+			return errors.New("cookies with the " + HostPrefix + " prefix can't have a Domain")
+		}
+	}
+	if strings.HasPrefix(name, SecurePrefix) && !o.Secure {
+		return errors.New("cookies with the " + SecurePrefix + " prefix must be Secure")
+	}
+	if o.SameSite == http.SameSiteNoneMode && !o.Secure {
+		return errors.New("cookies with SameSite=None must be Secure")
+	}
+	return nil
+}
+
+func (o CookieOptions) path() string {
+	if o.Path == "" {
+		return "/"
+	}
+	return o.Path
+}
+
+// maxAge returns the MaxAge of a cookie for a session which lasts for lifetimeMaxAge.
+func (o CookieOptions) maxAge(lifetimeMaxAge int) int {
+	if o.MaxAge < 0 {
+		return 0
+	}
+	if o.MaxAge > 0 && (lifetimeMaxAge == 0 || o.MaxAge < lifetimeMaxAge) {
+		return o.MaxAge
+	}
+	return lifetimeMaxAge
+}
+
+func (o CookieOptions) sessionsOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:     o.path(),
+		Domain:   o.Domain,
+		HttpOnly: true,
+		Secure:   o.Secure,
+		SameSite: o.SameSite,
+	}
+}
+
+func (o CookieOptions) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.path(),
+		Domain:   o.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   o.Secure,
+		SameSite: o.SameSite,
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+)
+
+func TestValidateCookie(t *testing.T) {
+	tests := []struct {
+		name        string
+		cookieName  string
+		options     CookieOptions
+		expectedErr bool
+	}{
+		{
+			name:       "defaults",
+			cookieName: "auth-session",
+			options:    DefaultCookieOptions(false),
+		},
+		{
+			name:       "host prefix",
+			cookieName: "__Host-auth-session",
+			options:    DefaultCookieOptions(true),
+		},
+		{
+			name:        "host prefix without the secure flag",
+			cookieName:  "__Host-auth-session",
+			options:     DefaultCookieOptions(false),
+			expectedErr: true,
+		},
+		{
+			name:        "host prefix with a path",
+			cookieName:  "__Host-auth-session",
+			options:     CookieOptions{Path: "/reports", Secure: true},
+			expectedErr: true,
+		},
+		{
+			name:        "host prefix with a domain",
+			cookieName:  "__Host-auth-session",
+			options:     CookieOptions{Path: "/", Domain: "example.com", Secure: true},
+			expectedErr: true,
+		},
+		{
+			name:        "secure prefix without the secure flag",
+			cookieName:  "__Secure-auth-session",
+			options:     CookieOptions{Domain: "example.com"},
+			expectedErr: true,
+		},
+		{
+			name:        "SameSite=None without the secure flag",
+			cookieName:  "auth-session",
+			options:     CookieOptions{SameSite: http.SameSiteNoneMode},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		err := ValidateCookie(test.cookieName, test.options)
+		if test.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+	}
+}
+
+func TestThatCookieOptionsAreApplied(t *testing.T) {
+	options := CookieOptions{
+		Path:     "/app",
+		Domain:   "example.com",
+		MaxAge:   600,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	gs := NewGorillaSession([]byte("random_data"), true, "cookie-name")
+	gs.CookieOptions = options
+	ss := NewServerSession(NewMemoryStore(), true, "cookie-name")
+	ss.CookieOptions = options
+
+	for _, s := range []Session{gs, ss} {
+		w := httptest.NewRecorder()
+		if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+			t.Fatalf("%T: unexpected error starting the session: %v", s, err)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("%T: expected a cookie, got %v", s, cookies)
+		}
+		c := cookies[0]
+		if c.Path != "/app" || c.Domain != "example.com" || c.MaxAge != 600 || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+			t.Errorf("%T: expected the cookie options to be applied, got %v", s, c.String())
+		}
+	}
+}
+
+func TestCookieMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge         int
+		lifetimeMaxAge int
+		expected       int
+	}{
+		{maxAge: 0, lifetimeMaxAge: 3600, expected: 3600},
+		{maxAge: 600, lifetimeMaxAge: 3600, expected: 600},
+		{maxAge: 7200, lifetimeMaxAge: 3600, expected: 3600},
+		{maxAge: 600, lifetimeMaxAge: 0, expected: 600},
+		{maxAge: -1, lifetimeMaxAge: 3600, expected: 0},
+	}
+	for _, test := range tests {
+		o := CookieOptions{MaxAge: test.maxAge}
+		if actual := o.maxAge(test.lifetimeMaxAge); actual != test.expected {
+			t.Errorf("MaxAge %d, lifetime %d: expected %d, got %d", test.maxAge, test.lifetimeMaxAge, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A FileStore holds each session in a JSON file within a directory, so that sessions
+// survive restarts.
+type FileStore struct {
+	Dir string
+	m   sync.Mutex
+	now func() time.Time
+}
+
+// NewFileStore creates a FileStore which uses the directory. The directory is created
+// when the first session is stored.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{
+		Dir: dir,
+		now: time.Now,
+	}
+}
+
+// validID prevents session IDs from being used to access files outside of the directory.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (fs *FileStore) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("FileStore: invalid session ID")
+	}
+	return filepath.Join(fs.Dir, id+".json"), nil
+}
+
+// Get returns the session with the ID.
+func (fs *FileStore) Get(id string) (r Record, ok bool, err error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	fn, err := fs.path(id)
+	if err != nil {
+		return r, false, nil
+	}
+	r, ok, err = fs.read(fn)
+	if ok && r.Expired(fs.now()) {
+		return Record{}, false, fs.remove(fn)
+	}
+	return
+}
+
+func (fs *FileStore) read(fn string) (r Record, ok bool, err error) {
+	data, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, fmt.Errorf("FileStore: failed to read session: %v", err)
+	}
+	if err = json.Unmarshal(data, &r); err != nil {
+		return r, false, fmt.Errorf("FileStore: failed to decode session: %v", err)
+	}
+	return r, true, nil
+}
+
+func (fs *FileStore) remove(fn string) error {
+	if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("FileStore: failed to delete session: %v", err)
+	}
+	return nil
+}
+
+// Put creates or replaces the session.
+func (fs *FileStore) Put(r Record) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	fn, err := fs.path(r.ID)
+	if err != nil {
+		return err
+	}
+	return fs.write(fn, r)
+}
+
+// Touch renews the session, if it exists.
+func (fs *FileStore) Touch(id string, lastSeen, expires time.Time) (ok bool, err error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	fn, err := fs.path(id)
+	if err != nil {
+		return false, nil
+	}
+	r, ok, err := fs.read(fn)
+	if !ok || err != nil || r.Expired(fs.now()) {
+		return false, err
+	}
+	r.LastSeen, r.Expires = lastSeen, expires
+	return true, fs.write(fn, r)
+}
+
+func (fs *FileStore) write(fn string, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("FileStore: failed to encode session: %v", err)
+	}
+	if err = os.MkdirAll(fs.Dir, 0700); err != nil {
+		return fmt.Errorf("FileStore: failed to create directory: %v", err)
+	}
+	// Write to a temporary file first, so that a partially written session is never read.
+	tmp, err := ioutil.TempFile(fs.Dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("FileStore: failed to create session: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("FileStore: failed to write session: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("FileStore: failed to write session: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), fn); err != nil {
+		return fmt.Errorf("FileStore: failed to write session: %v", err)
+	}
+	return nil
+}
+
+// Delete removes the session.
+func (fs *FileStore) Delete(id string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	fn, err := fs.path(id)
+	if err != nil {
+		return nil
+	}
+	return fs.remove(fn)
+}
+
+// List returns all sessions which have not expired, and removes those which have.
+func (fs *FileStore) List() ([]Record, error) {
+	return fs.list(func(Record) bool { return true })
+}
+
+// ListByUser returns the sessions of a user which have not expired.
+func (fs *FileStore) ListByUser(userID string) ([]Record, error) {
+	return fs.list(func(r Record) bool { return r.Identity.UserID() == userID })
+}
+
+func (fs *FileStore) list(include func(r Record) bool) (records []Record, err error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	files, err := ioutil.ReadDir(fs.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FileStore: failed to list sessions: %v", err)
+	}
+	now := fs.now()
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		fn := filepath.Join(fs.Dir, f.Name())
+		r, ok, err := fs.read(fn)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if r.Expired(now) {
+			if err = fs.remove(fn); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if include(r) {
+			records = append(records, r)
+		}
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package session_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/session/sessiontest"
+)
+
+func TestFileStore(t *testing.T) {
+	sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+		return newFileStore(t)
+	})
+}
+
+func TestThatTheFileStoreRejectsSessionIDsOutsideOfItsDirectory(t *testing.T) {
+	fs := newFileStore(t)
+	if err := fs.Put(sessiontest.NewRecord("../session1", "a@example.com")); err == nil {
+		t.Error("expected an invalid session ID to be rejected")
+	}
+	if _, ok, err := fs.Get("../session1"); ok || err != nil {
+		t.Errorf("expected an invalid session ID not to be found, got %v, %v", ok, err)
+	}
+}
+
+func newFileStore(t *testing.T) *session.FileStore {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return session.NewFileStore(dir)
+}
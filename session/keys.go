@@ -0,0 +1,33 @@
+package session
+
+import (
+	"github.com/a-h/gauthmiddleware/internal/keys"
+	"github.com/gorilla/securecookie"
+)
+
+// deriveKey derives a key for a specific purpose from the configured key, so that the
+// same key is never used for both authentication and encryption.
+func deriveKey(key []byte, purpose string) []byte {
+	return keys.Derive(key, "gauthmiddleware/session/"+purpose)
+}
+
+// newCodec creates a codec which authenticates cookies using a key derived from the
+// key and encrypts them using another derived key.
+func newCodec(key []byte) securecookie.Codec {
+	codec := securecookie.New(deriveKey(key, "authentication"), deriveKey(key, "encryption"))
+	// Expiry is checked by Validate, using the Lifetime.
+	codec.MaxAge(0)
+	// Values are compressed, and split across cookies if they're still too large, so the
+	// length is limited by the number of chunks instead.
+	codec.SetSerializer(compressingEncoder{})
+	codec.MaxLength(0)
+	return codec
+}
+
+// newSignedOnlyCodec creates a codec which reads the signed, but unencrypted, cookies
+// issued by earlier versions, which used the configured key directly as the hash key.
+func newSignedOnlyCodec(key []byte) securecookie.Codec {
+	// The cookies didn't record when the user logged in, so securecookie's timestamp, which
+	// is limited to 30 days by default, is the only limit on how old they can be.
+	return securecookie.New(key, nil)
+}
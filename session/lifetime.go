@@ -0,0 +1,75 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrExpired is returned by Validate when the session has expired.
+var ErrExpired = errors.New("session: expired")
+
+// DefaultLifetime is used by sessions unless configured otherwise.
+var DefaultLifetime = Lifetime{
+	Absolute:   7 * 24 * time.Hour,
+	Idle:       24 * time.Hour,
+	RenewAfter: 15 * time.Minute,
+}
+
+// Lifetime controls how long sessions last.
+type Lifetime struct {
+	// Absolute is the maximum time since the user logged in, after which they
+	// must log in again. Zero means no limit.
+	Absolute time.Duration
+	// Idle is how long the session lasts without being used. Zero means no limit.
+	Idle time.Duration
+	// RenewAfter is how long after the session was last renewed that it's renewed
+	// again, extending the idle timeout.
+	RenewAfter time.Duration
+}
+
+// expired returns true if a session which started at login and was last renewed
+// at renewed has expired by now.
+func (l Lifetime) expired(login, renewed, now time.Time) bool {
+	if l.Absolute > 0 && (login.IsZero() || now.Sub(login) > l.Absolute) {
+		return true
+	}
+	if l.Idle > 0 && (renewed.IsZero() || now.Sub(renewed) > l.Idle) {
+		return true
+	}
+	return false
+}
+
+// shouldRenew returns true if a session last renewed at renewed should be renewed.
+func (l Lifetime) shouldRenew(renewed, now time.Time) bool {
+	return now.Sub(renewed) > l.RenewAfter
+}
+
+// cookieMaxAge is the MaxAge of a cookie issued at now, for a session which
+// started at login, or zero for a cookie which expires when the browser is closed.
+func (l Lifetime) cookieMaxAge(login, now time.Time) int {
+	var maxAge time.Duration
+	if l.Absolute > 0 {
+		maxAge = l.Absolute - now.Sub(login)
+	}
+	if l.Idle > 0 && (maxAge == 0 || l.Idle < maxAge) {
+		maxAge = l.Idle
+	}
+	if maxAge < time.Second && maxAge != 0 {
+		maxAge = time.Second
+	}
+	return int(maxAge.Seconds())
+}
+
+// expiry returns when a session which started at login and was last renewed at
+// renewed expires, or zero if it never expires.
+func (l Lifetime) expiry(login, renewed time.Time) (expires time.Time) {
+	if l.Absolute > 0 {
+		expires = login.Add(l.Absolute)
+	}
+	if l.Idle > 0 {
+		if idle := renewed.Add(l.Idle); expires.IsZero() || idle.Before(expires) {
+			expires = idle
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// A MemoryStore holds sessions in memory. Sessions are lost when the process exits,
+// and aren't shared between processes.
+type MemoryStore struct {
+	// OnExpire, if set, is called with each session which is removed from the store
+	// because it has expired.
+	OnExpire func(r Record)
+	m        sync.Mutex
+	records  map[string]Record
+	now      func() time.Time
+	pruneAt  time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]Record),
+		now:     time.Now,
+	}
+}
+
+// Get returns the session with the ID.
+func (ms *MemoryStore) Get(id string) (r Record, ok bool, err error) {
+	var expired []Record
+	// Deferred first, so that OnExpire is called once the lock is released.
+	defer func() { ms.expire(expired) }()
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	r, ok = ms.records[id]
+	if ok && r.Expired(ms.now()) {
+		delete(ms.records, id)
+		expired = append(expired, r)
+		return Record{}, false, nil
+	}
+	return
+}
+
+// Put creates or replaces the session, and removes expired sessions.
+func (ms *MemoryStore) Put(r Record) error {
+	var expired []Record
+	defer func() { ms.expire(expired) }()
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	expired = ms.prune(ms.now())
+	ms.records[r.ID] = r
+	return nil
+}
+
+// Touch renews the session, if it exists.
+func (ms *MemoryStore) Touch(id string, lastSeen, expires time.Time) (ok bool, err error) {
+	var expired []Record
+	defer func() { ms.expire(expired) }()
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	r, ok := ms.records[id]
+	if !ok {
+		return false, nil
+	}
+	if r.Expired(ms.now()) {
+		delete(ms.records, id)
+		expired = append(expired, r)
+		return false, nil
+	}
+	r.LastSeen, r.Expires = lastSeen, expires
+	ms.records[id] = r
+	return true, nil
+}
+
+// prune removes expired sessions, at most once a minute, so that sessions which are never
+// read again don't accumulate. It returns the sessions which were removed.
+func (ms *MemoryStore) prune(now time.Time) (expired []Record) {
+	if now.Before(ms.pruneAt) {
+		return
+	}
+	for id, r := range ms.records {
+		if r.Expired(now) {
+			delete(ms.records, id)
+			expired = append(expired, r)
+		}
+	}
+	ms.pruneAt = now.Add(time.Minute)
+	return
+}
+
+// expire calls OnExpire with the sessions. It must be called without holding the lock, so
+// that OnExpire can use the store.
+func (ms *MemoryStore) expire(records []Record) {
+	if ms.OnExpire == nil {
+		return
+	}
+	for _, r := range records {
+		ms.OnExpire(r)
+	}
+}
+
+// Delete removes the session.
+func (ms *MemoryStore) Delete(id string) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	delete(ms.records, id)
+	return nil
+}
+
+// List returns all sessions which have not expired, and removes those which have.
+func (ms *MemoryStore) List() ([]Record, error) {
+	return ms.list(func(Record) bool { return true })
+}
+
+// ListByUser returns the sessions of a user which have not expired.
+func (ms *MemoryStore) ListByUser(userID string) ([]Record, error) {
+	return ms.list(func(r Record) bool { return r.Identity.UserID() == userID })
+}
+
+func (ms *MemoryStore) list(include func(r Record) bool) (records []Record, err error) {
+	var expired []Record
+	defer func() { ms.expire(expired) }()
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	now := ms.now()
+	for id, r := range ms.records {
+		if r.Expired(now) {
+			delete(ms.records, id)
+			expired = append(expired, r)
+			continue
+		}
+		if include(r) {
+			records = append(records, r)
+		}
+	}
+	return
+}
@@ -0,0 +1,14 @@
+package session_test
+
+import (
+	"testing"
+
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/session/sessiontest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+		return session.NewMemoryStore()
+	})
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+// A NotifyingStore wraps a SessionStore, and calls OnDelete with each session which is
+// deleted, however it ends, e.g. when the user logs out, logs in again, has too many
+// sessions, or is revoked by an administrator. It can be used to delete data held
+// elsewhere for the session.
+//
+// Sessions which expire are only notified if the wrapped store is a MemoryStore. Other
+// stores remove expired sessions without reporting them, so data held elsewhere should
+// also expire.
+type NotifyingStore struct {
+	SessionStore
+	OnDelete func(r Record) error
+}
+
+// NewNotifyingStore creates a NotifyingStore which calls onDelete when sessions are
+// deleted from the store. If the store is a MemoryStore, its OnExpire is set, so that
+// onDelete is also called when sessions expire.
+func NewNotifyingStore(store SessionStore, onDelete func(r Record) error) *NotifyingStore {
+	ns := &NotifyingStore{
+		SessionStore: store,
+		OnDelete:     onDelete,
+	}
+	if ms, ok := store.(*MemoryStore); ok {
+		ms.OnExpire = ns.expired
+	}
+	return ns
+}
+
+// Delete removes the session, and calls OnDelete if it existed.
+func (ns *NotifyingStore) Delete(id string) error {
+	r, ok, err := ns.SessionStore.Get(id)
+	if err != nil {
+		return fmt.Errorf("NotifyingStore.Delete: failed to get session: %v", err)
+	}
+	if err = ns.SessionStore.Delete(id); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if err = ns.OnDelete(r); err != nil {
+		return fmt.Errorf("NotifyingStore.Delete: %v", err)
+	}
+	return nil
+}
+
+// expired calls OnDelete with a session which the store removed because it expired.
+func (ns *NotifyingStore) expired(r Record) {
+	if err := ns.OnDelete(r); err != nil {
+		logger.For(pkg, "NotifyingStore.expired").WithField("email", r.Identity.Email).WithError(err).Warn("Failed to notify an expired session")
+	}
+}
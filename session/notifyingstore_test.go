@@ -0,0 +1,55 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/session/sessiontest"
+)
+
+func TestNotifyingStore(t *testing.T) {
+	sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+		return session.NewNotifyingStore(session.NewMemoryStore(), func(r session.Record) error {
+			return nil
+		})
+	})
+}
+
+func TestThatTheNotifyingStoreCallsOnDeleteForExistingSessions(t *testing.T) {
+	var deleted []string
+	store := session.NewNotifyingStore(session.NewMemoryStore(), func(r session.Record) error {
+		deleted = append(deleted, r.ID)
+		return nil
+	})
+	if err := store.Put(sessiontest.NewRecord("session1", "a@example.com")); err != nil {
+		t.Fatalf("failed to put session: %v", err)
+	}
+	for _, id := range []string{"session1", "session1", "missing"} {
+		if err := store.Delete(id); err != nil {
+			t.Fatalf("failed to delete session: %v", err)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "session1" {
+		t.Errorf("expected OnDelete to be called once for session1, got %v", deleted)
+	}
+}
+
+func TestThatTheNotifyingStoreCallsOnDeleteForExpiredSessions(t *testing.T) {
+	var deleted []string
+	store := session.NewNotifyingStore(session.NewMemoryStore(), func(r session.Record) error {
+		deleted = append(deleted, r.ID)
+		return nil
+	})
+	expired := sessiontest.NewRecord("session1", "a@example.com")
+	expired.Expires = time.Now().Add(-time.Minute)
+	if err := store.Put(expired); err != nil {
+		t.Fatalf("failed to put session: %v", err)
+	}
+	if _, ok, err := store.Get(expired.ID); ok || err != nil {
+		t.Fatalf("expected the session to have expired, got %v, %v", ok, err)
+	}
+	if len(deleted) != 1 || deleted[0] != "session1" {
+		t.Errorf("expected OnDelete to be called once for session1, got %v", deleted)
+	}
+}
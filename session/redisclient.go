@@ -0,0 +1,199 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply from the server, e.g. "ERR unknown command".
+type redisError string
+
+func (err redisError) Error() string {
+	return string(err)
+}
+
+// maxIdleRedisConns is the number of connections kept open between commands.
+const maxIdleRedisConns = 8
+
+// redisClient is a minimal client for servers which use the Redis serialization
+// protocol (RESP). Replies are returned as string (simple strings), int64 (integers),
+// []byte (bulk strings, nil if missing) or []interface{} (arrays).
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	m    sync.Mutex
+	idle []*redisConn
+}
+
+// newRedisClient creates a client from a URL in the form redis://[:password@]host[:port][/db].
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid Redis URL: expected the redis:// scheme, got %q", u.Scheme)
+	}
+	rc := &redisClient{
+		addr:    u.Host,
+		timeout: 5 * time.Second,
+	}
+	if u.Port() == "" {
+		rc.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rc.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if rc.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: the database %q is not a number", db)
+		}
+	}
+	return rc, nil
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (rc *redisClient) dial() (c *redisConn, err error) {
+	conn, err := net.DialTimeout("tcp", rc.addr, rc.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c = &redisConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+	if rc.password != "" {
+		if _, err = c.do(rc.timeout, "AUTH", rc.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if rc.db != 0 {
+		if _, err = c.do(rc.timeout, "SELECT", strconv.Itoa(rc.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs a command on an idle connection, or a new one if none are available.
+func (rc *redisClient) do(args ...string) (reply interface{}, err error) {
+	rc.m.Lock()
+	var c *redisConn
+	if n := len(rc.idle); n > 0 {
+		c, rc.idle = rc.idle[n-1], rc.idle[:n-1]
+	}
+	rc.m.Unlock()
+	if c == nil {
+		if c, err = rc.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err = c.do(rc.timeout, args...)
+	if _, isRedisError := err.(redisError); err != nil && !isRedisError {
+		// The connection is in an unknown state.
+		c.conn.Close()
+		return
+	}
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	if len(rc.idle) < maxIdleRedisConns {
+		rc.idle = append(rc.idle, c)
+	} else {
+		c.conn.Close()
+	}
+	return
+}
+
+// close closes the idle connections.
+func (rc *redisClient) close() {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	for _, c := range rc.idle {
+		c.conn.Close()
+	}
+	rc.idle = nil
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if re, ok := reply.(redisError); ok {
+		return nil, re
+	}
+	return reply, nil
+}
+
+func (c *redisConn) write(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("invalid reply from Redis")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected reply from Redis: %q", line)
+}
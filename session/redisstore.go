@@ -0,0 +1,489 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+// DefaultRedisCacheTTL is how long a RedisStore keeps a local copy of a session.
+const DefaultRedisCacheTTL = 5 * time.Minute
+
+// DefaultRedisKeyPrefix is prepended to the keys and channel used by a RedisStore.
+const DefaultRedisKeyPrefix = "gauth:"
+
+// A RedisStore holds sessions in Redis, so that sessions can be shared between multiple
+// instances of an application. Keys expire when the session does.
+//
+// Sessions are cached locally to avoid a round trip on every request. When a session is
+// changed or deleted, its ID is published on a channel, so that every instance evicts its
+// cached copy, and a revoked session can't be used on any instance. Sessions are only
+// cached while the instance is subscribed to the channel, since changes would otherwise be
+// missed.
+type RedisStore struct {
+	// KeyPrefix is prepended to keys and the channel name. Defaults to DefaultRedisKeyPrefix.
+	KeyPrefix string
+	// CacheTTL is how long sessions are cached locally. Zero disables the cache.
+	CacheTTL time.Duration
+
+	client *redisClient
+	now    func() time.Time
+
+	cacheMutex sync.Mutex
+	cache      map[string]cachedRecord
+	// reads are the Gets waiting for Redis, by session ID. They're marked as stale when
+	// the session is evicted, so that a copy read before a change isn't cached.
+	reads map[string][]*cacheRead
+	// subscribed is set while changes made by other instances are being received.
+	subscribed bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	subMutex sync.Mutex
+	sub      *redisConn
+}
+
+type cachedRecord struct {
+	record   Record
+	cachedAt time.Time
+}
+
+type cacheRead struct {
+	stale bool
+}
+
+// NewRedisStore creates a RedisStore which connects to the server at the URL, in the
+// form redis://[:password@]host[:port][/db]. It subscribes to changes made by other
+// instances in the background until Close is called.
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	client, err := newRedisClient(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("NewRedisStore: %v", err)
+	}
+	s := &RedisStore{
+		KeyPrefix: DefaultRedisKeyPrefix,
+		CacheTTL:  DefaultRedisCacheTTL,
+		client:    client,
+		now:       time.Now,
+		cache:     make(map[string]cachedRecord),
+		reads:     make(map[string][]*cacheRead),
+		stop:      make(chan struct{}),
+	}
+	go s.subscribe()
+	return s, nil
+}
+
+// Close stops listening for changes made by other instances, and closes connections.
+func (s *RedisStore) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.subMutex.Lock()
+		if s.sub != nil {
+			s.sub.conn.Close()
+		}
+		s.subMutex.Unlock()
+		s.client.close()
+	})
+	return nil
+}
+
+func (s *RedisStore) sessionKey(id string) string {
+	return s.KeyPrefix + "session:" + id
+}
+
+func (s *RedisStore) userKey(userID string) string {
+	return s.KeyPrefix + "user:" + userID
+}
+
+func (s *RedisStore) channel() string {
+	return s.KeyPrefix + "changed"
+}
+
+// subscribe evicts sessions from the cache when their IDs are published by any instance.
+// If the subscription is lost, caching stops and the cache is cleared, since changes may
+// have been missed.
+func (s *RedisStore) subscribe() {
+	log := logger.For(pkg, "RedisStore.subscribe")
+	retry := time.Second
+	for {
+		err := s.listen(func() {
+			retry = time.Second
+			s.setSubscribed(true)
+		})
+		s.setSubscribed(false)
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		log.WithError(err).Warn("Lost the subscription to session changes, reconnecting")
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(retry):
+		}
+		if retry < time.Minute {
+			retry *= 2
+		}
+	}
+}
+
+func (s *RedisStore) listen(subscribed func()) error {
+	c, err := s.client.dial()
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	s.subMutex.Lock()
+	select {
+	case <-s.stop:
+		s.subMutex.Unlock()
+		return nil
+	default:
+		s.sub = c
+	}
+	s.subMutex.Unlock()
+
+	c.conn.SetDeadline(time.Now().Add(s.client.timeout))
+	if err = c.write("SUBSCRIBE", s.channel()); err != nil {
+		return err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return err
+	}
+	if re, ok := reply.(redisError); ok {
+		return re
+	}
+	c.conn.SetDeadline(time.Time{})
+	subscribed()
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 || fmt.Sprintf("%s", msg[0]) != "message" {
+			continue
+		}
+		if id, ok := msg[2].([]byte); ok {
+			s.evict(string(id))
+		}
+	}
+}
+
+func (s *RedisStore) cached(id string) (r Record, ok bool) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	cr, ok := s.cache[id]
+	if ok && s.now().Sub(cr.cachedAt) >= s.CacheTTL {
+		delete(s.cache, id)
+		return r, false
+	}
+	return cr.record, ok
+}
+
+// beginRead records that the session is being read from Redis. It returns nil if the
+// session can't be cached, because the cache is disabled or changes aren't being received.
+func (s *RedisStore) beginRead(id string) *cacheRead {
+	if s.CacheTTL <= 0 {
+		return nil
+	}
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	if !s.subscribed {
+		return nil
+	}
+	cr := &cacheRead{}
+	s.reads[id] = append(s.reads[id], cr)
+	return cr
+}
+
+// endRead caches the record read from Redis, unless the session was evicted, or the
+// subscription was lost, while it was being read.
+func (s *RedisStore) endRead(id string, cr *cacheRead, r Record, ok bool) {
+	if cr == nil {
+		return
+	}
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	reads := s.reads[id]
+	for i := range reads {
+		if reads[i] == cr {
+			reads = append(reads[:i], reads[i+1:]...)
+			break
+		}
+	}
+	if len(reads) == 0 {
+		delete(s.reads, id)
+	} else {
+		s.reads[id] = reads
+	}
+	if ok && !cr.stale && s.subscribed {
+		s.cache[id] = cachedRecord{record: r, cachedAt: s.now()}
+	}
+}
+
+func (s *RedisStore) evict(id string) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	delete(s.cache, id)
+	for _, cr := range s.reads[id] {
+		cr.stale = true
+	}
+}
+
+// setSubscribed starts or stops caching. Either way, the cache is cleared, since changes
+// may have been missed while unsubscribed.
+func (s *RedisStore) setSubscribed(subscribed bool) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	s.subscribed = subscribed
+	s.cache = make(map[string]cachedRecord)
+	for _, reads := range s.reads {
+		for _, cr := range reads {
+			cr.stale = true
+		}
+	}
+}
+
+// publish tells all instances that the session has changed.
+func (s *RedisStore) publish(id string) error {
+	s.evict(id)
+	if _, err := s.client.do("PUBLISH", s.channel(), id); err != nil {
+		return fmt.Errorf("RedisStore: failed to publish session change: %v", err)
+	}
+	return nil
+}
+
+// Get returns the session with the ID.
+func (s *RedisStore) Get(id string) (r Record, ok bool, err error) {
+	if r, ok = s.cached(id); ok {
+		if r.Expired(s.now()) {
+			s.evict(id)
+			return Record{}, false, nil
+		}
+		return r, true, nil
+	}
+	cr := s.beginRead(id)
+	reply, err := s.client.do("GET", s.sessionKey(id))
+	if err != nil {
+		s.endRead(id, cr, r, false)
+		return r, false, fmt.Errorf("RedisStore: failed to get session: %v", err)
+	}
+	r, ok, err = s.decode(reply)
+	s.endRead(id, cr, r, ok && err == nil)
+	return
+}
+
+func (s *RedisStore) decode(reply interface{}) (r Record, ok bool, err error) {
+	data, _ := reply.([]byte)
+	if data == nil {
+		return r, false, nil
+	}
+	if err = json.Unmarshal(data, &r); err != nil {
+		return r, false, fmt.Errorf("RedisStore: failed to decode session: %v", err)
+	}
+	if r.Expired(s.now()) {
+		return Record{}, false, nil
+	}
+	return r, true, nil
+}
+
+// Put creates or replaces the session. The key expires when the session does.
+func (s *RedisStore) Put(r Record) error {
+	now := s.now()
+	if r.Expired(now) {
+		return s.Delete(r.ID)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("RedisStore: failed to encode session: %v", err)
+	}
+	args := []string{"SET", s.sessionKey(r.ID), string(data)}
+	if !r.Expires.IsZero() {
+		args = append(args, "PX", strconv.FormatInt(milliseconds(r.Expires.Sub(now)), 10))
+	}
+	if _, err = s.client.do(args...); err != nil {
+		return fmt.Errorf("RedisStore: failed to put session: %v", err)
+	}
+	if err = s.index(r, now); err != nil {
+		return err
+	}
+	return s.publish(r.ID)
+}
+
+// Touch renews the session, if it exists. The key is only replaced if it still exists,
+// so a session which is deleted while it's being renewed isn't brought back.
+func (s *RedisStore) Touch(id string, lastSeen, expires time.Time) (ok bool, err error) {
+	reply, err := s.client.do("GET", s.sessionKey(id))
+	if err != nil {
+		return false, fmt.Errorf("RedisStore: failed to get session: %v", err)
+	}
+	r, ok, err := s.decode(reply)
+	if !ok || err != nil {
+		return false, err
+	}
+	now := s.now()
+	r.LastSeen, r.Expires = lastSeen, expires
+	if r.Expired(now) {
+		return false, nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return false, fmt.Errorf("RedisStore: failed to encode session: %v", err)
+	}
+	args := []string{"SET", s.sessionKey(r.ID), string(data)}
+	if !r.Expires.IsZero() {
+		args = append(args, "PX", strconv.FormatInt(milliseconds(r.Expires.Sub(now)), 10))
+	}
+	// XX only sets the key if it exists, and the reply is nil if it doesn't.
+	if reply, err = s.client.do(append(args, "XX")...); err != nil {
+		return false, fmt.Errorf("RedisStore: failed to renew session: %v", err)
+	}
+	if reply == nil {
+		return false, nil
+	}
+	if err = s.index(r, now); err != nil {
+		return false, err
+	}
+	return true, s.publish(r.ID)
+}
+
+// index adds the session to the set of the user's sessions. The set expires when the
+// user's last session does.
+func (s *RedisStore) index(r Record, now time.Time) error {
+	key := s.userKey(r.Identity.UserID())
+	// PTTL returns -2 if the set doesn't exist, and -1 if it never expires, because one
+	// of the user's sessions never expires.
+	reply, err := s.client.do("PTTL", key)
+	if err != nil {
+		return fmt.Errorf("RedisStore: failed to index session: %v", err)
+	}
+	remaining, _ := reply.(int64)
+	if _, err = s.client.do("SADD", key, r.ID); err != nil {
+		return fmt.Errorf("RedisStore: failed to index session: %v", err)
+	}
+	if r.Expires.IsZero() {
+		_, err = s.client.do("PERSIST", key)
+	} else if ttl := milliseconds(r.Expires.Sub(now)); remaining == -2 || (remaining >= 0 && remaining < ttl) {
+		_, err = s.client.do("PEXPIRE", key, strconv.FormatInt(ttl, 10))
+	}
+	if err != nil {
+		return fmt.Errorf("RedisStore: failed to index session: %v", err)
+	}
+	return nil
+}
+
+func milliseconds(d time.Duration) int64 {
+	ms := int64(d / time.Millisecond)
+	if ms < 1 {
+		return 1
+	}
+	return ms
+}
+
+// Delete removes the session, and evicts it from the cache of every instance.
+func (s *RedisStore) Delete(id string) error {
+	reply, err := s.client.do("GET", s.sessionKey(id))
+	if err != nil {
+		return fmt.Errorf("RedisStore: failed to get session: %v", err)
+	}
+	if data, _ := reply.([]byte); data != nil {
+		var r Record
+		if err = json.Unmarshal(data, &r); err == nil {
+			if _, err = s.client.do("SREM", s.userKey(r.Identity.UserID()), id); err != nil {
+				return fmt.Errorf("RedisStore: failed to remove session from index: %v", err)
+			}
+		}
+	}
+	if _, err = s.client.do("DEL", s.sessionKey(id)); err != nil {
+		return fmt.Errorf("RedisStore: failed to delete session: %v", err)
+	}
+	return s.publish(id)
+}
+
+// List returns all sessions which have not expired.
+func (s *RedisStore) List() (records []Record, err error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.client.do("SCAN", cursor, "MATCH", s.sessionKey("*"), "COUNT", "100")
+		if err != nil {
+			return nil, fmt.Errorf("RedisStore: failed to list sessions: %v", err)
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return nil, fmt.Errorf("RedisStore: unexpected reply to SCAN")
+		}
+		cursor = fmt.Sprintf("%s", values[0])
+		page, _ := values[1].([]interface{})
+		for _, key := range page {
+			keys = append(keys, fmt.Sprintf("%s", key))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	records, _, err = s.getAll(keys)
+	return
+}
+
+// ListByUser returns the sessions of a user which have not expired.
+func (s *RedisStore) ListByUser(userID string) (records []Record, err error) {
+	key := s.userKey(userID)
+	reply, err := s.client.do("SMEMBERS", key)
+	if err != nil {
+		return nil, fmt.Errorf("RedisStore: failed to list sessions: %v", err)
+	}
+	members, _ := reply.([]interface{})
+	var keys []string
+	for _, id := range members {
+		keys = append(keys, s.sessionKey(fmt.Sprintf("%s", id)))
+	}
+	records, missing, err := s.getAll(keys)
+	if err != nil || len(missing) == 0 {
+		return
+	}
+	// Remove sessions which have expired from the index.
+	args := []string{"SREM", key}
+	for _, k := range missing {
+		args = append(args, strings.TrimPrefix(k, s.sessionKey("")))
+	}
+	if _, err = s.client.do(args...); err != nil {
+		return nil, fmt.Errorf("RedisStore: failed to remove expired sessions from index: %v", err)
+	}
+	return
+}
+
+// getAll returns the sessions stored at the keys, and the keys which no longer exist.
+func (s *RedisStore) getAll(keys []string) (records []Record, missing []string, err error) {
+	if len(keys) == 0 {
+		return
+	}
+	reply, err := s.client.do(append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("RedisStore: failed to get sessions: %v", err)
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != len(keys) {
+		return nil, nil, fmt.Errorf("RedisStore: unexpected reply to MGET")
+	}
+	for i, v := range values {
+		r, ok, err := s.decode(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			missing = append(missing, keys[i])
+			continue
+		}
+		records = append(records, r)
+	}
+	return
+}
@@ -0,0 +1,436 @@
+package session_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/session/sessiontest"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server, which supports the commands
+// used by the RedisStore.
+type fakeRedis struct {
+	ln          net.Listener
+	m           sync.Mutex
+	data        map[string]*fakeValue
+	subscribers map[string][]*fakeRedisConn
+	// refuseSubscriptions makes SUBSCRIBE fail, and counts the attempts.
+	refuseSubscriptions bool
+	refused             int
+}
+
+type fakeValue struct {
+	str     []byte
+	set     map[string]bool
+	expires time.Time
+}
+
+type fakeRedisConn struct {
+	m    sync.Mutex
+	w    *bufio.Writer
+	conn net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	fr := &fakeRedis{
+		ln:          ln,
+		data:        make(map[string]*fakeValue),
+		subscribers: make(map[string][]*fakeRedisConn),
+	}
+	go fr.serve()
+	t.Cleanup(func() { ln.Close() })
+	return fr
+}
+
+func (fr *fakeRedis) url() string {
+	return "redis://" + fr.ln.Addr().String()
+}
+
+func (fr *fakeRedis) serve() {
+	for {
+		conn, err := fr.ln.Accept()
+		if err != nil {
+			return
+		}
+		go fr.handle(conn)
+	}
+}
+
+func (fr *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	c := &fakeRedisConn{w: bufio.NewWriter(conn), conn: conn}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		reply := fr.execute(c, args)
+		c.m.Lock()
+		writeReply(c.w, reply)
+		c.w.Flush()
+		c.m.Unlock()
+	}
+}
+
+func readCommand(r *bufio.Reader) (args []string, err error) {
+	var n int
+	if _, err = fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		var size int
+		if _, err = fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return
+		}
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return
+		}
+		args = append(args, string(b[:size]))
+	}
+	return
+}
+
+type fakeRedisError string
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case fakeRedisError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+// get returns the value at the key, removing it if it has expired.
+func (fr *fakeRedis) get(key string) *fakeValue {
+	v, ok := fr.data[key]
+	if ok && !v.expires.IsZero() && !time.Now().Before(v.expires) {
+		delete(fr.data, key)
+		return nil
+	}
+	return v
+}
+
+func (fr *fakeRedis) execute(c *fakeRedisConn, args []string) interface{} {
+	fr.m.Lock()
+	defer fr.m.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "OK"
+	case "GET":
+		if v := fr.get(args[1]); v != nil {
+			return v.str
+		}
+		return nil
+	case "MGET":
+		values := []interface{}{}
+		for _, key := range args[1:] {
+			if v := fr.get(key); v != nil {
+				values = append(values, v.str)
+				continue
+			}
+			values = append(values, nil)
+		}
+		return values
+	case "SET":
+		v := &fakeValue{str: []byte(args[2])}
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "PX":
+				i++
+				ms, _ := strconv.Atoi(args[i])
+				v.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			case "XX":
+				if fr.get(args[1]) == nil {
+					return nil
+				}
+			}
+		}
+		fr.data[args[1]] = v
+		return "OK"
+	case "DEL":
+		var n int
+		for _, key := range args[1:] {
+			if fr.get(key) != nil {
+				delete(fr.data, key)
+				n++
+			}
+		}
+		return n
+	case "SADD":
+		v := fr.get(args[1])
+		if v == nil {
+			v = &fakeValue{set: make(map[string]bool)}
+			fr.data[args[1]] = v
+		}
+		for _, member := range args[2:] {
+			v.set[member] = true
+		}
+		return len(args) - 2
+	case "SREM":
+		if v := fr.get(args[1]); v != nil {
+			for _, member := range args[2:] {
+				delete(v.set, member)
+			}
+			if len(v.set) == 0 {
+				delete(fr.data, args[1])
+			}
+		}
+		return len(args) - 2
+	case "SMEMBERS":
+		members := []interface{}{}
+		if v := fr.get(args[1]); v != nil {
+			for member := range v.set {
+				members = append(members, []byte(member))
+			}
+		}
+		return members
+	case "PTTL":
+		v := fr.get(args[1])
+		if v == nil {
+			return -2
+		}
+		if v.expires.IsZero() {
+			return -1
+		}
+		return int(time.Until(v.expires) / time.Millisecond)
+	case "PEXPIRE":
+		if v := fr.get(args[1]); v != nil {
+			ms, _ := strconv.Atoi(args[2])
+			v.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			return 1
+		}
+		return 0
+	case "PERSIST":
+		if v := fr.get(args[1]); v != nil {
+			v.expires = time.Time{}
+			return 1
+		}
+		return 0
+	case "SCAN":
+		keys := []interface{}{}
+		for key := range fr.data {
+			if matched, _ := path.Match(args[3], key); matched && fr.get(key) != nil {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}
+	case "SUBSCRIBE":
+		if fr.refuseSubscriptions {
+			fr.refused++
+			return fakeRedisError("ERR subscriptions are unavailable")
+		}
+		fr.subscribers[args[1]] = append(fr.subscribers[args[1]], c)
+		return []interface{}{[]byte("subscribe"), []byte(args[1]), 1}
+	case "PUBLISH":
+		subscribers := fr.subscribers[args[1]]
+		for _, sub := range subscribers {
+			sub.m.Lock()
+			writeReply(sub.w, []interface{}{[]byte("message"), []byte(args[1]), []byte(args[2])})
+			sub.w.Flush()
+			sub.m.Unlock()
+		}
+		return len(subscribers)
+	}
+	return fakeRedisError("ERR unknown command '" + args[0] + "'")
+}
+
+func (fr *fakeRedis) subscriberCount() int {
+	fr.m.Lock()
+	defer fr.m.Unlock()
+	var n int
+	for _, subs := range fr.subscribers {
+		n += len(subs)
+	}
+	return n
+}
+
+// disconnectSubscribers drops the connections of all subscribers, and refuses new
+// subscriptions until reconnections are allowed.
+func (fr *fakeRedis) disconnectSubscribers() {
+	fr.m.Lock()
+	defer fr.m.Unlock()
+	fr.refuseSubscriptions = true
+	fr.refused = 0
+	for channel, subs := range fr.subscribers {
+		for _, sub := range subs {
+			sub.conn.Close()
+		}
+		delete(fr.subscribers, channel)
+	}
+}
+
+func (fr *fakeRedis) refusedSubscriptions() int {
+	fr.m.Lock()
+	defer fr.m.Unlock()
+	return fr.refused
+}
+
+func (fr *fakeRedis) del(key string) {
+	fr.m.Lock()
+	defer fr.m.Unlock()
+	delete(fr.data, key)
+}
+
+func newRedisStore(t *testing.T, fr *fakeRedis) *session.RedisStore {
+	store, err := session.NewRedisStore(fr.url())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisStore(t *testing.T) {
+	sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+		return newRedisStore(t, newFakeRedis(t))
+	})
+}
+
+func TestThatRedisKeysExpireWithTheSession(t *testing.T) {
+	fr := newFakeRedis(t)
+	store := newRedisStore(t, fr)
+
+	r := sessiontest.NewRecord("session1", "a@example.com")
+	r.Expires = time.Now().Add(50 * time.Millisecond)
+	if err := store.Put(r); err != nil {
+		t.Fatalf("failed to put session: %v", err)
+	}
+	keys := []string{"gauth:session:" + r.ID, "gauth:user:" + r.Identity.UserID()}
+	expired := func() (n int) {
+		fr.m.Lock()
+		defer fr.m.Unlock()
+		for _, key := range keys {
+			if fr.get(key) == nil {
+				n++
+			}
+		}
+		return
+	}
+	if n := expired(); n != 0 {
+		t.Fatalf("expected the session and index to be stored, but %d keys are missing", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := expired(); n != len(keys) {
+		t.Errorf("expected the session and index to expire, but %d keys remain", len(keys)-n)
+	}
+}
+
+func TestThatDeletingASessionEvictsItFromTheCacheOfOtherInstances(t *testing.T) {
+	fr := newFakeRedis(t)
+	a, b := newRedisStore(t, fr), newRedisStore(t, fr)
+	for deadline := time.Now().Add(5 * time.Second); fr.subscriberCount() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the stores to subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r := sessiontest.NewRecord("session1", "a@example.com")
+	waitUntilCached(t, fr, a, b, r)
+
+	if err := a.Delete(r.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		_, ok, err := b.Get(r.ID)
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the revoked session to be evicted from the other instance's cache")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitUntilCached puts the record using a, and waits until b serves it from its cache,
+// which it only does once it has subscribed to changes.
+func waitUntilCached(t *testing.T, fr *fakeRedis, a, b *session.RedisStore, r session.Record) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if err := a.Put(r); err != nil {
+			t.Fatalf("failed to put session: %v", err)
+		}
+		// b doesn't cache the session if a's change notification arrives while it's reading.
+		time.Sleep(10 * time.Millisecond)
+		if _, ok, err := b.Get(r.ID); err != nil || !ok {
+			t.Fatalf("expected the session to be shared, got ok=%v, err=%v", ok, err)
+		}
+		// Removing the key behind the stores' backs shows whether b serves a cached copy.
+		fr.del("gauth:session:" + r.ID)
+		if _, ok, _ := b.Get(r.ID); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the session to be cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThatSessionsAreNotCachedWhileChangesCantBeReceived(t *testing.T) {
+	fr := newFakeRedis(t)
+	a, b := newRedisStore(t, fr), newRedisStore(t, fr)
+	r := sessiontest.NewRecord("session1", "a@example.com")
+	waitUntilCached(t, fr, a, b, r)
+
+	// Lose the subscriptions, and wait until both stores have tried to subscribe again.
+	fr.disconnectSubscribers()
+	for deadline := time.Now().Add(5 * time.Second); fr.refusedSubscriptions() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the stores to resubscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The session is read while b can't receive changes, then revoked by a.
+	if err := a.Put(r); err != nil {
+		t.Fatalf("failed to put session: %v", err)
+	}
+	if _, ok, err := b.Get(r.ID); err != nil || !ok {
+		t.Fatalf("expected the session to be shared, got ok=%v, err=%v", ok, err)
+	}
+	if err := a.Delete(r.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if _, ok, err := b.Get(r.ID); err != nil || ok {
+		t.Errorf("expected the revoked session not to be served from the cache, got ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestThatRedisURLsAreValidated(t *testing.T) {
+	for _, u := range []string{"http://localhost:6379", "redis://localhost:6379/zero", "://"} {
+		if _, err := session.NewRedisStore(u); err == nil {
+			t.Errorf("%q: expected an error", u)
+		}
+	}
+}
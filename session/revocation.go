@@ -0,0 +1,209 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+// ErrRevoked is returned by Validate when the session has been revoked.
+var ErrRevoked = errors.New("session: the session has been revoked")
+
+// DefaultRevocationReloadInterval is how often a RevocationList checks its file for changes.
+const DefaultRevocationReloadInterval = 10 * time.Second
+
+// A RevocationChecker decides whether a session has been revoked, so that access can be
+// removed before the session expires.
+type RevocationChecker interface {
+	// IsRevoked returns true if the session with the ID, issued to the user at the
+	// issued time, has been revoked. The ID is empty for sessions issued by earlier
+	// versions, which didn't have IDs.
+	IsRevoked(sessionID string, user identity.Identity, issued time.Time) (bool, error)
+}
+
+// A RevocationList is an in-memory RevocationChecker. Sessions can be revoked by ID,
+// all of a user's sessions issued before a time can be revoked, or all sessions issued
+// before a time can be revoked.
+type RevocationList struct {
+	m        sync.RWMutex
+	sessions map[string]bool
+	// users maps email addresses and subjects to the time before which sessions are revoked.
+	users map[string]time.Time
+	// all is the time before which all sessions are revoked.
+	all time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRevocationList creates an empty RevocationList.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		sessions: make(map[string]bool),
+		users:    make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+}
+
+// NewFileRevocationList creates a RevocationList from a file, and reloads it whenever the
+// file changes, checking every interval until Close is called. If the file can't be
+// reloaded, the previous list is kept. Each line of the file revokes sessions:
+//
+//	# Comments and blank lines are ignored.
+//	session <session ID>
+//	user <email address or subject> <RFC 3339 time>
+//	all <RFC 3339 time>
+//
+// where the time is when the sessions were revoked. Sessions issued after that time
+// are not affected.
+func NewFileRevocationList(fileName string, interval time.Duration) (*RevocationList, error) {
+	rl := NewRevocationList()
+	modTime, err := rl.loadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	go rl.watch(fileName, modTime, interval)
+	return rl, nil
+}
+
+// Close stops reloading the file.
+func (rl *RevocationList) Close() error {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+	return nil
+}
+
+func (rl *RevocationList) watch(fileName string, modTime time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(fileName)
+		if err != nil {
+			logger.For(pkg, "RevocationList.watch").WithError(err).Error("Failed to check the revocation list, keeping the previous list")
+			continue
+		}
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+		if _, err = rl.loadFile(fileName); err != nil {
+			logger.For(pkg, "RevocationList.watch").WithError(err).Error("Failed to reload the revocation list, keeping the previous list")
+		}
+	}
+}
+
+func (rl *RevocationList) loadFile(fileName string) (modTime time.Time, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return modTime, fmt.Errorf("RevocationList: failed to open file: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return modTime, fmt.Errorf("RevocationList: failed to stat file: %v", err)
+	}
+	if err = rl.Load(f); err != nil {
+		return modTime, fmt.Errorf("RevocationList: %s: %v", fileName, err)
+	}
+	return fi.ModTime(), nil
+}
+
+// Load replaces the contents of the list with the revocations read from r, in the
+// format described by NewFileRevocationList.
+func (rl *RevocationList) Load(r io.Reader) error {
+	sessions := make(map[string]bool)
+	users := make(map[string]time.Time)
+	var all time.Time
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch {
+		case fields[0] == "session" && len(fields) == 2:
+			sessions[fields[1]] = true
+		case fields[0] == "user" && len(fields) == 3:
+			before, err := time.Parse(time.RFC3339, fields[2])
+			if err != nil {
+				return fmt.Errorf("line %d: invalid time: %v", n, err)
+			}
+			users[fields[1]] = latest(users[fields[1]], before)
+		case fields[0] == "all" && len(fields) == 2:
+			before, err := time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				return fmt.Errorf("line %d: invalid time: %v", n, err)
+			}
+			all = latest(all, before)
+		default:
+			return fmt.Errorf("line %d: expected 'session <id>', 'user <user> <time>' or 'all <time>'", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	rl.sessions, rl.users, rl.all = sessions, users, all
+	return nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// RevokeSession revokes the session with the ID. If the list is loaded from a file,
+// the revocation is lost when the file is reloaded.
+func (rl *RevocationList) RevokeSession(sessionID string) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	rl.sessions[sessionID] = true
+}
+
+// RevokeUser revokes the sessions issued before the time to the user with the email
+// address or subject.
+func (rl *RevocationList) RevokeUser(user string, before time.Time) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	rl.users[user] = latest(rl.users[user], before)
+}
+
+// RevokeAll revokes all sessions issued before the time.
+func (rl *RevocationList) RevokeAll(before time.Time) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	rl.all = latest(rl.all, before)
+}
+
+// IsRevoked returns true if the session has been revoked.
+func (rl *RevocationList) IsRevoked(sessionID string, user identity.Identity, issued time.Time) (bool, error) {
+	rl.m.RLock()
+	defer rl.m.RUnlock()
+	if sessionID != "" && rl.sessions[sessionID] {
+		return true, nil
+	}
+	if issued.Before(rl.all) {
+		return true, nil
+	}
+	for _, u := range []string{user.Email, user.Subject} {
+		if before, ok := rl.users[u]; u != "" && ok && issued.Before(before) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
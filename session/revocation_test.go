@@ -0,0 +1,151 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+)
+
+func TestRevocationListLoad(t *testing.T) {
+	revokedAt := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		input       string
+		sessionID   string
+		user        identity.Identity
+		issued      time.Time
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name:     "empty",
+			input:    "# Nothing revoked.\n\n",
+			expected: false,
+		},
+		{
+			name:      "session",
+			input:     "session abc\n",
+			sessionID: "abc",
+			expected:  true,
+		},
+		{
+			name:      "other session",
+			input:     "session abc\n",
+			sessionID: "def",
+			issued:    revokedAt,
+			expected:  false,
+		},
+		{
+			name:     "user before the revocation",
+			input:    "user alice@example.com 2020-01-02T12:00:00Z\n",
+			user:     identity.Identity{Email: "alice@example.com"},
+			issued:   revokedAt.Add(-time.Second),
+			expected: true,
+		},
+		{
+			name:     "user after the revocation",
+			input:    "user alice@example.com 2020-01-02T12:00:00Z\n",
+			user:     identity.Identity{Email: "alice@example.com"},
+			issued:   revokedAt,
+			expected: false,
+		},
+		{
+			name:     "other user",
+			input:    "user alice@example.com 2020-01-02T12:00:00Z\n",
+			user:     identity.Identity{Email: "bob@example.com"},
+			issued:   revokedAt.Add(-time.Second),
+			expected: false,
+		},
+		{
+			name:     "all",
+			input:    "all 2020-01-02T12:00:00Z\n",
+			user:     identity.Identity{Email: "bob@example.com"},
+			issued:   revokedAt.Add(-time.Second),
+			expected: true,
+		},
+		{
+			name:        "unknown entry",
+			input:       "everyone\n",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid time",
+			input:       "all yesterday\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		rl := NewRevocationList()
+		err := rl.Load(strings.NewReader(test.input))
+		if test.expectedErr != (err != nil) {
+			t.Fatalf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		actual, err := rl.IsRevoked(test.sessionID, test.user, test.issued)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected revoked %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestThatRevocationListsAreReloadedWhenTheFileChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := filepath.Join(dir, "revoked.txt")
+	if err = ioutil.WriteFile(fn, []byte("session abc\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	rl, err := NewFileRevocationList(fn, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rl.Close()
+	isRevoked := func(sessionID string) bool {
+		revoked, _ := rl.IsRevoked(sessionID, identity.Identity{}, time.Now())
+		return revoked
+	}
+	if !isRevoked("abc") || isRevoked("def") {
+		t.Fatal("expected only session abc to be revoked")
+	}
+
+	// An invalid file is ignored.
+	modTime := time.Now().Add(time.Second)
+	if err = ioutil.WriteFile(fn, []byte("invalid\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	os.Chtimes(fn, modTime, modTime)
+	time.Sleep(50 * time.Millisecond)
+	if !isRevoked("abc") {
+		t.Error("expected the previous list to be kept when the file is invalid")
+	}
+
+	modTime = modTime.Add(time.Second)
+	if err = ioutil.WriteFile(fn, []byte("session def\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	os.Chtimes(fn, modTime, modTime)
+	for deadline := time.Now().Add(5 * time.Second); !isRevoked("def"); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the file to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if isRevoked("abc") {
+		t.Error("expected session abc to no longer be revoked")
+	}
+}
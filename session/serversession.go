@@ -0,0 +1,204 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+// ErrTooManySessions is returned by Start when the user has reached the maximum number of
+// concurrent sessions, and the SessionLimitPolicy is RefuseNewSession.
+var ErrTooManySessions = errors.New("session: the user has too many sessions")
+
+// A SessionLimitPolicy determines what happens when a user who has reached the maximum
+// number of concurrent sessions logs in again.
+type SessionLimitPolicy int
+
+const (
+	// EvictOldestSession ends the user's oldest sessions to make room for the new one.
+	EvictOldestSession SessionLimitPolicy = iota
+	// RefuseNewSession refuses the new session, leaving the existing sessions in place.
+	RefuseNewSession
+)
+
+// A ServerSession holds sessions in a SessionStore. The cookie only contains a
+// random session ID, so sessions can be listed and revoked individually.
+type ServerSession struct {
+	Store      SessionStore
+	CookieName string
+	// CookieOptions are the attributes of the session cookie.
+	CookieOptions CookieOptions
+	Lifetime      Lifetime
+	// MaxSessionsPerUser limits the number of concurrent sessions each user can have, e.g.
+	// to prevent accounts from being shared. Zero means no limit. The limit is best effort,
+	// since logins which happen at the same time can each see room for a new session.
+	MaxSessionsPerUser int
+	// SessionLimitPolicy determines what happens when the limit is reached.
+	SessionLimitPolicy SessionLimitPolicy
+	// TrustedProxies are the networks of load balancers and reverse proxies, whose
+	// X-Forwarded-For headers are used to find the address of the client, which is recorded
+	// with the session.
+	TrustedProxies []*net.IPNet
+	now            func() time.Time
+}
+
+// NewServerSession creates a Session which holds sessions in the store.
+func NewServerSession(store SessionStore, setSecureFlag bool, cookieName string) *ServerSession {
+	return &ServerSession{
+		Store:         store,
+		CookieName:    cookieName,
+		CookieOptions: DefaultCookieOptions(setSecureFlag),
+		Lifetime:      DefaultLifetime,
+		now:           time.Now,
+	}
+}
+
+// newSessionID creates a random, unguessable session ID.
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Start creates a new session in the store and issues a cookie containing its ID. Any
+// session presented with the request is deleted from the store first. If the user has
+// reached MaxSessionsPerUser, their oldest sessions are ended, or ErrTooManySessions is
+// returned, depending on the SessionLimitPolicy.
+func (ss ServerSession) Start(w http.ResponseWriter, r *http.Request, id identity.Identity) error {
+	if c, err := r.Cookie(ss.CookieName); err == nil && c.Value != "" {
+		if err = ss.Store.Delete(c.Value); err != nil {
+			return fmt.Errorf("ServerSession.Start: failed to discard the existing session: %v", err)
+		}
+		logger.For(pkg, "ServerSession.Start").WithField("email", id.Email).Info("Discarded the existing session")
+	}
+	if err := ss.limitSessions(id); err != nil {
+		return err
+	}
+	sessionID, err := newSessionID()
+	if err != nil {
+		return fmt.Errorf("ServerSession.Start: failed to create session ID: %v", err)
+	}
+	ip := remoteIP(r)
+	if clientIP := ClientIP(r, ss.TrustedProxies); clientIP != nil {
+		ip = clientIP.String()
+	}
+	now := ss.now()
+	record := Record{
+		ID:        sessionID,
+		Identity:  id,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   ss.Lifetime.expiry(now, now),
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+	if err = ss.Store.Put(record); err != nil {
+		return fmt.Errorf("ServerSession.Start: failed to store session: %v", err)
+	}
+	ss.setCookie(w, sessionID, ss.CookieOptions.maxAge(ss.Lifetime.cookieMaxAge(now, now)))
+	return nil
+}
+
+// limitSessions makes room for a new session for the user, if they have reached
+// MaxSessionsPerUser. Listing the sessions and deleting the oldest aren't atomic, so
+// concurrent logins by the same user, e.g. on several instances, can briefly exceed the
+// limit. It's corrected on the user's next login.
+func (ss ServerSession) limitSessions(id identity.Identity) error {
+	if ss.MaxSessionsPerUser <= 0 {
+		return nil
+	}
+	records, err := ss.Store.ListByUser(id.UserID())
+	if err != nil {
+		return fmt.Errorf("ServerSession.Start: failed to list the user's sessions: %v", err)
+	}
+	excess := len(records) - ss.MaxSessionsPerUser + 1
+	if excess <= 0 {
+		return nil
+	}
+	if ss.SessionLimitPolicy == RefuseNewSession {
+		return ErrTooManySessions
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	for _, record := range records[:excess] {
+		if err = ss.Store.Delete(record.ID); err != nil {
+			return fmt.Errorf("ServerSession.Start: failed to end the user's oldest session: %v", err)
+		}
+		logger.For(pkg, "ServerSession.Start").WithField("email", id.Email).Info("Ended the oldest session, because the user has too many sessions")
+	}
+	return nil
+}
+
+func (ss ServerSession) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, ss.CookieOptions.cookie(ss.CookieName, value, maxAge))
+}
+
+// remoteIP returns the IP address of the client, without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Validate checks whether the session in the cookie exists in the store, and hasn't
+// expired.
+func (ss ServerSession) Validate(w http.ResponseWriter, r *http.Request) (isValid bool, id identity.Identity, err error) {
+	c, err := r.Cookie(ss.CookieName)
+	if err != nil {
+		return false, id, nil
+	}
+	record, ok, err := ss.Store.Get(c.Value)
+	if err != nil {
+		err = fmt.Errorf("ServerSession.Validate: failed to get the session from the store: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	now := ss.now()
+	if ss.Lifetime.expired(record.Created, record.LastSeen, now) {
+		if err = ss.Store.Delete(record.ID); err != nil {
+			err = fmt.Errorf("ServerSession.Validate: failed to delete expired session: %v", err)
+			return false, record.Identity, err
+		}
+		return false, record.Identity, ErrExpired
+	}
+	if ss.Lifetime.shouldRenew(record.LastSeen, now) {
+		// The session may have been ended, e.g. by logging out or being revoked, since it
+		// was read, so it's only renewed if it still exists.
+		ok, err = ss.Store.Touch(record.ID, now, ss.Lifetime.expiry(record.Created, now))
+		if err != nil {
+			err = fmt.Errorf("ServerSession.Validate: failed to renew the session: %v", err)
+			return false, record.Identity, err
+		}
+		if !ok {
+			return false, record.Identity, nil
+		}
+		ss.setCookie(w, record.ID, ss.CookieOptions.maxAge(ss.Lifetime.cookieMaxAge(record.Created, now)))
+	}
+	return true, record.Identity, nil
+}
+
+// End deletes the session from the store and expires the cookie.
+func (ss ServerSession) End(w http.ResponseWriter, r *http.Request) error {
+	if c, err := r.Cookie(ss.CookieName); err == nil {
+		if err = ss.Store.Delete(c.Value); err != nil {
+			return fmt.Errorf("ServerSession.End: failed to delete the session: %v", err)
+		}
+	}
+	ss.setCookie(w, "", -1)
+	return nil
+}
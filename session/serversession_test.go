@@ -0,0 +1,297 @@
+package session
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+)
+
+func TestServerSession(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	s := NewServerSession(store, true, "cookie-name")
+	s.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	w := httptest.NewRecorder()
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: now}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("expected a secure, HTTP only cookie, got %v", cookies)
+	}
+
+	records, err := store.List()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected a single session in the store, got %v, %v", records, err)
+	}
+	if records[0].ID != cookies[0].Value {
+		t.Errorf("expected the cookie to contain the session ID %q, got %q", records[0].ID, cookies[0].Value)
+	}
+	if records[0].IP != "192.0.2.1" || records[0].UserAgent != "Mozilla/5.0" {
+		t.Errorf("expected the IP address and user agent to be recorded, got %v", records[0])
+	}
+
+	validate := func() (bool, identity.Identity, error) {
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return s.Validate(httptest.NewRecorder(), r)
+	}
+
+	now = now.Add(time.Hour)
+	isValid, id, err := validate()
+	if !isValid || err != nil {
+		t.Fatalf("expected the session to be valid, got valid %v, error %v", isValid, err)
+	}
+	if id.Email != "test@example.com" {
+		t.Errorf("expected email test@example.com, got %v", id.Email)
+	}
+	if r, _, _ := store.Get(records[0].ID); !r.LastSeen.Equal(now) {
+		t.Errorf("expected the session to be renewed, but was last seen at %v", r.LastSeen)
+	}
+
+	// Revoking the session in the store ends it.
+	if err = store.Delete(records[0].ID); err != nil {
+		t.Fatalf("unexpected error deleting the session: %v", err)
+	}
+	if isValid, _, err = validate(); isValid || err != nil {
+		t.Errorf("expected a deleted session to be invalid, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatServerSessionsExpire(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	store := NewMemoryStore()
+	s := NewServerSession(store, false, "cookie-name")
+	s.now = func() time.Time { return now }
+	store.now = s.now
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: "test@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	// Sessions which have passed their expiry are removed by the store.
+	now = now.Add(DefaultLifetime.Idle + time.Second)
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); isValid || err != nil {
+		t.Errorf("expected the session to be invalid, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatEndingAServerSessionDeletesIt(t *testing.T) {
+	store := NewMemoryStore()
+	s := NewServerSession(store, false, "cookie-name")
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: "test@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	if err := s.End(w, r); err != nil {
+		t.Fatalf("unexpected error ending the session: %v", err)
+	}
+	if records, _ := store.List(); len(records) != 0 {
+		t.Errorf("expected the session to be deleted, got %v", records)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be expired, got %v", cookies)
+	}
+}
+
+func TestThatServerSessionsAreLimitedPerUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        SessionLimitPolicy
+		expectedErr   error
+		expectedFirst bool
+		expectedLast  bool
+	}{
+		{
+			name:          "the oldest session is evicted",
+			policy:        EvictOldestSession,
+			expectedFirst: false,
+			expectedLast:  true,
+		},
+		{
+			name:          "the new session is refused",
+			policy:        RefuseNewSession,
+			expectedErr:   ErrTooManySessions,
+			expectedFirst: true,
+			expectedLast:  false,
+		},
+	}
+
+	for _, test := range tests {
+		now := time.Unix(1433978353, 0)
+		store := NewMemoryStore()
+		store.now = func() time.Time { return now }
+		s := NewServerSession(store, true, "cookie-name")
+		s.now = func() time.Time { return now }
+		s.MaxSessionsPerUser = 2
+		s.SessionLimitPolicy = test.policy
+
+		start := func(email string) (cookieValue string, err error) {
+			now = now.Add(time.Minute)
+			w := httptest.NewRecorder()
+			err = s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: email, LoginTime: now})
+			if cookies := w.Result().Cookies(); len(cookies) == 1 {
+				cookieValue = cookies[0].Value
+			}
+			return
+		}
+		exists := func(id string) bool {
+			_, ok, _ := store.Get(id)
+			return ok
+		}
+
+		first, _ := start("a@example.com")
+		second, _ := start("a@example.com")
+		other, _ := start("b@example.com")
+		last, err := start("a@example.com")
+		if err != test.expectedErr {
+			t.Fatalf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+		if exists(first) != test.expectedFirst {
+			t.Errorf("%s: expected the first session to exist %v", test.name, test.expectedFirst)
+		}
+		if (last != "" && exists(last)) != test.expectedLast {
+			t.Errorf("%s: expected the last session to exist %v", test.name, test.expectedLast)
+		}
+		if !exists(second) || !exists(other) {
+			t.Errorf("%s: expected the other sessions to be unaffected", test.name)
+		}
+	}
+}
+
+func TestThatStartingAServerSessionDiscardsTheExistingSession(t *testing.T) {
+	store := NewMemoryStore()
+	s := NewServerSession(store, false, "cookie-name")
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: "attacker@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	planted := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.AddCookie(planted)
+	w = httptest.NewRecorder()
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	issued := w.Result().Cookies()[0]
+	if issued.Value == planted.Value {
+		t.Errorf("expected a new session ID to be issued")
+	}
+	if _, ok, _ := store.Get(planted.Value); ok {
+		t.Errorf("expected the existing session to be deleted")
+	}
+	if r, ok, _ := store.Get(issued.Value); !ok || r.Identity.Email != "test@example.com" {
+		t.Errorf("expected the new session to be stored, got %v", r)
+	}
+}
+
+func TestThatTheMemoryStorePrunesExpiredSessions(t *testing.T) {
+	now := time.Now()
+	ms := NewMemoryStore()
+	ms.now = func() time.Time { return now }
+	ms.Put(Record{ID: "expired", Expires: now.Add(time.Minute)})
+	ms.Put(Record{ID: "current", Expires: now.Add(time.Hour)})
+
+	// Expired sessions are removed by the next Put, even if they're never read.
+	now = now.Add(2 * time.Minute)
+	ms.Put(Record{ID: "new", Expires: now.Add(time.Hour)})
+	if _, ok := ms.records["expired"]; ok {
+		t.Errorf("expected the expired session to be pruned")
+	}
+	if len(ms.records) != 2 {
+		t.Errorf("expected 2 sessions, got %d", len(ms.records))
+	}
+}
+
+// deletingStore deletes each session after it's read, as if it were revoked while the
+// request was being handled.
+type deletingStore struct {
+	*MemoryStore
+}
+
+func (ds deletingStore) Get(id string) (r Record, ok bool, err error) {
+	r, ok, err = ds.MemoryStore.Get(id)
+	ds.MemoryStore.Delete(id)
+	return
+}
+
+func TestThatRenewingAServerSessionDoesNotRestoreADeletedSession(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	s := NewServerSession(deletingStore{store}, false, "cookie-name")
+	s.now = store.now
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{Email: "test@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	now = now.Add(time.Hour)
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); isValid || err != nil {
+		t.Errorf("expected the session to be invalid, got valid %v, error %v", isValid, err)
+	}
+	if records, _ := store.List(); len(records) != 0 {
+		t.Errorf("expected the session to stay deleted, got %v", records)
+	}
+}
+
+func TestThatServerSessionsRecordTheAddressOfClientsBehindTrustedProxies(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name           string
+		trustedProxies []*net.IPNet
+		expected       string
+	}{
+		{
+			name:     "the address of the connection is recorded by default",
+			expected: "10.0.0.1",
+		},
+		{
+			name:           "the forwarded address is recorded if the request came from a trusted proxy",
+			trustedProxies: []*net.IPNet{proxies},
+			expected:       "192.0.2.7",
+		},
+	}
+	for _, test := range tests {
+		store := NewMemoryStore()
+		s := NewServerSession(store, false, "cookie-name")
+		s.TrustedProxies = test.trustedProxies
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "192.0.2.7")
+		if err := s.Start(httptest.NewRecorder(), r, identity.Identity{Email: "test@example.com"}); err != nil {
+			t.Fatalf("%s: unexpected error starting the session: %v", test.name, err)
+		}
+		if records, _ := store.List(); len(records) != 1 || records[0].IP != test.expected {
+			t.Errorf("%s: expected the IP address %s to be recorded, got %v", test.name, test.expected, records)
+		}
+	}
+}
@@ -3,56 +3,324 @@ package session
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/logger"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
+const pkg = "github.com/a-h/gauthmiddleware/session"
+
 // Session determines how a user is logged in to the system.
 type Session interface {
-	// ValidateSession validates a session and returns the email address of the
-	// user.
-	Validate(r *http.Request) (isValid bool, emailAddress string, err error)
-	Start(w http.ResponseWriter, r *http.Request, emailAddress string) error
+	// ValidateSession validates a session and returns the identity of the
+	// user. If the session has expired, ErrExpired is returned. The session may
+	// be renewed by writing to w.
+	Validate(w http.ResponseWriter, r *http.Request) (isValid bool, id identity.Identity, err error)
+	// Start starts a new session for the user. A new session ID is always issued, and any
+	// session presented with the request is discarded, so that an attacker can't plant a
+	// session on the user's browser before they log in.
+	Start(w http.ResponseWriter, r *http.Request, id identity.Identity) error
+	// End ends the session, logging the user out.
+	End(w http.ResponseWriter, r *http.Request) error
 }
 
-// A GorillaSession uses the Gorilla framework to manage the session.
+// A GorillaSession uses the Gorilla framework to manage the session. Cookies are
+// authenticated and encrypted using separate keys derived from the encryption key.
 type GorillaSession struct {
+	// store.Codecs contains a codec for each key, newest first. Cookies are always
+	// issued using the newest key.
 	store      sessions.CookieStore
 	CookieName string
+	// CookieOptions are the attributes of the session cookie.
+	CookieOptions CookieOptions
+	Lifetime      Lifetime
+	// AcceptSignedOnlyCookies allows the signed, but unencrypted, cookies issued by
+	// earlier versions to be used during migration. They're re-issued encrypted.
+	AcceptSignedOnlyCookies bool
+	// Revocations, if set, is checked on each request so that sessions can be revoked
+	// before they expire.
+	Revocations RevocationChecker
+	// Binding ties sessions to the network and browser of the client which started them.
+	Binding          Binding
+	signedOnlyCodecs []securecookie.Codec
+	now              func() time.Time
 }
 
 // NewGorillaSession creates a Session which uses Gorilla.
 func NewGorillaSession(encryptionKey []byte, setSecureFlag bool, cookieName string) *GorillaSession {
-	store := sessions.NewCookieStore(encryptionKey)
-	store.Options = &sessions.Options{
-		HttpOnly: true,
-		Secure:   setSecureFlag,
+	return NewGorillaSessionWithKeys([][]byte{encryptionKey}, setSecureFlag, cookieName)
+}
+
+// NewGorillaSessionWithKeys creates a Session which uses Gorilla, with a keyring
+// ordered newest first. Cookies are issued using the newest key, and cookies issued
+// using previous keys are re-issued using the newest key, so that keys can be rotated
+// without logging users out. It panics if the keyring is empty, or contains an empty key,
+// since no cookie could be read or issued securely.
+func NewGorillaSessionWithKeys(encryptionKeys [][]byte, setSecureFlag bool, cookieName string) *GorillaSession {
+	if len(encryptionKeys) == 0 {
+		panic("session: NewGorillaSessionWithKeys: at least one encryption key is required")
+	}
+	for i, key := range encryptionKeys {
+		if len(key) == 0 {
+			panic(fmt.Sprintf("session: NewGorillaSessionWithKeys: encryption key %d is empty", i+1))
+		}
+	}
+	cookieOptions := DefaultCookieOptions(setSecureFlag)
+	store := &sessions.CookieStore{
+		Options: cookieOptions.sessionsOptions(),
+	}
+	var signedOnlyCodecs []securecookie.Codec
+	for _, key := range encryptionKeys {
+		store.Codecs = append(store.Codecs, newCodec(key))
+		signedOnlyCodecs = append(signedOnlyCodecs, newSignedOnlyCodec(key))
 	}
 	return &GorillaSession{
-		store:      *store,
-		CookieName: cookieName,
+		store:            *store,
+		CookieName:       cookieName,
+		CookieOptions:    cookieOptions,
+		Lifetime:         DefaultLifetime,
+		Binding:          DefaultBinding,
+		signedOnlyCodecs: signedOnlyCodecs,
+		now:              time.Now,
 	}
 }
 
-// Start starts off a session by adding the emailAddress value to an
-// encrypted cookie.
-func (gs GorillaSession) Start(w http.ResponseWriter, r *http.Request, emailAdress string) error {
-	session, err := gs.store.Get(r, gs.CookieName)
+// get reads the session from the cookie. If the cookie is missing or can't be decoded,
+// a new session is returned. requiresRenewal is set if the cookie needs to be re-issued
+// using the newest key, or in the current format.
+func (gs GorillaSession) get(r *http.Request) (session *sessions.Session, requiresRenewal bool) {
+	session = gs.newSession()
+	value, err := readCookie(r, gs.CookieName)
+	if err == http.ErrNoCookie {
+		return
+	}
+	if err != nil {
+		logger.For(pkg, "get").WithError(err).Info("Unable to read session cookie")
+		return
+	}
+	codecs := [][]securecookie.Codec{gs.store.Codecs[:1], gs.store.Codecs[1:]}
+	if gs.AcceptSignedOnlyCookies {
+		codecs = append(codecs, gs.signedOnlyCodecs)
+	}
+	for i, cs := range codecs {
+		if len(cs) == 0 {
+			continue
+		}
+		values := make(map[interface{}]interface{})
+		if err = securecookie.DecodeMulti(gs.CookieName, value, &values, cs...); err == nil {
+			if i == 2 {
+				gs.migrateSignedOnly(values)
+			}
+			session.Values = values
+			session.IsNew = false
+			return session, i > 0
+		}
+	}
+	logger.For(pkg, "get").WithError(err).Info("Unable to decode session cookie")
+	return
+}
+
+// migrateSignedOnly upgrades the values of a signed-only cookie. Cookies issued before
+// sessions had lifetimes only contain the email address, so they're treated as if the user
+// logged in now, otherwise every migrated user would be logged out.
+func (gs GorillaSession) migrateSignedOnly(values map[interface{}]interface{}) {
+	if _, ok := values["loginTime"].(int64); ok {
+		return
+	}
+	if _, ok := values["emailAddress"].(string); !ok {
+		return
+	}
+	now := gs.now().Unix()
+	values["version"] = cookieVersion
+	values["loginTime"] = now
+	values["renewed"] = now
+}
+
+func (gs GorillaSession) newSession() *sessions.Session {
+	session := sessions.NewSession(&gs.store, gs.CookieName)
+	session.Options = gs.CookieOptions.sessionsOptions()
+	session.IsNew = true
+	return session
+}
+
+// cookieVersion is the version of the cookie format written by Start. Version 1
+// cookies only contain the emailAddress value, and have no version value. Version 2
+// cookies have no session ID or issue time.
+const cookieVersion = 3
+
+// Start starts off a session by adding the identity to an encrypted cookie. Each session
+// is given a unique ID, so that it can be revoked. Nothing is carried over from any
+// existing session cookie, which is replaced.
+func (gs GorillaSession) Start(w http.ResponseWriter, r *http.Request, id identity.Identity) error {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return fmt.Errorf("GorillaSession.Start: failed to create session ID: %v", err)
+	}
+	session := gs.newSession()
+	session.Values["version"] = cookieVersion
+	session.Values["id"] = sessionID
+	session.Values["issued"] = gs.now().Unix()
+	session.Values["emailAddress"] = id.Email
+	session.Values["name"] = id.Name
+	session.Values["picture"] = id.Picture
+	session.Values["hd"] = id.HostedDomain
+	session.Values["sub"] = id.Subject
+	session.Values["loginTime"] = id.LoginTime.Unix()
+	if id.TokenID != "" {
+		session.Values["tokenID"] = id.TokenID
+	}
+	if gs.Binding.Mode != BindingDisabled {
+		fp := gs.Binding.fingerprint(r)
+		session.Values["clientIP"] = fp.IP
+		session.Values["clientUA"] = fp.UserAgent
+	}
+	// Log the ID, so that the session can be found and revoked.
+	logger.For(pkg, "GorillaSession.Start").WithField("email", id.Email).WithField("sessionID", sessionID).Info("Session started")
+	return gs.renew(w, r, session, id.LoginTime)
+}
+
+// renew extends the idle timeout of the session.
+func (gs GorillaSession) renew(w http.ResponseWriter, r *http.Request, session *sessions.Session, loginTime time.Time) error {
+	now := gs.now()
+	session.Values["renewed"] = now.Unix()
+	return gs.save(w, r, session, gs.CookieOptions.maxAge(gs.Lifetime.cookieMaxAge(loginTime, now)))
+}
+
+// save encrypts the session using the newest key, and writes it to the cookie, split
+// across several cookies if it's too large for one.
+func (gs GorillaSession) save(w http.ResponseWriter, r *http.Request, session *sessions.Session, maxAge int) error {
+	value, err := securecookie.EncodeMulti(gs.CookieName, session.Values, gs.store.Codecs[0])
+	if isTooLarge(err) {
+		return ErrSessionTooLarge
+	}
 	if err != nil {
 		return err
 	}
-	session.Values["emailAddress"] = emailAdress
-	return session.Save(r, w)
+	return gs.CookieOptions.writeCookie(w, r, gs.CookieName, value, maxAge)
 }
 
 // Validate checks whether the session is valid. If it isn't, it will
 // redirect the user to the logon screen.
-func (gs GorillaSession) Validate(r *http.Request) (isValid bool, emailAddress string, err error) {
-	session, err := gs.store.Get(r, gs.CookieName)
+func (gs GorillaSession) Validate(w http.ResponseWriter, r *http.Request) (isValid bool, id identity.Identity, err error) {
+	session, requiresRenewal := gs.get(r)
+	id, isValid = decodeIdentity(session.Values)
+	if !isValid {
+		return
+	}
+	var renewed time.Time
+	if ts, ok := session.Values["renewed"].(int64); ok {
+		renewed = time.Unix(ts, 0)
+	}
+	now := gs.now()
+	if gs.Lifetime.expired(id.LoginTime, renewed, now) {
+		return false, id, ErrExpired
+	}
+	if gs.Revocations != nil {
+		if err = gs.checkRevocation(session.Values, id); err != nil {
+			return false, id, err
+		}
+	}
+	if gs.Binding.Mode != BindingDisabled {
+		var bound bool
+		if bound, err = gs.checkBinding(r, session.Values, id); err != nil {
+			return false, id, err
+		}
+		// Sessions started before binding was enabled are bound to the current client, unless
+		// bound sessions are required.
+		requiresRenewal = requiresRenewal || !bound
+	}
+	if requiresRenewal || gs.Lifetime.shouldRenew(renewed, now) {
+		if err = gs.renew(w, r, session, id.LoginTime); err != nil {
+			err = fmt.Errorf("GorillaSession.Validate: failed to renew the session: %v", err)
+			return false, id, err
+		}
+	}
+	return
+}
+
+// checkRevocation returns ErrRevoked if the session has been revoked. Sessions issued by
+// earlier versions have no ID, and are treated as if they were issued at login.
+func (gs GorillaSession) checkRevocation(values map[interface{}]interface{}, id identity.Identity) error {
+	sessionID, _ := values["id"].(string)
+	issued := id.LoginTime
+	if ts, ok := values["issued"].(int64); ok {
+		issued = time.Unix(ts, 0)
+	}
+	revoked, err := gs.Revocations.IsRevoked(sessionID, id, issued)
 	if err != nil {
-		err = fmt.Errorf("GorillaSession.Validate: failed to get the cookie from the store: %v", err)
+		return fmt.Errorf("GorillaSession.Validate: failed to check whether the session was revoked: %v", err)
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// checkBinding compares the client to the one which started the session. It returns
+// ErrBindingChanged if the client has changed and the binding is enforced. If the session
+// wasn't bound to a client, it's bound to this one, unless the Binding requires sessions
+// to be bound, in which case it's treated as a change of client.
+func (gs GorillaSession) checkBinding(r *http.Request, values map[interface{}]interface{}, id identity.Identity) (bound bool, err error) {
+	current := gs.Binding.fingerprint(r)
+	var recorded clientFingerprint
+	recorded.IP, bound = values["clientIP"].(string)
+	recorded.UserAgent, _ = values["clientUA"].(string)
+	var changes []string
+	if !bound {
+		values["clientIP"] = current.IP
+		values["clientUA"] = current.UserAgent
+		if !gs.Binding.RequireBound {
+			return false, nil
+		}
+		changes = []string{"session wasn't bound to a client"}
+	} else {
+		changes = gs.Binding.changes(recorded, current)
+	}
+	if len(changes) == 0 {
+		return true, nil
+	}
+	log := logger.For(pkg, "GorillaSession.Validate").
+		WithField("email", id.Email).
+		WithField("changes", strings.Join(changes, ", ")).
+		WithField("event", "session_binding_changed")
+	if gs.Binding.Mode == BindingEnforced {
+		log.Warn("Session used by a different client, re-authentication required")
+		return bound, ErrBindingChanged
+	}
+	log.Warn("Session used by a different client")
+	return bound, nil
+}
+
+// decodeIdentity reads the identity from the values of any version of the cookie.
+func decodeIdentity(values map[interface{}]interface{}) (id identity.Identity, ok bool) {
+	id.Email, ok = values["emailAddress"].(string)
+	if !ok {
 		return
 	}
-	emailAddress, isValid = session.Values["emailAddress"].(string)
+	version, _ := values["version"].(int)
+	switch version {
+	case 0:
+		// Version 1 cookies only contain the email address.
+	case 2, 3:
+		id.Name, _ = values["name"].(string)
+		id.Picture, _ = values["picture"].(string)
+		id.HostedDomain, _ = values["hd"].(string)
+		id.Subject, _ = values["sub"].(string)
+		if loginTime, hasLoginTime := values["loginTime"].(int64); hasLoginTime {
+			id.LoginTime = time.Unix(loginTime, 0)
+		}
+		id.TokenID, _ = values["tokenID"].(string)
+	default:
+		return identity.Identity{}, false
+	}
 	return
 }
+
+// End ends the session by expiring the cookies.
+func (gs GorillaSession) End(w http.ResponseWriter, r *http.Request) error {
+	return gs.CookieOptions.writeCookie(w, r, gs.CookieName, "", -1)
+}
@@ -1,18 +1,27 @@
 package session
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/gorilla/sessions"
 )
 
 func TestSession(t *testing.T) {
+	loginTime := time.Unix(time.Now().Unix(), 0)
 	tests := []struct {
-		name          string
-		request       func() (*http.Request, error)
-		expectedValid bool
-		expectedEmail string
+		name             string
+		request          func() (*http.Request, error)
+		expectedValid    bool
+		expectedIdentity identity.Identity
+		expectedErr      error
 	}{
 		{
 			name: "no session cookie",
@@ -30,11 +39,83 @@ func TestSession(t *testing.T) {
 				}
 				w := httptest.NewRecorder()
 				s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
-				err = s.Start(w, r, "test@example.com")
+				err = s.Start(w, r, identity.Identity{
+					Email:        "test@example.com",
+					Name:         "Test User",
+					Picture:      "https://example.com/photo.jpg",
+					HostedDomain: "example.com",
+					Subject:      "110169484474386276334",
+					LoginTime:    loginTime,
+					TokenID:      "the_token_id",
+				})
+				if err != nil {
+					return nil, err
+				}
+				r, err = http.NewRequest("GET", "http://example.com", nil)
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
 				return r, err
 			},
 			expectedValid: true,
-			expectedEmail: "test@example.com",
+			expectedIdentity: identity.Identity{
+				Email:        "test@example.com",
+				Name:         "Test User",
+				Picture:      "https://example.com/photo.jpg",
+				HostedDomain: "example.com",
+				Subject:      "110169484474386276334",
+				LoginTime:    loginTime,
+				TokenID:      "the_token_id",
+			},
+		},
+		{
+			name: "session cookie from another key",
+			request: func() (*http.Request, error) {
+				r, err := http.NewRequest("GET", "http://example.com", nil)
+				if err != nil {
+					return nil, fmt.Errorf("error setting up request: %v", err)
+				}
+				w := httptest.NewRecorder()
+				s := NewGorillaSession([]byte("other_random_data"), false, "cookie-name")
+				err = s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: loginTime})
+				if err != nil {
+					return nil, err
+				}
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
+				return r, err
+			},
+			expectedValid: false,
+		},
+		{
+			name: "version 1 session cookies have no login time, so have expired",
+			request: func() (*http.Request, error) {
+				r, err := http.NewRequest("GET", "http://example.com", nil)
+				if err != nil {
+					return nil, fmt.Errorf("error setting up request: %v", err)
+				}
+				s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+				w := httptest.NewRecorder()
+				session, err := s.store.New(r, s.CookieName)
+				if err != nil {
+					return nil, err
+				}
+				session.Values["emailAddress"] = "test@example.com"
+				if err = session.Save(r, w); err != nil {
+					return nil, err
+				}
+				r, err = http.NewRequest("GET", "http://example.com", nil)
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
+				return r, err
+			},
+			expectedValid: false,
+			expectedIdentity: identity.Identity{
+				Email: "test@example.com",
+			},
+			expectedErr: ErrExpired,
 		},
 	}
 
@@ -46,15 +127,522 @@ func TestSession(t *testing.T) {
 			t.Fatalf("%s: error creating test request: %v", test.name, err)
 		}
 
-		actualValid, actualEmail, err := s.Validate(r)
-		if err != nil {
-			t.Fatalf("%s: unexpected error validating the session: %v", test.name, err)
+		actualValid, actualIdentity, err := s.Validate(httptest.NewRecorder(), r)
+		if test.expectedErr != err {
+			t.Fatalf("%s: expected error %v validating the session, got %v", test.name, test.expectedErr, err)
 		}
 		if test.expectedValid != actualValid {
 			t.Errorf("%s: expected valid %v, got %v", test.name, test.expectedValid, actualValid)
 		}
-		if test.expectedEmail != actualEmail {
-			t.Errorf("%s: expected email %v, got %v", test.name, test.expectedEmail, actualEmail)
+		if !reflect.DeepEqual(test.expectedIdentity, actualIdentity) {
+			t.Errorf("%s: expected identity %v, got %v", test.name, test.expectedIdentity, actualIdentity)
+		}
+	}
+}
+
+func TestThatEndingASessionExpiresTheCookie(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com"}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	if err := s.End(w, r); err != nil {
+		t.Fatalf("unexpected error ending the session: %v", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a single cookie, got %d", len(cookies))
+	}
+	if cookies[0].Name != "cookie-name" || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be expired, got %v", cookies[0])
+	}
+}
+
+func TestThatSessionsExpireAccordingToTheirLifetime(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	s.Lifetime = Lifetime{
+		Absolute:   48 * time.Hour,
+		Idle:       time.Hour,
+		RenewAfter: 15 * time.Minute,
+	}
+	s.now = func() time.Time { return now }
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		LoginTime: now,
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge != 3600 {
+		t.Fatalf("expected a cookie with a MaxAge of the idle timeout, got %v", cookies)
+	}
+
+	validate := func(after time.Duration) (isValid bool, renewed bool, err error) {
+		now = now.Add(after)
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		isValid, _, err = s.Validate(w, r)
+		if renewedCookies := w.Result().Cookies(); len(renewedCookies) > 0 {
+			cookies = renewedCookies
+			renewed = true
+		}
+		return
+	}
+
+	if isValid, renewed, err := validate(10 * time.Minute); !isValid || renewed || err != nil {
+		t.Errorf("expected a valid session which is not renewed, got valid %v, renewed %v, error %v", isValid, renewed, err)
+	}
+	if isValid, renewed, err := validate(10 * time.Minute); !isValid || !renewed || err != nil {
+		t.Errorf("expected a valid session which is renewed, got valid %v, renewed %v, error %v", isValid, renewed, err)
+	}
+	// Keep using the session until the absolute lifetime is reached.
+	for i := 0; i < 95; i++ {
+		if isValid, _, err := validate(30 * time.Minute); !isValid || err != nil {
+			t.Fatalf("%d: expected a valid session, got valid %v, error %v", i, isValid, err)
+		}
+	}
+	if isValid, _, err := validate(30 * time.Minute); isValid || err != ErrExpired {
+		t.Errorf("expected the session to have reached its absolute lifetime, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatIdleSessionsExpire(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	s.now = func() time.Time { return now }
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		LoginTime: now,
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+
+	now = now.Add(DefaultLifetime.Idle + time.Second)
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); isValid || err != ErrExpired {
+		t.Errorf("expected the session to have expired, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatSessionCookiesAreEncrypted(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+
+	w := httptest.NewRecorder()
+	if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		LoginTime: time.Now(),
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a single cookie, got %d", len(cookies))
+	}
+	value, err := base64.URLEncoding.DecodeString(cookies[0].Value)
+	if err != nil {
+		t.Fatalf("unexpected error decoding the cookie: %v", err)
+	}
+	if strings.Contains(string(value), "test@example.com") {
+		t.Errorf("expected the email address to be encrypted, but found it in the cookie: %s", string(value))
+	}
+}
+
+func TestThatSignedOnlyCookiesAreAcceptedDuringMigration(t *testing.T) {
+	key := []byte("random_data")
+
+	// Issue a cookie in the format used before cookies were encrypted, which only contained
+	// the email address.
+	legacy := sessions.NewCookieStore(key)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	session, err := legacy.New(r, "cookie-name")
+	if err != nil {
+		t.Fatalf("unexpected error creating the legacy session: %v", err)
+	}
+	session.Values["emailAddress"] = "test@example.com"
+	if err = session.Save(r, w); err != nil {
+		t.Fatalf("unexpected error saving the legacy session: %v", err)
+	}
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	s := NewGorillaSession(key, false, "cookie-name")
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); isValid || err != nil {
+		t.Errorf("expected signed-only cookies to be rejected by default, got valid %v, error %v", isValid, err)
+	}
+
+	s.AcceptSignedOnlyCookies = true
+	w = httptest.NewRecorder()
+	isValid, id, err := s.Validate(w, r)
+	if !isValid || err != nil {
+		t.Fatalf("expected signed-only cookies to be accepted during migration, got valid %v, error %v", isValid, err)
+	}
+	if id.Email != "test@example.com" {
+		t.Errorf("expected email test@example.com, got %v", id.Email)
+	}
+	if id.LoginTime.IsZero() {
+		t.Errorf("expected the login time of the migrated session to be set")
+	}
+
+	// The cookie is re-issued encrypted.
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	s.AcceptSignedOnlyCookies = false
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); !isValid || err != nil {
+		t.Errorf("expected the re-issued cookie to be valid, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatSessionsRequireAKey(t *testing.T) {
+	tests := []struct {
+		name string
+		keys [][]byte
+	}{
+		{
+			name: "no keyring",
+		},
+		{
+			name: "empty keyring",
+			keys: [][]byte{},
+		},
+		{
+			name: "empty key",
+			keys: [][]byte{[]byte("random_data"), nil},
+		},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", test.name)
+				}
+			}()
+			NewGorillaSessionWithKeys(test.keys, false, "cookie-name")
+		}()
+	}
+}
+
+func TestThatSessionsSurviveKeyRotation(t *testing.T) {
+	oldKey, newKey := []byte("old_random_data"), []byte("new_random_data")
+
+	w := httptest.NewRecorder()
+	if err := NewGorillaSession(oldKey, false, "cookie-name").Start(w, httptest.NewRequest("GET", "http://example.com", nil), identity.Identity{
+		Email:     "test@example.com",
+		LoginTime: time.Now(),
+	}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	// Rotate the key.
+	s := NewGorillaSessionWithKeys([][]byte{newKey, oldKey}, false, "cookie-name")
+	w = httptest.NewRecorder()
+	isValid, id, err := s.Validate(w, r)
+	if !isValid || err != nil {
+		t.Fatalf("expected a cookie issued with a previous key to be valid, got valid %v, error %v", isValid, err)
+	}
+	if id.Email != "test@example.com" {
+		t.Errorf("expected email test@example.com, got %v", id.Email)
+	}
+
+	// The cookie is re-issued with the new key, so the old key can be removed.
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	s = NewGorillaSession(newKey, false, "cookie-name")
+	if isValid, _, err := s.Validate(httptest.NewRecorder(), r); !isValid || err != nil {
+		t.Errorf("expected the re-issued cookie to be valid with the new key, got valid %v, error %v", isValid, err)
+	}
+}
+
+func TestThatRevokedSessionsAreRejected(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	start := func(s *GorillaSession, id identity.Identity) (r *http.Request, sessionID string) {
+		w := httptest.NewRecorder()
+		if err := s.Start(w, httptest.NewRequest("GET", "http://example.com", nil), id); err != nil {
+			t.Fatalf("unexpected error starting the session: %v", err)
+		}
+		r = httptest.NewRequest("GET", "http://example.com", nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
 		}
+		session, _ := s.get(r)
+		sessionID, _ = session.Values["id"].(string)
+		return
+	}
+	alice := identity.Identity{Email: "alice@example.com", Subject: "1", LoginTime: now}
+	bob := identity.Identity{Email: "bob@example.com", Subject: "2", LoginTime: now}
+
+	tests := []struct {
+		name            string
+		revoke          func(rl *RevocationList, aliceSessionID string)
+		expectedAlice   error
+		expectedBob     error
+		expectedRenewed error
+	}{
+		{
+			name:   "nothing revoked",
+			revoke: func(rl *RevocationList, aliceSessionID string) {},
+		},
+		{
+			name:          "revoked by session ID",
+			revoke:        func(rl *RevocationList, aliceSessionID string) { rl.RevokeSession(aliceSessionID) },
+			expectedAlice: ErrRevoked,
+		},
+		{
+			name: "revoked by email address",
+			revoke: func(rl *RevocationList, aliceSessionID string) {
+				rl.RevokeUser("alice@example.com", now.Add(time.Minute))
+			},
+			expectedAlice: ErrRevoked,
+		},
+		{
+			name:          "revoked by subject",
+			revoke:        func(rl *RevocationList, aliceSessionID string) { rl.RevokeUser("1", now.Add(time.Minute)) },
+			expectedAlice: ErrRevoked,
+		},
+		{
+			name: "user sessions issued after the revocation are not affected",
+			revoke: func(rl *RevocationList, aliceSessionID string) {
+				rl.RevokeUser("alice@example.com", now.Add(-time.Minute))
+			},
+		},
+		{
+			name:          "all revoked",
+			revoke:        func(rl *RevocationList, aliceSessionID string) { rl.RevokeAll(now.Add(time.Minute)) },
+			expectedAlice: ErrRevoked,
+			expectedBob:   ErrRevoked,
+		},
+	}
+
+	for _, test := range tests {
+		rl := NewRevocationList()
+		s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+		s.Revocations = rl
+		s.now = func() time.Time { return now }
+		aliceRequest, aliceSessionID := start(s, alice)
+		bobRequest, bobSessionID := start(s, bob)
+		if aliceSessionID == "" || aliceSessionID == bobSessionID {
+			t.Fatalf("%s: expected sessions to have unique IDs, got %q and %q", test.name, aliceSessionID, bobSessionID)
+		}
+		test.revoke(rl, aliceSessionID)
+
+		for _, check := range []struct {
+			r        *http.Request
+			expected error
+		}{{aliceRequest, test.expectedAlice}, {bobRequest, test.expectedBob}} {
+			isValid, _, err := s.Validate(httptest.NewRecorder(), check.r)
+			if err != check.expected {
+				t.Errorf("%s: expected error %v, got %v", test.name, check.expected, err)
+			}
+			if isValid != (check.expected == nil) {
+				t.Errorf("%s: expected valid %v, got %v", test.name, check.expected == nil, isValid)
+			}
+		}
+
+		// Logging in again issues a new session, which isn't revoked.
+		now = now.Add(2 * time.Minute)
+		r, _ := start(s, alice)
+		if isValid, _, err := s.Validate(httptest.NewRecorder(), r); !isValid || err != nil {
+			t.Errorf("%s: expected a new session to be valid, got valid %v, error %v", test.name, isValid, err)
+		}
+		now = now.Add(-2 * time.Minute)
+	}
+}
+
+func TestThatSessionsCanBeBoundToTheClient(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Safari/537.36"
+	const chromeUpgraded = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/81.0.4044.92 Safari/537.36"
+	const firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:74.0) Gecko/20100101 Firefox/74.0"
+	tests := []struct {
+		name        string
+		mode        BindingMode
+		remoteAddr  string
+		userAgent   string
+		expectedErr error
+	}{
+		{
+			name:       "the same client",
+			mode:       BindingEnforced,
+			remoteAddr: "192.0.2.1:1234",
+			userAgent:  chrome,
+		},
+		{
+			name:       "a client on the same network with an upgraded browser",
+			mode:       BindingEnforced,
+			remoteAddr: "192.0.2.200:1234",
+			userAgent:  chromeUpgraded,
+		},
+		{
+			name:        "a client on another network",
+			mode:        BindingEnforced,
+			remoteAddr:  "198.51.100.1:1234",
+			userAgent:   chrome,
+			expectedErr: ErrBindingChanged,
+		},
+		{
+			name:        "a client using another browser",
+			mode:        BindingEnforced,
+			remoteAddr:  "192.0.2.1:1234",
+			userAgent:   firefox,
+			expectedErr: ErrBindingChanged,
+		},
+		{
+			name:       "changes are only logged when the binding isn't enforced",
+			mode:       BindingLogOnly,
+			remoteAddr: "198.51.100.1:1234",
+			userAgent:  firefox,
+		},
+		{
+			name:       "changes are ignored when binding is disabled",
+			mode:       BindingDisabled,
+			remoteAddr: "198.51.100.1:1234",
+			userAgent:  firefox,
+		},
+	}
+
+	for _, test := range tests {
+		s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+		s.Binding.Mode = test.mode
+
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", chrome)
+		w := httptest.NewRecorder()
+		if err := s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+			t.Fatalf("%s: unexpected error starting the session: %v", test.name, err)
+		}
+
+		r = httptest.NewRequest("GET", "http://example.com", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("User-Agent", test.userAgent)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		isValid, _, err := s.Validate(httptest.NewRecorder(), r)
+		if err != test.expectedErr {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+		if isValid != (test.expectedErr == nil) {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.expectedErr == nil, isValid)
+		}
+	}
+}
+
+func TestThatSessionsStartedBeforeBindingWasEnabledAreBound(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+
+	s.Binding.Mode = BindingEnforced
+	validate := func(remoteAddr string) (renewed []*http.Cookie, err error) {
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		r.RemoteAddr = remoteAddr
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		_, _, err = s.Validate(w, r)
+		return w.Result().Cookies(), err
+	}
+	renewed, err := validate("192.0.2.1:1234")
+	if err != nil || len(renewed) != 1 {
+		t.Fatalf("expected the session to be bound and re-issued, got cookies %v, error %v", renewed, err)
+	}
+	cookies = renewed
+	if _, err = validate("198.51.100.1:1234"); err != ErrBindingChanged {
+		t.Errorf("expected the session to be bound to the first client, got error %v", err)
+	}
+}
+
+func TestThatSessionsStartedBeforeBindingWasEnabledCanBeRequiredToLogInAgain(t *testing.T) {
+	s := NewGorillaSession([]byte("random_data"), false, "cookie-name")
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	w := httptest.NewRecorder()
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+
+	s.Binding.Mode = BindingEnforced
+	s.Binding.RequireBound = true
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if _, _, err := s.Validate(httptest.NewRecorder(), r); err != ErrBindingChanged {
+		t.Errorf("expected ErrBindingChanged, got %v", err)
+	}
+}
+
+func TestThatStartingASessionDiscardsTheExistingSession(t *testing.T) {
+	key := []byte("random_data")
+	s := NewGorillaSession(key, false, "cookie-name")
+
+	// Plant a session containing a value which Start doesn't set.
+	planted := s.newSession()
+	planted.Values["version"] = cookieVersion
+	planted.Values["id"] = "planted"
+	planted.Values["emailAddress"] = "attacker@example.com"
+	planted.Values["planted"] = true
+	w := httptest.NewRecorder()
+	if err := s.save(w, httptest.NewRequest("GET", "http://example.com", nil), planted, 0); err != nil {
+		t.Fatalf("unexpected error saving the planted session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	w = httptest.NewRecorder()
+	if err := s.Start(w, r, identity.Identity{Email: "test@example.com", LoginTime: time.Now()}); err != nil {
+		t.Fatalf("unexpected error starting the session: %v", err)
+	}
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	session, _ := s.get(r)
+	if session.Values["id"] == "planted" {
+		t.Errorf("expected a new session ID to be issued")
+	}
+	if _, ok := session.Values["planted"]; ok {
+		t.Errorf("expected no values to be carried over from the existing session, got %v", session.Values)
+	}
+	if session.Values["emailAddress"] != "test@example.com" {
+		t.Errorf("expected the session to belong to test@example.com, got %v", session.Values["emailAddress"])
 	}
 }
@@ -0,0 +1,222 @@
+// Package sessiontest contains conformance tests for implementations of session.SessionStore.
+package sessiontest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+	"github.com/a-h/gauthmiddleware/session"
+)
+
+// Run runs the conformance tests against stores created by newStore. Each test
+// calls newStore to get an empty store, e.g.
+//
+//	func TestMyStore(t *testing.T) {
+//		sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+//			return NewMyStore()
+//		})
+//	}
+func Run(t *testing.T, newStore func(t *testing.T) session.SessionStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store session.SessionStore)
+	}{
+		{"missing sessions are not found", testMissing},
+		{"sessions can be stored and retrieved", testPutGet},
+		{"sessions can be replaced", testReplace},
+		{"sessions can be deleted", testDelete},
+		{"sessions can be renewed", testTouch},
+		{"deleted sessions are not renewed", testTouchDeleted},
+		{"expired sessions are not returned", testExpired},
+		{"sessions can be listed", testList},
+		{"sessions can be listed by user", testListByUser},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+// NewRecord creates a Record for the user which expires in an hour.
+func NewRecord(id string, email string) session.Record {
+	// Stores may not retain more than millisecond precision.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return session.Record{
+		ID: id,
+		Identity: identity.Identity{
+			Email:        email,
+			Name:         "Test User",
+			Picture:      "https://example.com/photo.jpg",
+			HostedDomain: "example.com",
+			Subject:      "sub-" + email,
+			LoginTime:    now,
+		},
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(time.Hour),
+		IP:        "192.0.2.1",
+		UserAgent: "Mozilla/5.0",
+	}
+}
+
+func mustPut(t *testing.T, store session.SessionStore, records ...session.Record) {
+	for _, r := range records {
+		if err := store.Put(r); err != nil {
+			t.Fatalf("failed to put session %q: %v", r.ID, err)
+		}
+	}
+}
+
+func assertGet(t *testing.T, store session.SessionStore, id string, expected *session.Record) {
+	actual, ok, err := store.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get session %q: %v", id, err)
+	}
+	if expected == nil {
+		if ok {
+			t.Errorf("expected session %q not to be found, but got %v", id, actual)
+		}
+		return
+	}
+	if !ok {
+		t.Fatalf("expected session %q to be found", id)
+	}
+	if !equal(*expected, actual) {
+		t.Errorf("expected session %v, got %v", *expected, actual)
+	}
+}
+
+// equal compares records, ignoring differences in time zone.
+func equal(a, b session.Record) bool {
+	for _, r := range []*session.Record{&a, &b} {
+		r.Identity.LoginTime = r.Identity.LoginTime.UTC()
+		r.Created = r.Created.UTC()
+		r.LastSeen = r.LastSeen.UTC()
+		r.Expires = r.Expires.UTC()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func assertIDs(t *testing.T, records []session.Record, err error, expected ...string) {
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	actual := []string{}
+	for _, r := range records {
+		actual = append(actual, r.ID)
+	}
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if len(expected) == 0 {
+		expected = []string{}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected sessions %v, got %v", expected, actual)
+	}
+}
+
+func testMissing(t *testing.T, store session.SessionStore) {
+	assertGet(t, store, "missing", nil)
+	if err := store.Delete("missing"); err != nil {
+		t.Errorf("expected deleting a missing session to succeed, got %v", err)
+	}
+}
+
+func testPutGet(t *testing.T, store session.SessionStore) {
+	r := NewRecord("session1", "a@example.com")
+	mustPut(t, store, r)
+	assertGet(t, store, r.ID, &r)
+}
+
+func testReplace(t *testing.T, store session.SessionStore) {
+	r := NewRecord("session1", "a@example.com")
+	mustPut(t, store, r)
+	r.LastSeen = r.LastSeen.Add(time.Minute)
+	r.Expires = r.Expires.Add(time.Minute)
+	mustPut(t, store, r)
+	assertGet(t, store, r.ID, &r)
+}
+
+func testDelete(t *testing.T, store session.SessionStore) {
+	r1, r2 := NewRecord("session1", "a@example.com"), NewRecord("session2", "a@example.com")
+	mustPut(t, store, r1, r2)
+	if err := store.Delete(r1.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	assertGet(t, store, r1.ID, nil)
+	assertGet(t, store, r2.ID, &r2)
+}
+
+func testTouch(t *testing.T, store session.SessionStore) {
+	r := NewRecord("session1", "a@example.com")
+	mustPut(t, store, r)
+	r.LastSeen = r.LastSeen.Add(time.Minute)
+	r.Expires = r.Expires.Add(time.Minute)
+	ok, err := store.Touch(r.ID, r.LastSeen, r.Expires)
+	if err != nil || !ok {
+		t.Fatalf("expected the session to be renewed, got ok=%v, err=%v", ok, err)
+	}
+	assertGet(t, store, r.ID, &r)
+}
+
+func testTouchDeleted(t *testing.T, store session.SessionStore) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if ok, err := store.Touch("missing", now, now.Add(time.Hour)); err != nil || ok {
+		t.Errorf("expected a missing session not to be renewed, got ok=%v, err=%v", ok, err)
+	}
+	assertGet(t, store, "missing", nil)
+
+	r := NewRecord("session1", "a@example.com")
+	mustPut(t, store, r)
+	if err := store.Delete(r.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if ok, err := store.Touch(r.ID, now, now.Add(time.Hour)); err != nil || ok {
+		t.Errorf("expected a deleted session not to be renewed, got ok=%v, err=%v", ok, err)
+	}
+	assertGet(t, store, r.ID, nil)
+}
+
+func testExpired(t *testing.T, store session.SessionStore) {
+	r := NewRecord("session1", "a@example.com")
+	r.Expires = time.Now().Add(-time.Minute)
+	mustPut(t, store, r)
+	assertGet(t, store, r.ID, nil)
+	records, err := store.List()
+	assertIDs(t, records, err)
+	records, err = store.ListByUser(r.Identity.UserID())
+	assertIDs(t, records, err)
+}
+
+func testList(t *testing.T, store session.SessionStore) {
+	records, err := store.List()
+	assertIDs(t, records, err)
+	mustPut(t, store,
+		NewRecord("session1", "a@example.com"),
+		NewRecord("session2", "b@example.com"),
+		NewRecord("session3", "a@example.com"))
+	records, err = store.List()
+	assertIDs(t, records, err, "session1", "session2", "session3")
+}
+
+func testListByUser(t *testing.T, store session.SessionStore) {
+	a := NewRecord("session1", "a@example.com")
+	mustPut(t, store,
+		a,
+		NewRecord("session2", "b@example.com"),
+		NewRecord("session3", "a@example.com"))
+	records, err := store.ListByUser(a.Identity.UserID())
+	assertIDs(t, records, err, "session1", "session3")
+	for _, r := range records {
+		if r.ID == a.ID && !equal(a, r) {
+			t.Errorf("expected session %v, got %v", a, r)
+		}
+	}
+	records, err = store.ListByUser("unknown")
+	assertIDs(t, records, err)
+}
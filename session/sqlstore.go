@@ -0,0 +1,298 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+// DefaultCleanupInterval is how often the SQLStore deletes expired sessions.
+const DefaultCleanupInterval = 10 * time.Minute
+
+// migrations create and update the schema. Each migration is applied once, in order,
+// and recorded in the gauth_schema_version table. Never change a migration once it's
+// been released, add a new one instead.
+var migrations = []string{
+	`CREATE TABLE gauth_sessions (
+		id VARCHAR(64) NOT NULL PRIMARY KEY,
+		user_id VARCHAR(255) NOT NULL,
+		expires BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX gauth_sessions_user_id ON gauth_sessions (user_id)`,
+	`CREATE INDEX gauth_sessions_expires ON gauth_sessions (expires)`,
+}
+
+// An SQLStore holds sessions in a database using database/sql, so that sessions can be
+// shared between multiple instances of an application. The schema is created when the
+// store is first used. Expired sessions are deleted in the background until Close is
+// called.
+type SQLStore struct {
+	db *sql.DB
+	// dollarPlaceholders is set for databases which use $1 rather than ? as placeholders.
+	dollarPlaceholders bool
+	// upsert is the statement which creates or replaces a session in a single step, or
+	// empty if the database's syntax isn't known.
+	upsert string
+	now    func() time.Time
+
+	migrateMutex sync.Mutex
+	migrated     bool
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewSQLStore creates a SQLStore which uses the database. The driverName, e.g. "postgres",
+// "mysql" or "sqlite", selects the placeholder and upsert syntax used in queries.
+func NewSQLStore(db *sql.DB, driverName string) *SQLStore {
+	s := &SQLStore{
+		db:                 db,
+		dollarPlaceholders: driverName == "postgres" || driverName == "pgx",
+		upsert:             upsertQuery(driverName),
+		now:                time.Now,
+		stop:               make(chan struct{}),
+	}
+	go s.cleanup(DefaultCleanupInterval)
+	return s
+}
+
+// Close stops deleting expired sessions in the background. It doesn't close the database.
+func (s *SQLStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func (s *SQLStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.DeleteExpired(); err != nil {
+				logger.For(pkg, "SQLStore.cleanup").WithError(err).Warn("Failed to delete expired sessions")
+			}
+		}
+	}
+}
+
+// rebind replaces ? placeholders with $1, $2 etc. if required by the database.
+func (s *SQLStore) rebind(query string) string {
+	if !s.dollarPlaceholders {
+		return query
+	}
+	var sb strings.Builder
+	var n int
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// upsertQuery returns the statement which creates or replaces a session using the syntax
+// of the database, or an empty string if it isn't known.
+func upsertQuery(driverName string) string {
+	const insert = `INSERT INTO gauth_sessions (id, user_id, expires, data) VALUES (?, ?, ?, ?)`
+	switch driverName {
+	case "postgres", "pgx", "sqlite", "sqlite3":
+		return insert + ` ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, expires = excluded.expires, data = excluded.data`
+	case "mysql":
+		return insert + ` ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), expires = VALUES(expires), data = VALUES(data)`
+	}
+	return ""
+}
+
+// Migrate creates or updates the schema. It's called automatically when the store is
+// first used, but can be called at startup to find configuration problems early.
+func (s *SQLStore) Migrate() error {
+	s.migrateMutex.Lock()
+	defer s.migrateMutex.Unlock()
+	if s.migrated {
+		return nil
+	}
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS gauth_schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("SQLStore: failed to create schema version table: %v", err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SQLStore: failed to start migration: %v", err)
+	}
+	defer tx.Rollback()
+	var version sql.NullInt64
+	if err = tx.QueryRow(`SELECT MAX(version) FROM gauth_schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("SQLStore: failed to get schema version: %v", err)
+	}
+	for i := int(version.Int64); i < len(migrations); i++ {
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("SQLStore: failed to apply migration %d: %v", i+1, err)
+		}
+		if _, err = tx.Exec(s.rebind(`INSERT INTO gauth_schema_version (version) VALUES (?)`), i+1); err != nil {
+			return fmt.Errorf("SQLStore: failed to record migration %d: %v", i+1, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("SQLStore: failed to commit migration: %v", err)
+	}
+	s.migrated = true
+	return nil
+}
+
+// expires converts a Record's expiry to milliseconds since the epoch, or zero for never.
+func expires(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Get returns the session with the ID.
+func (s *SQLStore) Get(id string) (r Record, ok bool, err error) {
+	if err = s.Migrate(); err != nil {
+		return
+	}
+	var data string
+	err = s.db.QueryRow(s.rebind(`SELECT data FROM gauth_sessions WHERE id = ? AND (expires = 0 OR expires > ?)`),
+		id, expires(s.now())).Scan(&data)
+	if err == sql.ErrNoRows {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, fmt.Errorf("SQLStore: failed to get session: %v", err)
+	}
+	if err = json.Unmarshal([]byte(data), &r); err != nil {
+		return r, false, fmt.Errorf("SQLStore: failed to decode session: %v", err)
+	}
+	return r, true, nil
+}
+
+// Put creates or replaces the session.
+func (s *SQLStore) Put(r Record) error {
+	if err := s.Migrate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("SQLStore: failed to encode session: %v", err)
+	}
+	if s.upsert != "" {
+		if _, err = s.db.Exec(s.rebind(s.upsert), r.ID, r.Identity.UserID(), expires(r.Expires), string(data)); err != nil {
+			return fmt.Errorf("SQLStore: failed to put session: %v", err)
+		}
+		return nil
+	}
+	// The database's upsert syntax isn't known, so the session is updated, and only inserted
+	// if it doesn't exist. If a concurrent Put inserts it first, the insert fails, and the
+	// session is updated instead.
+	updated, err := s.update(r.ID, r.Identity.UserID(), expires(r.Expires), string(data))
+	if err != nil || updated {
+		return err
+	}
+	_, err = s.db.Exec(s.rebind(`INSERT INTO gauth_sessions (id, user_id, expires, data) VALUES (?, ?, ?, ?)`),
+		r.ID, r.Identity.UserID(), expires(r.Expires), string(data))
+	if err == nil {
+		return nil
+	}
+	if updated, _ = s.update(r.ID, r.Identity.UserID(), expires(r.Expires), string(data)); !updated {
+		return fmt.Errorf("SQLStore: failed to insert session: %v", err)
+	}
+	return nil
+}
+
+// Touch renews the session, if it exists. The update only matches a session which still
+// exists, so one which is deleted while it's being renewed isn't brought back.
+func (s *SQLStore) Touch(id string, lastSeen, expiry time.Time) (ok bool, err error) {
+	r, ok, err := s.Get(id)
+	if !ok || err != nil {
+		return false, err
+	}
+	r.LastSeen, r.Expires = lastSeen, expiry
+	data, err := json.Marshal(r)
+	if err != nil {
+		return false, fmt.Errorf("SQLStore: failed to encode session: %v", err)
+	}
+	return s.update(r.ID, r.Identity.UserID(), expires(r.Expires), string(data))
+}
+
+// update replaces the session if it exists.
+func (s *SQLStore) update(id, userID string, expires int64, data string) (updated bool, err error) {
+	result, err := s.db.Exec(s.rebind(`UPDATE gauth_sessions SET user_id = ?, expires = ?, data = ? WHERE id = ?`),
+		userID, expires, data, id)
+	if err != nil {
+		return false, fmt.Errorf("SQLStore: failed to update session: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("SQLStore: failed to update session: %v", err)
+	}
+	return n > 0, nil
+}
+
+// Delete removes the session.
+func (s *SQLStore) Delete(id string) error {
+	if err := s.Migrate(); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(s.rebind(`DELETE FROM gauth_sessions WHERE id = ?`), id); err != nil {
+		return fmt.Errorf("SQLStore: failed to delete session: %v", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes all expired sessions.
+func (s *SQLStore) DeleteExpired() error {
+	if err := s.Migrate(); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(s.rebind(`DELETE FROM gauth_sessions WHERE expires <> 0 AND expires <= ?`), expires(s.now())); err != nil {
+		return fmt.Errorf("SQLStore: failed to delete expired sessions: %v", err)
+	}
+	return nil
+}
+
+// List returns all sessions which have not expired.
+func (s *SQLStore) List() ([]Record, error) {
+	return s.list(`SELECT data FROM gauth_sessions WHERE expires = 0 OR expires > ?`, expires(s.now()))
+}
+
+// ListByUser returns the sessions of a user which have not expired.
+func (s *SQLStore) ListByUser(userID string) ([]Record, error) {
+	return s.list(`SELECT data FROM gauth_sessions WHERE user_id = ? AND (expires = 0 OR expires > ?)`, userID, expires(s.now()))
+}
+
+func (s *SQLStore) list(query string, args ...interface{}) (records []Record, err error) {
+	if err = s.Migrate(); err != nil {
+		return
+	}
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("SQLStore: failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("SQLStore: failed to read session: %v", err)
+		}
+		var r Record
+		if err = json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, fmt.Errorf("SQLStore: failed to decode session: %v", err)
+		}
+		records = append(records, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("SQLStore: failed to list sessions: %v", err)
+	}
+	return
+}
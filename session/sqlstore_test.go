@@ -0,0 +1,121 @@
+package session_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/session"
+	"github.com/a-h/gauthmiddleware/session/sessiontest"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLStore(t *testing.T) {
+	sessiontest.Run(t, func(t *testing.T) session.SessionStore {
+		return newSQLStore(t, newDB(t))
+	})
+}
+
+func TestThatSQLStoreMigrationsCanBeRepeated(t *testing.T) {
+	db := newDB(t)
+	if err := newSQLStore(t, db).Migrate(); err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+	s := newSQLStore(t, db)
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("unexpected error migrating an existing database: %v", err)
+	}
+	var versions int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM gauth_schema_version`).Scan(&versions); err != nil {
+		t.Fatalf("unexpected error reading the schema version: %v", err)
+	}
+	if versions != 3 {
+		t.Errorf("expected each migration to be applied once, got %d versions", versions)
+	}
+}
+
+func TestThatSQLStoreDeletesExpiredSessions(t *testing.T) {
+	db := newDB(t)
+	s := newSQLStore(t, db)
+
+	expired := sessiontest.NewRecord("session1", "a@example.com")
+	expired.Expires = time.Now().Add(-time.Minute)
+	current := sessiontest.NewRecord("session2", "a@example.com")
+	neverExpires := sessiontest.NewRecord("session3", "a@example.com")
+	neverExpires.Expires = time.Time{}
+	for _, r := range []session.Record{expired, current, neverExpires} {
+		if err := s.Put(r); err != nil {
+			t.Fatalf("unexpected error storing session: %v", err)
+		}
+	}
+
+	if err := s.DeleteExpired(); err != nil {
+		t.Fatalf("unexpected error deleting expired sessions: %v", err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM gauth_sessions`).Scan(&count); err != nil {
+		t.Fatalf("unexpected error counting sessions: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 sessions to remain, got %d", count)
+	}
+}
+
+func TestThatSQLStoreSessionsCanBePutConcurrently(t *testing.T) {
+	for _, driverName := range []string{"sqlite", "unknown"} {
+		db := newDB(t)
+		s := session.NewSQLStore(db, driverName)
+		defer s.Close()
+		if err := s.Migrate(); err != nil {
+			t.Fatalf("%s: unexpected error migrating: %v", driverName, err)
+		}
+
+		// Renewing the same session from parallel requests must not fail.
+		errs := make(chan error, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- s.Put(sessiontest.NewRecord("session1", "a@example.com"))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("%s: unexpected error putting session: %v", driverName, err)
+			}
+		}
+		if records, err := s.List(); err != nil || len(records) != 1 {
+			t.Errorf("%s: expected a single session, got %v, %v", driverName, records, err)
+		}
+	}
+}
+
+func newDB(t *testing.T) *sql.DB {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	// Writers wait for each other, rather than failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "sessions.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+func newSQLStore(t *testing.T, db *sql.DB) *session.SQLStore {
+	s := session.NewSQLStore(db, "sqlite")
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"time"
+
+	"github.com/a-h/gauthmiddleware/identity"
+)
+
+// A Record is a session held in a SessionStore.
+type Record struct {
+	// ID is the random session ID held in the session cookie.
+	ID       string            `json:"id"`
+	Identity identity.Identity `json:"identity"`
+	// Created is when the session was started.
+	Created time.Time `json:"created"`
+	// LastSeen is when the session was last renewed.
+	LastSeen time.Time `json:"lastSeen"`
+	// Expires is when the session expires, and can be deleted. Zero means never.
+	Expires   time.Time `json:"expires"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// Expired returns true if the record has expired by now.
+func (r Record) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires)
+}
+
+// A SessionStore holds sessions on the server. Implementations can be checked
+// using the sessiontest package.
+type SessionStore interface {
+	// Get returns the session with the ID. ok is false if the session doesn't
+	// exist or has expired.
+	Get(id string) (r Record, ok bool, err error)
+	// Put creates or replaces the session.
+	Put(r Record) error
+	// Touch sets when the session was last seen and when it expires, if it exists and
+	// hasn't expired. ok is false if it doesn't, so that renewing a session can't bring
+	// it back after it has been deleted, e.g. because it was revoked.
+	Touch(id string, lastSeen, expires time.Time) (ok bool, err error)
+	// Delete removes the session. It's not an error if the session doesn't exist.
+	Delete(id string) error
+	// List returns all sessions which have not expired.
+	List() ([]Record, error)
+	// ListByUser returns the sessions of a user which have not expired, where
+	// userID is the identity.Identity.UserID of the user.
+	ListByUser(userID string) ([]Record, error)
+}
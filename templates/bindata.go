@@ -1,7 +1,10 @@
 // Code generated by go-bindata.
 // sources:
+// templates/admin.html
+// templates/error.html
 // templates/footer.html
 // templates/header.html
+// templates/loggedout.html
 // templates/login.html
 // DO NOT EDIT!
 
@@ -70,7 +73,47 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _templatesFooterHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xaa\xae\x4e\x49\x4d\xcb\xcc\x4b\x55\x50\x4a\xcb\xcf\x2f\x49\x2d\x52\xaa\xad\xe5\xb2\xd1\x4f\xca\x4f\xa9\xb4\xe3\xb2\xd1\xcf\x28\xc9\xcd\xb1\xe3\xaa\xae\x4e\xcd\x4b\xa9\xad\xe5\x02\x04\x00\x00\xff\xff\x5f\x49\xf7\x01\x2c\x00\x00\x00")
+var _templatesAdminHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xcc\x54\xdb\x8a\xdb\x30\x10\x7d\xcf\x57\x0c\xa2\xaf\xb1\xb2\xa1\xdb\x87\x45\x31\x2c\xbd\xd0\x85\x6d\x59\xba\xdb\x0f\x18\x47\x93\x58\x54\x96\x8c\x34\x0e\x0d\x46\xff\x5e\xe4\x24\x9b\xcb\x7a\xa1\x97\x97\x12\x08\x9e\x73\x3c\x67\x46\xc7\x9a\xe9\x7b\xa6\xa6\xb5\xc8\x04\xa2\x26\xd4\x14\x04\x14\x90\xd2\x04\x00\x40\x69\xb3\x81\xa5\xc5\x18\x17\x62\xe9\x1d\xa3\x71\x14\x44\x39\x70\x00\x7d\x6f\x56\x50\xdc\xfb\xb5\xef\xf8\x01\xb9\x4e\xe9\x54\x2c\x9a\xb5\xf3\x1d\x0b\x28\x32\x4e\x4e\xef\x35\x01\x54\x3d\x2f\x6f\x97\x6c\x36\x04\x91\x62\x34\xde\x45\x25\xeb\x79\x39\x39\xd3\x7d\xdc\x53\xc7\x34\xc6\xca\xd2\xa1\x9d\x5d\x30\xfc\x4f\x23\x07\xd3\x92\x7e\x6e\x0c\x40\x71\x3e\xcb\x31\xce\x48\x38\x0d\x33\x50\x97\xdf\x23\x05\x25\xb9\x7e\xc9\xdc\x3d\x00\x6a\x1d\x28\xc6\x71\x3e\x67\x02\xae\xc9\xf1\x38\xff\x3e\x10\x32\xe9\x71\xf2\x1e\x23\x43\x24\x72\xe3\xf4\x25\xaa\xe4\x69\xef\x4a\x5e\x9c\x4d\x71\xe5\xf5\xf6\x18\xe7\x0f\x13\xd0\xad\x69\xc4\xc3\x57\x9c\xd0\x65\xdf\x17\x5f\xb1\xa1\x94\x54\x15\x64\xa9\x62\x83\xd6\x66\xf0\x63\x83\xc6\xa6\xa4\xe4\x0e\x51\x92\xf5\x68\xf2\xdd\x43\x4a\xe3\xe4\x51\x2b\x7b\x76\x9b\x2d\xfb\x0d\xbd\xbd\x7f\xc5\x27\x1f\x1a\x64\x10\xf3\xd9\xec\xdd\x74\x76\x35\x9d\xcd\xe1\xea\xfa\x66\xf6\xf6\x66\x76\x0d\x5f\x1e\x9f\xc4\x6b\x55\xfb\xbe\xc8\x2e\x3f\x12\xb9\xbf\xd6\x38\x03\x00\xd4\xca\x87\x06\x1a\xe2\xda\xeb\x85\x68\x7d\x64\x01\xb8\x64\xe3\xdd\x42\xf4\xfd\x9b\x62\x37\x02\x32\xd0\xc6\xff\x20\x71\xb8\xa7\x39\x69\x6a\x9c\x35\x8e\x04\x44\xde\x5a\x5a\x08\x6d\x62\x6b\x71\x7b\x03\x7b\xfc\xbc\x74\xfe\x29\xe3\xda\x8e\x81\xb7\x2d\x2d\x44\x6d\xb4\x26\x27\xc0\x61\x93\x23\x74\xda\x92\x80\x0d\xda\x8e\x72\xe5\xe2\xf3\x80\xa4\x24\xe4\x88\x50\xd5\x31\x7b\xb7\x57\x8a\x5d\xd5\x18\x7e\xee\xad\x62\x07\x15\xbb\xa9\xa6\x15\x76\x96\x87\xe7\x9f\x51\x94\xdf\x86\x23\x28\xb9\xcb\xbd\x14\x55\x32\x9f\xe9\x3f\x75\xa7\x8b\x14\x4e\xbd\xc9\x77\xee\xee\xc3\xbf\x78\x93\x27\x29\x5c\x58\x03\x68\x2d\xac\x7c\x80\x5c\xee\x4f\x7c\xba\xbc\x68\xe7\xa3\x0d\x70\xbe\x28\x07\xfe\x74\xb8\x95\x1c\x16\xde\x21\xec\x7b\xb2\x91\x9e\x5f\x57\xed\xa1\x79\x4b\xa8\x45\xf9\x54\x53\x20\xc0\x40\xe0\x3c\xe0\xf9\xc2\x2d\x94\x6c\xcb\xc9\xcb\xa2\x4a\x6a\xb3\x29\x27\xa7\x8b\x7c\xe5\x3d\x53\x10\x29\x4d\x7e\x0d\x00\x55\xb8\xf7\x81\x2c\x06\x00\x00")
+
+func templatesAdminHtmlBytes() ([]byte, error) {
+	return bindataRead(
+		_templatesAdminHtml,
+		"templates/admin.html",
+	)
+}
+
+func templatesAdminHtml() (*asset, error) {
+	bytes, err := templatesAdminHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "templates/admin.html", size: 1580, mode: os.FileMode(420), modTime: time.Unix(1792309785, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _templatesErrorHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x4c\x8f\x41\x6a\x03\x31\x0c\x45\xf7\x73\x8a\x8f\xf7\x99\x81\xac\x1d\x9f\x20\xdd\x94\xe6\x00\x9a\x58\x63\x1b\x5c\x79\xb0\xd5\x40\x31\xbe\x7b\x49\xda\xd0\x6c\xb4\x91\xde\xe3\xa9\x77\xe5\xcf\x3d\x93\x32\x4c\x64\xf2\x5c\x0d\x66\x8c\x31\x01\x80\xf5\xe9\x86\x6b\xa6\xd6\x4e\xe6\x5a\x44\x29\x09\x57\xe3\x1e\x3b\xc0\xc6\xa3\xbb\x08\xad\x99\xa1\x05\x2d\x05\x41\x12\xbb\xc4\xa3\x9b\x9e\x17\x2f\x3c\x65\xae\x8a\xc7\x3c\x78\x92\x70\x17\xf5\x3e\xbf\x71\x6b\x14\x78\x0c\xbb\xf8\x74\xfb\x27\x77\x67\xe9\x89\xae\x2a\x58\x55\x0e\x9e\x37\xfa\xca\x6a\x10\x2b\x6f\x27\xd3\xfb\x7c\x2e\x21\xc9\xe5\xfd\x3c\x86\x71\x1f\xf5\x1b\x14\xe8\x9e\x40\xce\x2e\xfb\x6f\xe6\x9f\xf6\xf5\xcd\xad\x14\xe5\x6a\xc6\x98\x7e\x06\x00\x30\x47\xcc\x5e\xfd\x00\x00\x00")
+
+func templatesErrorHtmlBytes() ([]byte, error) {
+	return bindataRead(
+		_templatesErrorHtml,
+		"templates/error.html",
+	)
+}
+
+func templatesErrorHtml() (*asset, error) {
+	bytes, err := templatesErrorHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "templates/error.html", size: 253, mode: os.FileMode(420), modTime: time.Unix(1792307645, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _templatesFooterHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2c\x00\xd3\xff\x7b\x7b\x64\x65\x66\x69\x6e\x65\x20\x22\x66\x6f\x6f\x74\x65\x72\x22\x7d\x7d\x0a\x3c\x2f\x62\x6f\x64\x79\x3e\x0a\x3c\x2f\x68\x74\x6d\x6c\x3e\x0a\x7b\x7b\x65\x6e\x64\x7d\x7d\x0a\x03\x00\x5f\x49\xf7\x01\x2c\x00\x00\x00")
 
 func templatesFooterHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -85,12 +128,12 @@ func templatesFooterHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "templates/footer.html", size: 44, mode: os.FileMode(420), modTime: time.Unix(1528059324, 0)}
+	info := bindataFileInfo{name: "templates/footer.html", size: 44, mode: os.FileMode(420), modTime: time.Unix(1529680829, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
 
-var _templatesHeaderHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x92\x41\x8f\xd3\x3e\x10\xc5\xef\xf9\x14\xb3\x3e\xff\x6d\xeb\xdf\x15\x17\x94\x54\x82\x5d\x84\x38\x81\x04\x17\x4e\xc8\xb5\x27\xcd\x04\xc7\x0e\x9e\xe9\x96\x2a\xca\x77\x47\x49\xb7\xb4\x20\x24\x6e\x9c\x92\x19\x8d\x7f\xf3\xf4\xde\x4c\x53\xc0\x96\x12\x82\xea\xd0\x05\x2c\x6a\x9e\xab\xfa\xee\xf1\xfd\xc3\xa7\xcf\x1f\xde\x40\x27\x43\xdc\x56\xf5\xf2\x81\xe8\xd2\xbe\x51\x98\xd4\xb6\x02\xa8\x97\xe9\xe5\x07\xa0\x1e\x50\x1c\x24\x37\x60\xa3\x9e\x08\x8f\x63\x2e\xa2\xc0\xe7\x24\x98\xa4\x51\x47\x0a\xd2\x35\x01\x9f\xc8\xa3\x5e\x8b\xff\x80\x12\x09\xb9\xa8\xd9\xbb\x88\xcd\xff\x6a\x5b\x9d\x49\xec\x0b\x8d\x02\x5c\x7c\xa3\x3a\x91\x91\x5f\x5a\xeb\x7a\xf7\xdd\xec\x73\xde\x47\x74\x23\xb1\xf1\x79\x58\x7b\x36\xd2\x8e\x6d\xff\xed\x80\xe5\x64\x37\x66\x63\x36\xcf\x85\x19\x28\x99\x9e\xd5\xb6\xb6\x67\xde\x85\x7e\xa7\x35\xbc\xce\x59\x58\x8a\x1b\x41\xeb\x67\xf9\x91\xd2\x57\x28\x18\x1b\xc5\x72\x8a\xc8\x1d\xa2\x28\xe8\x0a\xb6\x57\x11\x3e\xa4\x9e\x8d\x8f\xf9\x10\xda\xe8\x0a\xfe\xa6\x42\x8e\x24\x82\x45\xef\x2e\x74\x7b\x6f\xee\xcd\x0b\xeb\x99\xed\xcf\xde\xaa\xcb\x33\xab\x7f\xbc\x57\x4b\x87\x03\xde\x6c\xbf\xba\xf1\x91\xf6\x09\x28\x5d\xbd\xb8\x89\xf2\x6c\xb9\x66\xda\x27\x4a\x9a\x7d\x1e\xf1\x26\xd5\xb1\xe4\x96\x22\x02\x0e\x8e\xa2\xfa\xdb\x6b\x1f\x09\x93\x7c\xa1\x70\x43\x98\x26\xf3\x76\x1d\x7a\x75\x90\xee\x61\x1d\x78\xf7\x38\xcf\x17\xd6\x1f\x4f\x61\xc9\xff\x4c\x5e\x8d\xe8\xd9\x8e\xd1\x49\x9b\xcb\xb0\x04\x0e\x8e\x4f\xc9\x43\xc0\x16\xcb\xaf\xe1\xd7\xf6\x72\xae\xf5\x2e\x87\xd3\xb6\x9a\x26\x4c\x61\x9e\xab\x1f\x01\x00\x00\xff\xff\xeb\xd7\x5f\x2c\xfe\x02\x00\x00")
+var _templatesHeaderHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x94\x4f\x6f\xd4\x30\x10\xc5\xef\xf9\x14\x53\x5f\xb8\x34\x89\x68\xc5\x05\x25\x91\xa0\x45\xa8\x52\x25\x2a\xc1\x85\x13\x72\x9c\x49\xe2\xc5\xb1\x83\x67\xd2\xb2\xb2\xfc\xdd\x91\xb3\x7f\xba\xa5\x50\x6e\x9c\x36\xf1\x7a\x7e\x7e\x79\xef\xc9\x21\x74\xd8\x6b\x8b\x20\x46\x94\x9d\x88\x31\xab\xce\xae\x3f\x5d\x7d\xf9\x7a\xf7\x01\x46\x9e\x4c\x93\x55\xe9\x07\x8c\xb4\x43\x2d\xd0\x8a\x26\x03\xa8\xd2\xde\xf4\x00\x50\x4d\xc8\x12\xac\x9c\xb0\x16\xf7\x1a\x1f\x66\xe7\x59\x80\x72\x96\xd1\x72\x2d\x1e\x74\xc7\x63\xdd\xe1\xbd\x56\x98\xaf\x2f\xe7\xa0\xad\x66\x2d\x4d\x4e\x4a\x1a\xac\x5f\x8b\x26\xdb\x91\x48\x79\x3d\x33\x90\x57\xb5\x18\x99\x67\x7a\x5b\x96\x72\x23\x7f\x16\x83\x73\x83\x41\x39\x6b\x2a\x94\x9b\xd6\xb5\xd2\xe8\x96\xca\xcd\x8f\x05\xfd\xb6\xbc\x28\x2e\x8a\x8b\xfd\x4b\x31\x69\x5b\x6c\x48\x34\x55\xb9\xe3\x1d\xe8\x67\x79\x0e\xef\x9d\x63\x62\x2f\x67\xc8\xf3\xbd\x7c\xa3\xed\x77\xf0\x68\x6a\x41\xbc\x35\x48\x23\x22\x0b\x18\x3d\xf6\x8f\x22\x54\x67\x37\x54\x28\xe3\x96\xae\x37\xd2\xe3\x6f\x2a\xf8\x41\x33\xa3\xcf\xdb\x03\xbd\xbc\x2c\x2e\x8b\x37\xa5\x22\x2a\x8f\x6b\xab\x2e\x45\x24\xfe\xf3\xb9\x39\x8f\x38\xe1\xc9\xe9\x21\xa0\xed\x62\xcc\xb2\xc7\xe4\x49\x0f\x56\xdb\x94\xfd\xd1\xa9\xcf\x7a\xb0\xa0\xed\xd1\xa7\x10\x74\x0f\xc5\xc7\x35\x88\xf4\xdf\x8d\xbd\xc5\x41\xaa\x6d\x8c\xcf\x5a\xb0\x4b\x2b\xdf\x41\x73\x52\x6e\xc6\x93\x42\xcc\xde\xf5\xda\x20\xe0\x24\xb5\x11\xcd\x3f\xa6\x95\xd1\x68\xf9\x9b\xee\x4e\x08\x21\xec\x75\xbc\x5b\x78\xbc\x5a\x37\xdc\x5c\xc7\x28\x9a\x17\x5a\x94\xaa\xb3\xd3\xb5\x7a\xb8\xa1\x72\x36\x92\x7b\xe7\xa7\xd4\x15\x90\xb4\xb5\x0a\x3a\xec\xd1\x9f\xf4\x26\x99\x11\x02\x1a\xc2\x18\x5f\x60\x2b\xe5\x16\xcb\x4f\xf8\x03\xe9\x72\x27\x7d\xcf\x7e\x4e\x5d\x33\xf8\x4b\x16\x6e\xe1\x27\x61\xdc\xba\x61\xd0\x76\x00\xb7\x30\x4c\x0b\x31\xb4\x08\xb3\x23\xc6\xee\x1c\xc8\x01\x8f\x92\xc1\xf1\x88\x1e\x48\x33\x12\x28\x69\x5f\x31\x18\x37\x00\x8f\x08\x0b\xa1\x4f\xa3\xc5\x63\xe9\xd3\x87\xc3\x84\x3c\xba\xae\x16\x89\x24\x40\x2a\xd6\xce\xd6\x22\x84\xe2\xd6\x0d\x6e\xe1\x3b\xc9\x63\x8c\x02\x94\x91\x44\xb5\xb0\xf2\xbe\x95\x3e\x5f\x27\xf7\xcf\x5e\x0f\x23\xef\x6d\x07\xa8\xda\x85\xd9\x59\xe0\xed\x8c\xb5\xa0\xa5\x9d\x34\x1f\xa7\x5b\xb6\xd0\xb2\xcd\x3b\xec\xe5\x62\x58\x34\xa9\x43\x49\x54\x55\xee\xc6\xf6\xc2\xca\xc4\xff\x63\x47\xd3\x8d\x83\x3e\xd9\x12\x02\xe3\x94\xe2\x3b\xdc\x59\x50\xac\x66\x55\xe5\xe1\x56\xaa\x5a\xd7\x6d\x9b\x2c\x04\xb4\x5d\x8c\xd9\xaf\x01\x00\x4e\xe2\x80\x2c\xe3\x04\x00\x00")
 
 func templatesHeaderHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -105,12 +148,32 @@ func templatesHeaderHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "templates/header.html", size: 766, mode: os.FileMode(420), modTime: time.Unix(1528206105, 0)}
+	info := bindataFileInfo{name: "templates/header.html", size: 1251, mode: os.FileMode(420), modTime: time.Unix(1792309785, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _templatesLoggedoutHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x93\x41\xab\xdb\x30\x10\x84\xef\xf9\x15\x8b\x4f\x0e\xbc\xca\x90\x6b\x1d\x43\x0f\xa5\x14\x0c\x85\x3e\x7a\xe8\x71\x6d\xad\xed\xa5\xee\x6e\x90\xd6\x09\xc1\xf8\xbf\x17\x2b\x2f\x69\xdc\xd2\xde\x24\xcd\x37\x9a\x59\x61\xcf\xb3\xd1\xcf\xd3\x88\x46\x90\x0d\x84\x3e\x03\x07\xcb\xb2\x7b\x3e\x8e\xdc\x0b\xcb\x9b\x00\x50\x16\x2b\x57\xed\x00\xca\x46\xfd\x75\x5d\x00\x94\x9e\xcf\xd0\x8e\x18\xe3\x31\x6b\x55\x0c\x59\x28\x64\x37\x0d\xa0\x1c\x0e\xd5\x2b\xf7\x42\x1e\x74\xb2\xb2\x18\x0e\xd5\xee\x2e\x9d\xee\xb6\x71\x4d\xaf\xbe\xeb\x04\x18\x08\xe2\x03\x77\x65\x71\x7a\xc2\xab\x12\xef\x8e\xc6\x04\x1a\x93\x77\x9e\x3a\x9c\x46\xcb\x60\x08\xd4\x1d\xb3\x79\x76\xb5\xf6\x2c\xdf\xbe\xd6\xcb\x92\xa5\x60\x60\x01\xec\x91\xa5\x2c\xb0\xda\xdc\x17\xdb\xc0\x27\xbb\x17\x05\x28\x0a\x48\x06\x9d\x0c\xb4\x03\x1b\x08\x3e\xa9\xf6\x23\x41\xa4\x18\x59\x05\x4c\xf5\x05\xd4\x06\x0a\x17\x8e\x94\x88\x71\x8d\x83\xd8\x06\x22\x49\xcd\x63\x3a\x9e\x22\x05\x88\x16\x90\xfb\xc1\xa0\xc1\xf6\x07\xb0\xb8\x47\xd4\x3c\x73\x07\xee\x76\xfb\x9a\xf9\x59\x6a\xea\xb1\xbd\xa6\x57\x4e\x04\x5c\x58\xbc\x5e\x1c\x7a\xff\xf1\x4c\x62\x35\x47\x23\xa1\x90\x67\xa3\xa2\xcf\x5e\xa0\x9b\xa4\xb5\xb5\x54\xbe\x87\xf9\xe1\x02\xe8\xf1\xc4\x6e\x65\xf2\x0c\x27\x1b\x0e\xff\x41\xdf\xe0\x84\x39\x16\xb6\x7c\xef\x6c\x20\xc9\x7f\x1b\x92\xf6\xa7\x0b\x20\x1d\xbb\x75\xdc\x2f\x93\xe5\xfb\xf7\x1b\x79\xd9\xec\x9f\x77\xcf\xeb\x79\xa6\x31\xd2\xdf\x03\xab\xdc\x9e\xa5\xe6\x26\x60\xb8\xd6\x8a\x1e\x8e\xff\x9e\x36\xb1\x0e\xdb\x56\x27\xb1\xe8\xd8\x3b\xcf\x11\x9b\x91\x3e\x4c\xa6\xaf\x34\x52\xbb\xe9\xb7\x6c\x0a\x88\x7f\xe4\x97\xc5\xf3\xe7\x50\x16\x9e\xcf\xd5\xe6\x57\xe8\x54\x8d\x42\xb6\x2c\xbb\x5f\x03\x00\xcf\xf2\xf5\xfe\x38\x03\x00\x00")
+
+func templatesLoggedoutHtmlBytes() ([]byte, error) {
+	return bindataRead(
+		_templatesLoggedoutHtml,
+		"templates/loggedout.html",
+	)
+}
+
+func templatesLoggedoutHtml() (*asset, error) {
+	bytes, err := templatesLoggedoutHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "templates/loggedout.html", size: 824, mode: os.FileMode(420), modTime: time.Unix(1792308510, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
 
-var _templatesLoginHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x5c\x92\x41\x8f\x9b\x40\x0c\x85\xef\xf9\x15\x96\xdb\x03\x1c\x16\xa4\x5c\x0b\x48\x7b\xaa\x2a\xf5\xd4\x6a\xcf\xab\xe9\x8c\x03\xa3\x80\x3d\x9a\x31\x48\x51\xc4\x7f\xaf\x48\x33\x04\xf5\x68\xfb\x7b\xcf\xe6\x31\xf7\xbb\xd2\x14\x46\xa3\x04\x38\x90\x71\x14\x11\x2a\x58\xd7\x13\x00\x40\xe3\xfc\x02\x76\x34\x29\xb5\x68\x85\xd5\x78\xa6\x88\xdd\x63\x06\xd0\x0c\xe7\xee\xa7\xf4\x9e\x9b\x7a\x38\x77\xa7\xdc\x0d\x59\x31\x92\x71\xd8\x7d\x24\x82\x9b\xcc\x11\xbe\x8b\xf4\x23\xc1\xbb\xb5\x32\xb3\x36\x75\x78\x49\x0e\x6b\xfa\xb7\xe4\x7b\xf6\x7c\x46\x70\x46\xcd\x9b\x70\x9a\xad\xa5\x6d\x24\xfc\xdb\xf7\xfc\x83\x9f\x13\x1d\x68\xa2\x16\x9d\x89\x57\xec\x9a\xda\xf9\xe5\x65\x98\x6c\xf4\x41\xf3\xa1\x00\x97\x99\xad\x7a\x61\xc8\x1e\x45\xff\xb8\xe6\x23\x51\x2c\xe1\xbe\x73\x00\x8b\x89\xe0\xdd\xa7\xca\x95\x18\x5a\x78\x61\x55\x4f\xfa\x3e\xeb\xf0\x8b\x52\x10\x4e\x54\x94\x55\xe6\xbe\x1d\xf4\x5f\x0b\xfc\x92\xfb\x58\x56\x8b\x19\x8b\x5c\x96\xff\x73\xe3\x16\xde\xe7\x45\xe2\x84\x65\x95\xe6\x3f\x93\xd7\xa2\xdc\x99\x35\xe3\x4d\x9d\x3f\x27\x37\x36\x09\x78\xd7\xe2\xc1\x01\x26\xd2\x41\x5c\x8b\x41\x92\x62\x77\x58\xd5\x78\x0e\xb3\x82\xde\x02\xb5\x38\x78\xe7\x88\xf1\xa1\xde\xef\x04\x36\x5b\x94\x7b\x5d\xef\xbf\xb8\xde\xbc\xff\x55\xcf\x88\x8f\x0f\xe6\x22\xa2\x14\x71\x5d\x4f\x7f\x03\x00\x00\xff\xff\xa1\xf4\x67\xb1\x47\x02\x00\x00")
+var _templatesLoginHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x55\x51\xab\xeb\x36\x0c\x7e\x3f\xbf\x42\xf3\xf6\xd0\x3e\xb4\x85\xf3\xba\xa4\x70\x39\x63\xa3\x70\x36\x46\xcf\xfa\x1c\xdc\x58\x49\x4c\x1d\x39\xd8\x4a\xef\xba\x90\xff\x3e\xec\xd4\x6d\x76\xdb\xc3\x2e\x94\x62\x5b\x9f\x3e\x49\x9f\x65\x65\x18\x18\xdb\xce\x48\x46\x10\x0d\x4a\x25\x60\x0d\xe3\xf8\x32\x0c\xba\x02\xb2\x0c\xeb\x0f\x5d\xd3\x8e\x0e\xfb\xf7\x71\x9c\x63\xbd\xae\x49\xd3\x84\x1e\x06\x24\x35\x8e\x2f\x00\xd9\x26\x70\x6c\xc3\xea\x68\xd5\x25\x2c\x00\x32\xa5\xcf\x50\x1a\xe9\x7d\x2e\x4a\x4b\x2c\x35\xa1\x13\x93\x0d\x20\x6b\x5e\xb7\xef\xb6\xd6\x94\x6d\x9a\xd7\xed\xcb\xf5\x34\xc6\x5f\xff\x8e\xde\xcb\x1a\xc7\x71\x4e\x21\x0d\x3a\x86\xf8\xbf\xfa\x2a\x1d\x69\xaa\xc5\x76\x18\x66\xe0\x8d\xd2\xe7\x6d\x4a\x2a\x85\xe9\x92\xbf\x09\x55\x6e\x0f\x1e\xe1\x62\x7b\x07\xbf\x59\x5b\x1b\x84\x2f\x65\x69\x7b\xe2\x6c\xd3\x7d\x93\xc3\xac\xfe\x44\x25\x13\xd5\x91\x09\x8e\x4c\xab\xce\xe9\x56\xba\x4b\x5c\x9b\x5a\x40\xe3\xb0\xca\xc5\x30\xcc\xc5\x13\xdb\xb0\x01\x4d\xf0\x55\x73\x73\x0d\x9b\x6d\x64\xd2\x61\x18\xd0\x78\x84\x50\xf6\x64\x0b\xf0\x1d\xbd\x63\x2d\xcb\xcb\x3d\xf6\x4c\x88\x7a\x35\x5d\xc2\xab\x00\x25\x59\xae\x2c\xf9\xbe\x2c\x31\x24\x66\x69\xf2\xbe\x5a\xb8\xc1\x16\x73\xa1\xa4\x3b\x89\xed\x24\xcf\x4d\x17\x5f\x3a\xdd\x71\xca\x02\xa0\xea\xa9\x64\x6d\x09\x12\xc7\xa2\x8e\xe9\x1c\x3c\xba\x25\x0c\x37\x1c\xc0\x59\x3a\xd0\xaa\x60\x7b\x42\x82\x1c\xee\xb0\x75\x8d\xfc\xa5\xe7\x66\x8f\xbe\xb3\xe4\x71\xb1\x5c\x27\xdc\xcf\x33\xff\x9f\x16\xe2\xc7\x74\x2e\x96\xeb\xb3\x34\x8b\xb4\x5d\x7e\x8b\x33\xa1\x43\x8a\xca\xba\x56\x2c\xd7\xbe\x3f\xb6\x9a\x17\xcb\x1b\x66\x4c\xf0\x6c\x93\xca\x49\x07\xc1\x05\xb4\xca\xc5\x8c\x01\x5a\xe4\xc6\xaa\x5c\x74\xd6\xb3\x00\x19\xeb\x8d\xf7\xf5\x26\x8d\x39\xca\xf2\xf4\xa7\xe4\x26\x5c\xd9\x95\x25\xfc\x32\x4d\x5d\xcf\xc0\x97\x0e\x73\xd1\x68\xa5\x90\x44\x24\xbe\x95\x00\x24\x5b\x9c\xed\x37\xff\xeb\x3e\x39\x78\x96\x8c\x02\xce\xd2\xf4\x18\xb3\xf8\x08\x07\xe3\xf8\xdd\x04\xa5\x77\x55\xca\xe1\xce\xf2\xf6\xb1\xff\xf5\xaf\x70\x38\x67\xca\x36\x41\x81\xb4\x9b\x7a\xee\xde\x5c\x3f\xac\x56\xe9\x3d\xec\x14\x12\x6b\xbe\xc0\x07\xba\xb3\x2e\xd1\x43\x10\xcb\x03\x37\x08\xa5\xc3\x68\x95\x06\x24\x29\xa8\x8b\x7b\x02\xc0\x36\x42\xa2\xda\x70\xd8\xef\xd6\xb0\x5a\xdd\x82\x87\xe6\x0d\x92\xd5\x85\x56\x85\x25\x63\xa5\x12\x57\x5b\xfc\xc5\x66\x2d\x8d\x46\xe2\x22\xe0\x86\xe1\xfa\x16\x42\x37\xbd\xc5\xf3\xdd\x2f\xe3\xf8\xe8\x13\xc3\x15\xbd\xd3\xd1\x27\x8e\x93\xc3\x7e\xf7\x0c\x49\x96\xca\x49\x9f\x3f\xc2\xea\x19\x24\xcc\x28\xfc\x9b\xf3\x34\xe3\x1e\x00\xb2\x67\x5b\x74\xce\xb6\x1d\xe7\x82\x5d\x8f\x8f\x10\xcd\x5d\xe1\xfb\xae\xb3\xee\x53\x48\xef\xb1\xa8\x50\x95\x6d\x68\xeb\xff\xd2\xdd\x04\x9b\x9e\xea\x93\xa7\x1f\x04\xfc\x2c\xbd\xa9\x45\x3c\x4b\x52\xd2\x3d\x11\xf8\x3a\x0d\x2a\x6d\x0c\xaa\xe2\x68\x64\x79\x7a\x04\x79\xfd\x0f\xe6\xc2\x48\x57\x3f\x49\x7d\x2e\x4f\x11\xc6\xd9\x23\xc4\xd8\xda\x16\xd2\xe8\x9a\x5a\x24\x0e\x53\xb7\xe2\xe7\x75\xdd\xbf\x1e\x37\xc3\xfc\x43\x53\x59\xcb\xe8\xc4\x38\xbe\xfc\x3b\x00\x6e\xdc\xb6\xa6\xab\x06\x00\x00")
 
 func templatesLoginHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -125,7 +188,7 @@ func templatesLoginHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "templates/login.html", size: 583, mode: os.FileMode(420), modTime: time.Unix(1528206088, 0)}
+	info := bindataFileInfo{name: "templates/login.html", size: 1707, mode: os.FileMode(420), modTime: time.Unix(1792310287, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -182,8 +245,11 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
+	"templates/admin.html": templatesAdminHtml,
+	"templates/error.html": templatesErrorHtml,
 	"templates/footer.html": templatesFooterHtml,
 	"templates/header.html": templatesHeaderHtml,
+	"templates/loggedout.html": templatesLoggedoutHtml,
 	"templates/login.html": templatesLoginHtml,
 }
 
@@ -228,8 +294,11 @@ type bintree struct {
 }
 var _bintree = &bintree{nil, map[string]*bintree{
 	"templates": &bintree{nil, map[string]*bintree{
+		"admin.html": &bintree{templatesAdminHtml, map[string]*bintree{}},
+		"error.html": &bintree{templatesErrorHtml, map[string]*bintree{}},
 		"footer.html": &bintree{templatesFooterHtml, map[string]*bintree{}},
 		"header.html": &bintree{templatesHeaderHtml, map[string]*bintree{}},
+		"loggedout.html": &bintree{templatesLoggedoutHtml, map[string]*bintree{}},
 		"login.html": &bintree{templatesLoginHtml, map[string]*bintree{}},
 	}},
 }}
@@ -2,6 +2,7 @@ package templates
 
 import (
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -9,7 +10,44 @@ import (
 
 func TestThatTheLoginPageCanBeRendered(t *testing.T) {
 	w := httptest.NewRecorder()
-	RenderLogin(w, LoginModel{GoogleAuthClientID: "the_client_id"})
+	RenderLogin(w, LoginModel{
+		GoogleAuthClientID: "the_client_id",
+		LoginURI:           "https://example.com/_gauth/callback?state=the_state%3D",
+		Nonce:              "the_nonce",
+		Message:            "Your session has expired.",
+	})
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	for _, expected := range []string{
+		`src="https://accounts.google.com/gsi/client"`,
+		`data-client_id="the_client_id"`,
+		`data-login_uri="https://example.com/_gauth/callback?state=the_state%3D"`,
+		`data-nonce="the_nonce"`,
+		`data-use_fedcm_for_prompt="true"`,
+		`class="g_id_signin"`,
+		"Your session has expired.",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %s, but didn't find it: %v", expected, string(body))
+		}
+	}
+	if strings.Contains(string(body), "platform.js") {
+		t.Errorf("expected the legacy platform library not to be loaded: %v", string(body))
+	}
+}
+
+func TestThatTheLegacyLoginPageCanBeRendered(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderLogin(w, LoginModel{
+		GoogleAuthClientID: "the_client_id",
+		GoogleSignInLegacy: true,
+		CallbackPath:       "/_gauth/callback",
+		State:              "the_state",
+		Message:            "Your session has expired.",
+		CSRFToken:          "the_csrf_token",
+	})
 	body, err := ioutil.ReadAll(w.Result().Body)
 	if err != nil {
 		t.Errorf("failed to read body: %v", err)
@@ -17,4 +55,114 @@ func TestThatTheLoginPageCanBeRendered(t *testing.T) {
 	if !strings.Contains(string(body), "the_client_id") {
 		t.Errorf("expected 'the_client_id', but didn't find it: %v", string(body))
 	}
+	if !strings.Contains(string(body), `action="/_gauth/callback"`) {
+		t.Errorf("expected the form to post to the callback, but it didn't: %v", string(body))
+	}
+	if !strings.Contains(string(body), `value="the_state"`) {
+		t.Errorf("expected the state, but didn't find it: %v", string(body))
+	}
+	if !strings.Contains(string(body), `name="csrf_token" value="the_csrf_token"`) {
+		t.Errorf("expected the CSRF token, but didn't find it: %v", string(body))
+	}
+	if !strings.Contains(string(body), "Your session has expired.") {
+		t.Errorf("expected the message, but didn't find it: %v", string(body))
+	}
+}
+
+func TestThatTheCodeFlowLoginPageDoesNotUseJavaScript(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderLogin(w, LoginModel{
+		GoogleAuthClientID: "the_client_id",
+		SignInURL:          "/_gauth/start?return=%2Freports%3Fyear%3D2017",
+	})
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	if !strings.Contains(string(body), `href="/_gauth/start?return=%2Freports%3Fyear%3D2017"`) {
+		t.Errorf("expected a link to sign in, but didn't find it: %v", string(body))
+	}
+	for _, unexpected := range []string{"gsi/client", "platform.js", "g_id_onload"} {
+		if strings.Contains(string(body), unexpected) {
+			t.Errorf("expected %s not to be used, but found it: %v", unexpected, string(body))
+		}
+	}
+}
+
+func TestThatTheLoggedOutPageCanBeRendered(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderLoggedOut(w, LoggedOutModel{
+		GoogleAuthClientID: "the_client_id",
+		LoginURL:           "/reports",
+	})
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	if !strings.Contains(string(body), "You are signed out.") {
+		t.Errorf("expected 'You are signed out.', but didn't find it: %v", string(body))
+	}
+	if !strings.Contains(string(body), `href="/reports"`) {
+		t.Errorf("expected a link to sign in again, but didn't find it: %v", string(body))
+	}
+	if !strings.Contains(string(body), "google.accounts.id.disableAutoSelect()") {
+		t.Errorf("expected the user to be signed out of One Tap, but didn't find it: %v", string(body))
+	}
+
+	w = httptest.NewRecorder()
+	RenderLoggedOut(w, LoggedOutModel{
+		GoogleAuthClientID: "the_client_id",
+		GoogleSignInLegacy: true,
+		LoginURL:           "/reports",
+	})
+	if body, _ := ioutil.ReadAll(w.Result().Body); !strings.Contains(string(body), "auth2.signOut()") {
+		t.Errorf("expected the user to be signed out of Google Sign-In, but didn't find it: %v", string(body))
+	}
+}
+
+func TestThatTheAdminPageCanBeRendered(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderAdmin(w, AdminModel{
+		GoogleAuthClientID: "the_client_id",
+		Path:               "/_gauth/admin",
+		LogoutPath:         "/_gauth/logout",
+		Sessions: []AdminSession{
+			{
+				Handle:    "the_handle",
+				UserID:    "110169484474386276334",
+				Email:     "a-h@github.com",
+				Name:      "Adrian",
+				IP:        "192.0.2.1",
+				UserAgent: "Mozilla/5.0",
+			},
+		},
+	})
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	for _, expected := range []string{"a-h@github.com", "192.0.2.1", `action="/_gauth/admin/revoke"`, `value="the_handle"`, `value="110169484474386276334"`, `<form method="post" action="/_gauth/logout"`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q, but didn't find it: %v", expected, string(body))
+		}
+	}
+}
+
+func TestThatTheErrorPageCanBeRendered(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderError(w, http.StatusForbidden, ErrorModel{
+		GoogleAuthClientID: "the_client_id",
+		Message:            "You are signed in on too many devices.",
+		LoginURL:           "/",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Errorf("failed to read body: %v", err)
+	}
+	if !strings.Contains(string(body), "You are signed in on too many devices.") {
+		t.Errorf("expected the message, but didn't find it: %v", string(body))
+	}
 }
@@ -3,6 +3,7 @@ package templates
 import (
 	"html/template"
 	"net/http"
+	"time"
 )
 
 var templates *template.Template
@@ -11,12 +12,34 @@ func init() {
 	templates = template.New("")
 	template.Must(templates.New("header.html").Parse(string(MustAsset("templates/header.html"))))
 	template.Must(templates.New("login.html").Parse(string(MustAsset("templates/login.html"))))
+	template.Must(templates.New("loggedout.html").Parse(string(MustAsset("templates/loggedout.html"))))
+	template.Must(templates.New("error.html").Parse(string(MustAsset("templates/error.html"))))
+	template.Must(templates.New("admin.html").Parse(string(MustAsset("templates/admin.html"))))
 	template.Must(templates.New("footer.html").Parse(string(MustAsset("templates/footer.html"))))
 }
 
 // LoginModel is the data required to render the Login screen.
 type LoginModel struct {
 	GoogleAuthClientID string
+	// GoogleSignInLegacy uses the deprecated Google Sign-In platform library, instead of
+	// Google Identity Services.
+	GoogleSignInLegacy bool
+	// CallbackPath is where the ID token is posted to.
+	CallbackPath string
+	// State is posted back to the callback, it records the page the user was trying to access.
+	State string
+	// LoginURI is the absolute URL Google Identity Services posts the ID token to.
+	LoginURI string
+	// Nonce is sent to Google Identity Services, to be included in the ID token.
+	Nonce string
+	// SignInURL is where the user is sent to sign in using the authorization code flow. If
+	// set, no JavaScript is used.
+	SignInURL string
+	// Message explains why the user must log in, e.g. because their session expired.
+	Message string
+	// CSRFToken is posted back to the callback, it proves the login screen was shown by
+	// this site.
+	CSRFToken string
 }
 
 // RenderLogin renders the login template.
@@ -24,6 +47,65 @@ func RenderLogin(w http.ResponseWriter, model LoginModel) error {
 	return Render(w, "login.html", model)
 }
 
+// LoggedOutModel is the data required to render the Logged Out screen.
+type LoggedOutModel struct {
+	GoogleAuthClientID string
+	// GoogleSignInLegacy signs out using the deprecated Google Sign-In platform library,
+	// instead of Google Identity Services.
+	GoogleSignInLegacy bool
+	// LoginURL is where the user can go to sign in again.
+	LoginURL string
+}
+
+// RenderLoggedOut renders the logged out template.
+func RenderLoggedOut(w http.ResponseWriter, model LoggedOutModel) error {
+	return Render(w, "loggedout.html", model)
+}
+
+// ErrorModel is the data required to render the Error screen.
+type ErrorModel struct {
+	GoogleAuthClientID string
+	// Message explains why the user couldn't sign in.
+	Message string
+	// LoginURL is where the user can go to try again.
+	LoginURL string
+}
+
+// RenderError renders the error template with the HTTP status code.
+func RenderError(w http.ResponseWriter, status int, model ErrorModel) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	return Render(w, "error.html", model)
+}
+
+// AdminModel is the data required to render the Admin screen.
+type AdminModel struct {
+	GoogleAuthClientID string
+	// Path is where the admin screen is served from.
+	Path string
+	// LogoutPath is where the sign out form is posted to.
+	LogoutPath string
+	Sessions   []AdminSession
+}
+
+// AdminSession is an active session shown on the Admin screen.
+type AdminSession struct {
+	// Handle identifies the session when revoking it.
+	Handle    string
+	UserID    string
+	Email     string
+	Name      string
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// RenderAdmin renders the admin template.
+func RenderAdmin(w http.ResponseWriter, model AdminModel) error {
+	return Render(w, "admin.html", model)
+}
+
 // Render template to HTTP.
 func Render(w http.ResponseWriter, templateName string, model interface{}) (err error) {
 	err = templates.ExecuteTemplate(w, templateName, model)
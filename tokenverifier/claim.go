@@ -1,6 +1,9 @@
 package tokenverifier
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+)
 
 // A Claim represents a subset of fields available in a JWT.
 // See (https://tools.ietf.org/html/draft-ietf-oauth-json-web-token-32) and
@@ -17,6 +20,20 @@ type Claim struct {
 	GivenName     string `json:"given_name"`  // e.g. "Test"
 	FamilyName    string `json:"family_name"` // e.g. "User"
 	HD            string `json:"hd"`          // e.g. "infinityworks.com" - The GSuite domain.
+	// The audience, the client ID of the application the token was issued for.
+	Audience string `json:"aud"`
+	// The authorized party, the client ID of the application which requested the token.
+	AuthorizedParty string `json:"azp"`
+	// The subject, a stable identifier for the Google account, e.g. "110169484474386276334".
+	Subject string `json:"sub"`
+	// The time the token was issued, e.g. "1433978353".
+	IssuedAt string `json:"iat"`
+	// The time before which the token must not be accepted, e.g. "1433978353".
+	NotBefore string `json:"nbf"`
+	// The nonce sent by the login screen when the token was requested.
+	Nonce string `json:"nonce"`
+	// The JWT ID, a unique identifier for the token.
+	JWTID string `json:"jti"`
 }
 
 // NewClaim creates an instance of a claim from JWT JSON.
@@ -25,3 +42,46 @@ func NewClaim(jwt []byte) (claim *Claim, err error) {
 	err = json.Unmarshal(jwt, claim)
 	return claim, err
 }
+
+// UnmarshalJSON accepts both the string values returned by the tokeninfo endpoint
+// and the numbers and booleans found in the payload of a signed JWT.
+func (c *Claim) UnmarshalJSON(data []byte) error {
+	type plain Claim
+	v := struct {
+		*plain
+		Expiry        claimValue `json:"exp"`
+		EmailVerified claimValue `json:"email_verified"`
+		IssuedAt      claimValue `json:"iat"`
+		NotBefore     claimValue `json:"nbf"`
+	}{
+		plain: (*plain)(c),
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c.Expiry = string(v.Expiry)
+	c.EmailVerified = string(v.EmailVerified)
+	c.IssuedAt = string(v.IssuedAt)
+	c.NotBefore = string(v.NotBefore)
+	return nil
+}
+
+// claimValue is a JSON string, number or boolean stored as a string.
+type claimValue string
+
+func (cv *claimValue) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*cv = claimValue(s)
+		return nil
+	}
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*cv = claimValue(raw)
+	return nil
+}
@@ -11,19 +11,29 @@ import (
 	"time"
 )
 
+// GoogleIssuers are the values of the iss claim in ID tokens issued by Google.
+var GoogleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
 // A GoogleTokenVerifier verifies Tokens with Google.
 type GoogleTokenVerifier struct {
+	// ClientIDs are the Google client IDs (web and mobile) which tokens may be issued for.
+	ClientIDs      []string
 	AllowedDomains []string
+	// Issuers are the accepted values of the iss claim. If empty, GoogleIssuers are
+	// accepted.
+	Issuers []string
 }
 
 // ValidateToken retrieves a claim from Google and validates it using Google's rules.
-func (verifier GoogleTokenVerifier) ValidateToken(idToken string) (claim *Claim, err error) {
+func (verifier GoogleTokenVerifier) ValidateToken(idToken string, nonce string) (claim *Claim, err error) {
 	claim, err = verifier.GetClaim(idToken)
 	if err != nil {
 		return
 	}
-	_, err = verifier.IsClaimValid(claim)
-	return claim, err
+	if _, err = verifier.IsClaimValid(claim); err != nil {
+		return
+	}
+	return claim, checkNonce(claim, nonce)
 }
 
 // GetClaim returns a Claim from Google, using the id_token presented by the
@@ -47,10 +57,12 @@ func getResponse(url string) (body []byte, err error) {
 }
 
 // IsClaimValid validates a claim by checking that it's not expired, the issuer
-// was Google and that the user's email address has been verified.
+// was Google, or one of the Issuers, the token was issued for one of our client IDs
+// and that the user's email address has been verified.
 func (verifier GoogleTokenVerifier) IsClaimValid(claim *Claim) (ok bool, err error) {
 	expiry, expiryErr := strconv.Atoi(claim.Expiry)
 	emailVerified, emailVerifiedErr := strconv.ParseBool(claim.EmailVerified)
+	notBefore, notBeforeErr := strconv.Atoi(claim.NotBefore)
 
 	validation := []struct {
 		name               string
@@ -60,8 +72,21 @@ func (verifier GoogleTokenVerifier) IsClaimValid(claim *Claim) (ok bool, err err
 		{"email verified ok", func() bool { return emailVerifiedErr == nil && emailVerified }},
 		{"expiry is number", func() bool { return expiryErr == nil }},
 		{"expiry ok", func() bool { return time.Unix(int64(expiry), 0).After(time.Now()) }},
+		{"not before ok", func() bool {
+			if claim.NotBefore == "" {
+				return true
+			}
+			return notBeforeErr == nil && !time.Unix(int64(notBefore), 0).After(time.Now())
+		}},
 		{"issuer ok", func() bool {
-			return claim.Issuer == "https://accounts.google.com" || claim.Issuer == "accounts.google.com"
+			if len(verifier.Issuers) == 0 {
+				return contains(GoogleIssuers, claim.Issuer)
+			}
+			return contains(verifier.Issuers, claim.Issuer)
+		}},
+		{"audience ok", func() bool { return contains(verifier.ClientIDs, claim.Audience) }},
+		{"authorized party ok", func() bool {
+			return claim.AuthorizedParty == "" || contains(verifier.ClientIDs, claim.AuthorizedParty)
 		}},
 		{"domain ok", func() bool {
 			if len(verifier.AllowedDomains) == 0 {
@@ -88,3 +113,12 @@ func (verifier GoogleTokenVerifier) IsClaimValid(claim *Claim) (ok bool, err err
 	}
 	return
 }
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s != "" && s == v {
+			return true
+		}
+	}
+	return false
+}
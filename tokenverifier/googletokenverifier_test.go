@@ -37,6 +37,30 @@ func TestThatAClaimCanBeUnmarshalled(t *testing.T) {
 	validate("picture", "https://lh4.googleusercontent.com/-kYgzyAWpZzJ/ABCDEFGHI/AAAJKLMNOP/tIXL9Ir44LE/s99-c/photo.jpg", claim.Picture, t)
 	validate("given_name", "Test", claim.GivenName, t)
 	validate("family_name", "User", claim.FamilyName, t)
+	validate("sub", "110169484474386276334", claim.Subject, t)
+	validate("aud", "1008719970978-hb24n2dstb40o45d4feuo2ukqmcc6381.apps.googleusercontent.com", claim.Audience, t)
+	validate("azp", "1008719970978-hb24n2dstb40o45d4feuo2ukqmcc6381.apps.googleusercontent.com", claim.AuthorizedParty, t)
+	validate("iat", "1433978353", claim.IssuedAt, t)
+}
+
+func TestThatAClaimFromASignedJWTCanBeUnmarshalled(t *testing.T) {
+	claimJSON := `{
+ "iss": "https://accounts.google.com",
+ "exp": 1433981953,
+ "email": "testuser@gmail.com",
+ "email_verified": true
+}`
+
+	claim, err := NewClaim([]byte(claimJSON))
+
+	if err != nil {
+		t.Fatal("Received an error during unmarshalling. ", err)
+	}
+
+	validate("issuer", "https://accounts.google.com", claim.Issuer, t)
+	validate("exp", "1433981953", claim.Expiry, t)
+	validate("email", "testuser@gmail.com", claim.Email, t)
+	validate("email_verified", "true", claim.EmailVerified, t)
 }
 
 func validate(field string, expected string, actual string, t *testing.T) {
@@ -54,9 +78,10 @@ func TestThatAValidClaimIsValidatedSuccessfully(t *testing.T) {
 		Expiry:        expiry,
 		EmailVerified: "true",
 		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
 	}
 
-	gtv := &GoogleTokenVerifier{}
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
 	ok, err := gtv.IsClaimValid(claim)
 
 	if !ok {
@@ -74,10 +99,12 @@ func TestThatAValidClaimLimitedToASpecificDomainIsValidatedSuccessfully(t *testi
 		Expiry:        expiry,
 		EmailVerified: "true",
 		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
 		HD:            "github.com",
 	}
 
 	gtv := &GoogleTokenVerifier{
+		ClientIDs:      []string{"the_client_id"},
 		AllowedDomains: []string{"microsoft.com", "github.com"},
 	}
 	ok, err := gtv.IsClaimValid(claim)
@@ -97,9 +124,10 @@ func TestThatExpiredClaimFailValidation(t *testing.T) {
 		Expiry:        expiry,
 		EmailVerified: "true",
 		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
 	}
 
-	gtv := &GoogleTokenVerifier{}
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
 	ok, err := gtv.IsClaimValid(claim)
 
 	if ok {
@@ -117,9 +145,10 @@ func TestThatClaimsWithInvalidIssuersFailValidation(t *testing.T) {
 		Expiry:        expiry,
 		EmailVerified: "true",
 		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
 	}
 
-	gtv := &GoogleTokenVerifier{}
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
 	ok, err := gtv.IsClaimValid(claim)
 
 	if ok {
@@ -137,9 +166,10 @@ func TestThatClaimsWithoutVerifiedEmailAddressesFailValidation(t *testing.T) {
 		Expiry:        expiry,
 		EmailVerified: "false",
 		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
 	}
 
-	gtv := &GoogleTokenVerifier{}
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
 	ok, err := gtv.IsClaimValid(claim)
 
 	if ok {
@@ -157,9 +187,10 @@ func TestThatClaimsWithMissingEmailAddressesFailValidation(t *testing.T) {
 		Expiry:        expiry,
 		EmailVerified: "true",
 		Email:         "",
+		Audience:      "the_client_id",
 	}
 
-	gtv := &GoogleTokenVerifier{}
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
 	ok, err := gtv.IsClaimValid(claim)
 
 	if ok {
@@ -167,3 +198,76 @@ func TestThatClaimsWithMissingEmailAddressesFailValidation(t *testing.T) {
 		t.Error(claim)
 	}
 }
+
+func TestThatClaimsForOtherClientIDsFailValidation(t *testing.T) {
+	secondsSince1970 := time.Now().Add(time.Hour).Unix()
+	expiry := strconv.Itoa(int(secondsSince1970))
+
+	claim := &Claim{
+		Issuer:        "https://accounts.google.com",
+		Expiry:        expiry,
+		EmailVerified: "true",
+		Email:         "a-h@github.com",
+		Audience:      "another_client_id",
+	}
+
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
+	ok, err := gtv.IsClaimValid(claim)
+
+	if ok {
+		t.Error("The claim should not have been passed, it was issued for another application. ", err)
+		t.Error(claim)
+	}
+}
+
+func TestThatClaimsFromAMobileClientIDPassValidation(t *testing.T) {
+	secondsSince1970 := time.Now().Add(time.Hour).Unix()
+	expiry := strconv.Itoa(int(secondsSince1970))
+
+	claim := &Claim{
+		Issuer:          "https://accounts.google.com",
+		Expiry:          expiry,
+		EmailVerified:   "true",
+		Email:           "a-h@github.com",
+		Audience:        "the_client_id",
+		AuthorizedParty: "the_android_client_id",
+	}
+
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id", "the_android_client_id"}}
+	ok, err := gtv.IsClaimValid(claim)
+
+	if !ok {
+		t.Error("The claim had all of the required properties set correctly. ", err)
+		t.Error(claim)
+	}
+
+	gtv = &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
+	ok, err = gtv.IsClaimValid(claim)
+
+	if ok {
+		t.Error("The claim should not have been passed, the authorized party is not accepted. ", err)
+		t.Error(claim)
+	}
+}
+
+func TestThatClaimsWhichAreNotYetValidFailValidation(t *testing.T) {
+	secondsSince1970 := time.Now().Add(time.Hour).Unix()
+	expiry := strconv.Itoa(int(secondsSince1970))
+
+	claim := &Claim{
+		Issuer:        "https://accounts.google.com",
+		Expiry:        expiry,
+		NotBefore:     strconv.Itoa(int(time.Now().Add(time.Minute * 30).Unix())),
+		EmailVerified: "true",
+		Email:         "a-h@github.com",
+		Audience:      "the_client_id",
+	}
+
+	gtv := &GoogleTokenVerifier{ClientIDs: []string{"the_client_id"}}
+	ok, err := gtv.IsClaimValid(claim)
+
+	if ok {
+		t.Error("The claim should not have been passed, it is not valid yet. ", err)
+		t.Error(claim)
+	}
+}
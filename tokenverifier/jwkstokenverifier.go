@@ -0,0 +1,92 @@
+package tokenverifier
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A JWKSTokenVerifier verifies the signature of ID tokens locally, using keys
+// published by Google, instead of calling the tokeninfo endpoint for each token.
+type JWKSTokenVerifier struct {
+	// ClientIDs are the Google client IDs (web and mobile) which tokens may be issued for.
+	ClientIDs      []string
+	AllowedDomains []string
+	Keys           *KeySet
+	// Issuers are the accepted values of the iss claim, e.g. when the keys are published
+	// by another OpenID Connect provider. If empty, GoogleIssuers are accepted.
+	Issuers []string
+}
+
+// NewJWKSTokenVerifier creates a JWKSTokenVerifier which retrieves signing keys
+// from jwksURL, e.g. GoogleJWKSURL.
+func NewJWKSTokenVerifier(jwksURL string, clientIDs []string, allowedDomains []string) *JWKSTokenVerifier {
+	return &JWKSTokenVerifier{
+		ClientIDs:      clientIDs,
+		AllowedDomains: allowedDomains,
+		Keys:           NewKeySet(jwksURL),
+	}
+}
+
+// ValidateToken verifies the signature of the ID token and validates its claim
+// using Google's rules.
+func (verifier *JWKSTokenVerifier) ValidateToken(idToken string, nonce string) (claim *Claim, err error) {
+	claim, err = verifier.GetClaim(idToken)
+	if err != nil {
+		return
+	}
+	gtv := GoogleTokenVerifier{
+		ClientIDs:      verifier.ClientIDs,
+		AllowedDomains: verifier.AllowedDomains,
+		Issuers:        verifier.Issuers,
+	}
+	if _, err = gtv.IsClaimValid(claim); err != nil {
+		return
+	}
+	return claim, checkNonce(claim, nonce)
+}
+
+// GetClaim returns the Claim contained within the ID token, once its RS256
+// signature has been verified.
+func (verifier *JWKSTokenVerifier) GetClaim(idToken string) (*Claim, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWKSTokenVerifier: the token is not a JWT")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: failed to decode header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: failed to unmarshal header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("JWKSTokenVerifier: unsupported algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: failed to decode signature: %v", err)
+	}
+	key, err := verifier.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: %v", err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: invalid signature: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("JWKSTokenVerifier: failed to decode payload: %v", err)
+	}
+	return NewClaim(payload)
+}
@@ -0,0 +1,304 @@
+package tokenverifier
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) testKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return testKey{kid: kid, key: key}
+}
+
+func (tk testKey) sign(t *testing.T, claim map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": tk.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claim)
+	if err != nil {
+		t.Fatalf("failed to marshal claim: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tk.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWKSServer serves the public parts of its keys.
+type testJWKSServer struct {
+	m            sync.Mutex
+	keys         []testKey
+	cacheControl string
+	unavailable  bool
+	requests     int
+}
+
+func (s *testJWKSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.requests++
+	if s.unavailable {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range s.keys {
+		jwks.Keys = append(jwks.Keys, map[string]string{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": k.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	w.Header().Set("Cache-Control", s.cacheControl)
+	json.NewEncoder(w).Encode(jwks)
+}
+
+func (s *testJWKSServer) set(f func(s *testJWKSServer)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	f(s)
+}
+
+func validClaim() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "a-h@github.com",
+		"email_verified": true,
+		"hd":             "github.com",
+		"aud":            "the_client_id",
+		"azp":            "the_client_id",
+		"sub":            "110169484474386276334",
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func TestThatJWKSTokensWithValidSignaturesAreAccepted(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "public, max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, []string{"github.com"})
+	claim, err := verifier.ValidateToken(key.sign(t, validClaim()), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validate("email", "a-h@github.com", claim.Email, t)
+	validate("email_verified", "true", claim.EmailVerified, t)
+	validate("sub", "110169484474386276334", claim.Subject, t)
+}
+
+func TestThatJWKSTokensWithInvalidSignaturesAreRejected(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	impostor := newTestKey(t, "key1")
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, nil)
+	if _, err := verifier.ValidateToken(impostor.sign(t, validClaim()), ""); err == nil {
+		t.Error("expected a token signed by another key to be rejected")
+	}
+
+	token := key.sign(t, validClaim())
+	parts := strings.Split(token, ".")
+	tampered := validClaim()
+	tampered["email"] = "someone@github.com"
+	payload, _ := json.Marshal(tampered)
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := verifier.ValidateToken(strings.Join(parts, "."), ""); err == nil {
+		t.Error("expected a token with a modified payload to be rejected")
+	}
+}
+
+func TestThatJWKSTokensWhichFailValidationAreRejected(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, []string{"microsoft.com"})
+	if _, err := verifier.ValidateToken(key.sign(t, validClaim()), ""); err == nil {
+		t.Error("expected a token from a domain which is not allowed to be rejected")
+	}
+}
+
+func TestThatJWKSTokensForOtherClientIDsAreRejected(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, nil)
+	claim := validClaim()
+	claim["aud"] = "another_client_id"
+	claim["azp"] = "another_client_id"
+	if _, err := verifier.ValidateToken(key.sign(t, claim), ""); err == nil {
+		t.Error("expected a token issued for another client ID to be rejected")
+	}
+}
+
+func TestThatJWKSTokensMustMatchTheNonce(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	tests := []struct {
+		name        string
+		tokenNonce  string
+		nonce       string
+		expectedErr error
+	}{
+		{name: "matching nonces are accepted", tokenNonce: "the_nonce", nonce: "the_nonce"},
+		{name: "tokens for other login attempts are rejected", tokenNonce: "another_nonce", nonce: "the_nonce", expectedErr: ErrNonceMismatch},
+		{name: "tokens without a nonce are rejected when one is expected", nonce: "the_nonce", expectedErr: ErrNonceMismatch},
+		{name: "the nonce isn't checked when none is expected", tokenNonce: "the_nonce"},
+	}
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, nil)
+	for _, test := range tests {
+		claim := validClaim()
+		if test.tokenNonce != "" {
+			claim["nonce"] = test.tokenNonce
+		}
+		if _, err := verifier.ValidateToken(key.sign(t, claim), test.nonce); err != test.expectedErr {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+	}
+}
+
+func TestThatKeysAreCachedAccordingToTheCacheControlHeader(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "public, max-age=60, must-revalidate"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	now := time.Now()
+	ks := NewKeySet(s.URL)
+	ks.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Key("key1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if jwks.requests != 1 {
+		t.Errorf("expected the keys to be retrieved once, but were retrieved %d times", jwks.requests)
+	}
+
+	now = now.Add(61 * time.Second)
+	if _, err := ks.Key("key1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if jwks.requests != 2 {
+		t.Errorf("expected the keys to be retrieved again after max-age, but were retrieved %d times", jwks.requests)
+	}
+}
+
+func TestThatKeySetsCanBeCreatedWithoutTheConstructor(t *testing.T) {
+	jwks := &testJWKSServer{keys: []testKey{newTestKey(t, "key1")}}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	ks := &KeySet{URL: s.URL}
+	if _, err := ks.Key("key1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Unknown key IDs don't cause the keys to be retrieved again straight away.
+	if _, err := ks.Key("unknown"); err == nil {
+		t.Errorf("expected an error for an unknown key ID")
+	}
+	if jwks.requests != 1 {
+		t.Errorf("expected the keys to be retrieved once, but were retrieved %d times", jwks.requests)
+	}
+}
+
+func TestThatKeysAreRefreshedWhenAnUnknownKeyIDIsPresented(t *testing.T) {
+	key1 := newTestKey(t, "key1")
+	key2 := newTestKey(t, "key2")
+	jwks := &testJWKSServer{keys: []testKey{key1}, cacheControl: "max-age=3600"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	now := time.Now()
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, nil)
+	verifier.Keys.now = func() time.Time { return now }
+
+	if _, err := verifier.ValidateToken(key1.sign(t, validClaim()), ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Google rotates its keys.
+	jwks.set(func(s *testJWKSServer) { s.keys = []testKey{key2} })
+	now = now.Add(DefaultMinRefreshInterval)
+	if _, err := verifier.ValidateToken(key2.sign(t, validClaim()), ""); err != nil {
+		t.Fatalf("expected the keys to be refreshed, but got error: %v", err)
+	}
+	if jwks.requests != 2 {
+		t.Errorf("expected 2 requests for keys, got %d", jwks.requests)
+	}
+
+	// Unknown key IDs don't cause repeated requests.
+	key3 := newTestKey(t, "key3")
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.ValidateToken(key3.sign(t, validClaim()), ""); err == nil {
+			t.Error("expected an unknown key ID to be rejected")
+		}
+	}
+	if jwks.requests != 2 {
+		t.Errorf("expected no further requests for keys, got %d", jwks.requests)
+	}
+}
+
+func TestThatCachedKeysAreUsedWhenTheEndpointIsUnavailable(t *testing.T) {
+	key := newTestKey(t, "key1")
+	jwks := &testJWKSServer{keys: []testKey{key}, cacheControl: "max-age=60"}
+	s := httptest.NewServer(jwks)
+	defer s.Close()
+
+	now := time.Now()
+	verifier := NewJWKSTokenVerifier(s.URL, []string{"the_client_id"}, nil)
+	verifier.Keys.now = func() time.Time { return now }
+
+	if _, err := verifier.ValidateToken(key.sign(t, validClaim()), ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	jwks.set(func(s *testJWKSServer) { s.unavailable = true })
+	now = now.Add(time.Hour)
+	if _, err := verifier.ValidateToken(key.sign(t, validClaim()), ""); err != nil {
+		t.Errorf("expected the cached key to be used, but got error: %v", err)
+	}
+	if _, err := verifier.ValidateToken(key.sign(t, validClaim()), ""); err != nil {
+		t.Errorf("expected the cached key to be used, but got error: %v", err)
+	}
+	if jwks.requests != 2 {
+		t.Errorf("expected a single retry while the endpoint is unavailable, got %d requests", jwks.requests)
+	}
+}
@@ -0,0 +1,176 @@
+package tokenverifier
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/gauthmiddleware/logger"
+)
+
+const pkg = "github.com/a-h/gauthmiddleware/tokenverifier"
+
+// GoogleJWKSURL is the location of the keys Google uses to sign ID tokens.
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// DefaultMinRefreshInterval is the minimum time between requests for new keys.
+const DefaultMinRefreshInterval = time.Minute
+
+// A KeySet retrieves RSA public keys from a JSON Web Key Set (JWKS) endpoint
+// and caches them for as long as the Cache-Control header allows. A KeySet with
+// only the URL set can be used, but NewKeySet also sets a timeout on the Client.
+type KeySet struct {
+	// URL of the JWKS endpoint, e.g. GoogleJWKSURL.
+	URL string
+	// Client is used to retrieve the keys. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// MinRefreshInterval limits how often the keys are retrieved when an unknown
+	// key ID is presented, or when the endpoint is unavailable. If zero,
+	// DefaultMinRefreshInterval is used.
+	MinRefreshInterval time.Duration
+
+	now         func() time.Time
+	m           sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	expires     time.Time
+	lastAttempt time.Time
+}
+
+// NewKeySet creates a KeySet which retrieves keys from the url.
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		URL:                url,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+		MinRefreshInterval: DefaultMinRefreshInterval,
+		now:                time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID. The keys are retrieved again
+// when the cache has expired, or when the key ID isn't known. If the keys can't
+// be retrieved, previously cached keys continue to be used.
+func (ks *KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	now := time.Now()
+	if ks.now != nil {
+		now = ks.now()
+	}
+	key, ok := ks.keys[kid]
+	if ok && now.Before(ks.expires) {
+		return key, nil
+	}
+	minRefreshInterval := ks.MinRefreshInterval
+	if minRefreshInterval == 0 {
+		minRefreshInterval = DefaultMinRefreshInterval
+	}
+	if now.Sub(ks.lastAttempt) < minRefreshInterval {
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("KeySet: unknown key ID %q", kid)
+	}
+	if err := ks.refresh(now); err != nil {
+		if ok {
+			logger.For(pkg, "Key").WithError(err).Warn("Failed to refresh keys, using cached keys")
+			return key, nil
+		}
+		return nil, fmt.Errorf("KeySet: failed to retrieve keys: %v", err)
+	}
+	if key, ok = ks.keys[kid]; !ok {
+		return nil, fmt.Errorf("KeySet: unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+func (ks *KeySet) refresh(now time.Time) error {
+	ks.lastAttempt = now
+	client := ks.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(ks.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to decode keys: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("failed to parse key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = pk
+	}
+	if len(keys) == 0 {
+		return errors.New("no RSA keys found")
+	}
+	ks.keys = keys
+	ks.expires = now.Add(maxAge(resp.Header.Get("Cache-Control")))
+	return nil
+}
+
+// maxAge returns the max-age directive of a Cache-Control header, or zero if
+// it's not present.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// A jwk is a JSON Web Key, see https://tools.ietf.org/html/rfc7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package tokenverifier
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrTokenReplayed is returned when an ID token which has already been used to log in is
+// presented again.
+var ErrTokenReplayed = errors.New("tokenverifier: the token has already been used")
+
+// DefaultReplayCacheTTL is how long tokens are remembered if their expiry can't be read.
+// Google's ID tokens are valid for an hour.
+const DefaultReplayCacheTTL = time.Hour
+
+// A ReplayCache remembers the ID tokens which have been used to log in until they expire,
+// so that a token captured from a browser can't be used again. Tokens are identified by
+// their JWT ID, or by their hash if they don't have one. The cache is held in memory, so
+// it only protects a single instance.
+type ReplayCache struct {
+	now     func() time.Time
+	m       sync.Mutex
+	used    map[string]time.Time
+	pruneAt time.Time
+}
+
+// NewReplayCache creates an empty ReplayCache.
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		now:  time.Now,
+		used: make(map[string]time.Time),
+	}
+}
+
+// Use records that the token has been used, and returns ErrTokenReplayed if it has been
+// used before. The claim must have been validated first.
+func (rc *ReplayCache) Use(idToken string, claim *Claim) error {
+	key := "jti:" + claim.JWTID
+	if claim.JWTID == "" {
+		hash := sha256.Sum256([]byte(idToken))
+		key = "sha256:" + base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+	now := rc.now()
+	expires := now.Add(DefaultReplayCacheTTL)
+	if exp, err := strconv.ParseInt(claim.Expiry, 10, 64); err == nil {
+		expires = time.Unix(exp, 0)
+	}
+
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	rc.prune(now)
+	if e, ok := rc.used[key]; ok && now.Before(e) {
+		return ErrTokenReplayed
+	}
+	rc.used[key] = expires
+	return nil
+}
+
+// prune removes expired tokens, at most once a minute.
+func (rc *ReplayCache) prune(now time.Time) {
+	if now.Before(rc.pruneAt) {
+		return
+	}
+	for k, expires := range rc.used {
+		if !now.Before(expires) {
+			delete(rc.used, k)
+		}
+	}
+	rc.pruneAt = now.Add(time.Minute)
+}
@@ -0,0 +1,81 @@
+package tokenverifier
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReplayCache(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	expiry := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		uses     []string
+		claims   []*Claim
+		advance  time.Duration
+		expected []error
+	}{
+		{
+			name:     "tokens can be used once",
+			uses:     []string{"token1", "token2"},
+			claims:   []*Claim{{Expiry: expiry}, {Expiry: expiry}},
+			expected: []error{nil, nil},
+		},
+		{
+			name:     "tokens without a JWT ID can't be used twice",
+			uses:     []string{"token1", "token1"},
+			claims:   []*Claim{{Expiry: expiry}, {Expiry: expiry}},
+			expected: []error{nil, ErrTokenReplayed},
+		},
+		{
+			name:     "tokens with the same JWT ID can't be used twice",
+			uses:     []string{"token1", "token2"},
+			claims:   []*Claim{{Expiry: expiry, JWTID: "jti1"}, {Expiry: expiry, JWTID: "jti1"}},
+			expected: []error{nil, ErrTokenReplayed},
+		},
+		{
+			name:     "tokens are forgotten once they expire",
+			uses:     []string{"token1", "token1"},
+			claims:   []*Claim{{Expiry: expiry}, {Expiry: expiry}},
+			advance:  time.Hour,
+			expected: []error{nil, nil},
+		},
+		{
+			name:     "tokens without an expiry are remembered for the default TTL",
+			uses:     []string{"token1", "token1"},
+			claims:   []*Claim{{}, {}},
+			advance:  DefaultReplayCacheTTL - time.Second,
+			expected: []error{nil, ErrTokenReplayed},
+		},
+	}
+
+	for _, test := range tests {
+		rc := NewReplayCache()
+		current := now
+		rc.now = func() time.Time { return current }
+		for i, token := range test.uses {
+			if i > 0 {
+				current = current.Add(test.advance)
+			}
+			if err := rc.Use(token, test.claims[i]); err != test.expected[i] {
+				t.Errorf("%s: use %d: expected %v, got %v", test.name, i, test.expected[i], err)
+			}
+		}
+	}
+}
+
+func TestThatExpiredTokensArePruned(t *testing.T) {
+	now := time.Unix(1433978353, 0)
+	rc := NewReplayCache()
+	rc.now = func() time.Time { return now }
+	for _, token := range []string{"token1", "token2"} {
+		rc.Use(token, &Claim{Expiry: strconv.FormatInt(now.Add(time.Minute).Unix(), 10)})
+	}
+	now = now.Add(2 * time.Minute)
+	rc.Use("token3", &Claim{Expiry: strconv.FormatInt(now.Add(time.Minute).Unix(), 10)})
+	if len(rc.used) != 1 {
+		t.Errorf("expected expired tokens to be pruned, got %v", rc.used)
+	}
+}
@@ -12,6 +12,6 @@ func NewTestTokenVerifier(claimToReturn *Claim, errorToReturn error) *TestTokenV
 }
 
 // ValidateToken validates tokens and returns the claim passed into the creator.
-func (verifier TestTokenVerifier) ValidateToken(idToken string) (claim *Claim, err error) {
+func (verifier TestTokenVerifier) ValidateToken(idToken string, nonce string) (claim *Claim, err error) {
 	return verifier.claimToReturn, verifier.errorToReturn
 }
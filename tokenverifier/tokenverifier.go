@@ -1,6 +1,29 @@
 package tokenverifier
 
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrNonceMismatch is returned when the nonce claim of the ID token doesn't match the
+// nonce of the login attempt.
+var ErrNonceMismatch = errors.New("tokenverifier: the nonce of the token doesn't match the login attempt")
+
 // A TokenVerifier provides a way of validating OAuth ID tokens.
 type TokenVerifier interface {
-	ValidateToken(idToken string) (claim *Claim, err error)
+	// ValidateToken validates the ID token. If nonce is not empty, the token's nonce
+	// claim must match it, which ties the token to the login attempt that requested it.
+	ValidateToken(idToken string, nonce string) (claim *Claim, err error)
+}
+
+// checkNonce returns ErrNonceMismatch if a nonce is expected, and the claim's nonce
+// doesn't match it.
+func checkNonce(claim *Claim, nonce string) error {
+	if nonce == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(claim.Nonce), []byte(nonce)) != 1 {
+		return ErrNonceMismatch
+	}
+	return nil
 }